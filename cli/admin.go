@@ -42,20 +42,57 @@ func (c *Cli) AddClientListCommand(link PipelineLink) {
 
 func (c *Cli) AddNewClientCommand(link PipelineLink) {
 	client := &pipeline.Client{}
+	secret := &secretOptions{}
 	fn := func(...string) (interface{}, error) {
-		return link.NewClient(*client)
+		return newClient(link, *client, *secret)
 	}
 	cmd := newCommandBuilder("create", "Creates a new client").
-		withCall(fn).withTemplate(TmplClient).buildAdmin(c)
+		withCall(fn).withTemplate(TmplClientSecret).buildAdmin(c)
 
 	cmd.AddOption("id", "i", "Client id (must be unique)", "", "", func(string, value string) error {
 		client.Id = value
 		return nil
 	}).Must(true)
 	addClientOptions(cmd, client, true)
+	cmd.AddSwitch("generate-secret", "g", "Generate a random secret instead of giving it with --secret, it's only shown once", func(string, string) error {
+		secret.generate = true
+		return nil
+	})
+	addSecretOptions(cmd, secret)
 
 }
 
+//Creates the client, generating its secret if asked to
+func newClient(link PipelineLink, client pipeline.Client, opts secretOptions) (interface{}, error) {
+	switch {
+	case opts.generate && client.Secret != "":
+		return nil, newError(UsageError, nil, "create: use either --secret or --generate-secret")
+	case opts.generate:
+		if err := opts.check(link.config); err != nil {
+			return nil, err
+		}
+		secret, err := generateSecret()
+		if err != nil {
+			return nil, err
+		}
+		client.Secret = secret
+	case client.Secret == "":
+		return nil, newError(UsageError, nil, "create: the client needs a secret, use --secret or --generate-secret")
+	case opts.file != "" || opts.profile != "":
+		return nil, newError(UsageError, nil, "create: --secret-file and --save-profile are only used with --generate-secret")
+	}
+	created, err := link.NewClient(client)
+	if err != nil {
+		return nil, err
+	}
+	result := clientSecret{}
+	if opts.generate {
+		result = opts.store(link.config, client.Id, client.Secret)
+	}
+	result.Client = created
+	return result, nil
+}
+
 func (c *Cli) AddDeleteClientCommand(link PipelineLink) {
 	newCommandBuilder("remove", "Removes a client").
 		withCall(func(args ...string) (v interface{}, err error) {
@@ -104,12 +141,13 @@ func (c *Cli) AddModifyClientCommand(link PipelineLink) {
 
 }
 
-//Adds the client options a part from the id
-func addClientOptions(cmd *subcommand.Command, client *pipeline.Client, must bool) {
+//Adds the client options a part from the id, requireRole makes --role mandatory
+func addClientOptions(cmd *subcommand.Command, client *pipeline.Client, requireRole bool) {
+	//the secret is never mandatory: create can generate it and modify keeps the old one
 	cmd.AddOption("secret", "s", "Client secret", "", "", func(string, value string) error {
 		client.Secret = value
 		return nil
-	}).Must(must)
+	})
 	cmd.AddOption("role", "r", "Client role  (ADMIN,CLIENTAPP)", "", "",
 		func(string, value string) error {
 			if value != "ADMIN" && value != "CLIENTAPP" {
@@ -117,7 +155,7 @@ func addClientOptions(cmd *subcommand.Command, client *pipeline.Client, must boo
 			}
 			client.Role = value
 			return nil
-		}).Must(must)
+		}).Must(requireRole)
 	cmd.AddOption("contact", "c", "Client e-mail address", "", "",
 		func(string, value string) error {
 			client.Contact = value
@@ -129,7 +167,7 @@ func addClientOptions(cmd *subcommand.Command, client *pipeline.Client, must boo
 				client.Priority = priority
 				return nil
 			} else {
-				return fmt.Errorf("%s is not a valid priority. Allowed values are high, medium and low",
+				return newError(ValidationError, nil, "%s is not a valid priority. Allowed values are high, medium and low",
 					priority)
 			}
 		})
@@ -155,6 +193,9 @@ func (c *Cli) AddSizesCommand(link PipelineLink) {
 			if err != nil {
 				return err
 			}
+			if c.Format != TEXT_FORMAT {
+				return writeDocument(c.Output, c.Format, toDocument(sizes))
+			}
 			if !list {
 				c.Printf("Total %s\n", unitFormatter(sizes.Total))
 			} else {
@@ -0,0 +1,442 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/daisy/pipeline-clientlib-go"
+	"launchpad.net/goyaml"
+)
+
+//Name of the file where the batch state is stored within the output directory
+const BATCH_MANIFEST = "dp2-batch.yml"
+
+//Status of a batch entry that finished correctly
+const BATCH_SUCCESS = "SUCCESS"
+
+//Batch manifest, records the outcome of every input so a batch can be rerun
+type batchManifest struct {
+	Script  string       `yaml:"script"`
+	Entries []batchEntry `yaml:"entries"`
+}
+
+//Outcome of converting a single input
+type batchEntry struct {
+	Input  string `yaml:"input"`           //input path relative to the input dir
+	Output string `yaml:"output"`          //output path relative to the output dir
+	Job    string `yaml:"job,omitempty"`   //job id in the server
+	Status string `yaml:"status"`          //job status or ERROR if the job couldn't be run
+	Error  string `yaml:"error,omitempty"` //error message
+}
+
+//Batch conversion of all the files in a directory tree
+type batchExecution struct {
+	link      *PipelineLink
+	script    string            //script id
+	inputDir  string            //root of the input tree
+	outputDir string            //root of the output tree
+	pattern   string            //pattern that the file names must match
+	parallel  int               //maximum number of jobs running at the same time
+	options   map[string]string //script options as given in the command line
+	priority  string            //job priority
+	force     bool              //do not skip inputs which already succeeded
+}
+
+//Adds the batch command to the cli
+func AddBatchCommand(cli *Cli, link *PipelineLink) {
+	batch := &batchExecution{
+		link:     link,
+		pattern:  "*",
+		parallel: 2,
+		options:  make(map[string]string),
+	}
+	cmd := cli.AddCommand("batch", "Runs a script over all the files in a directory tree", func(command string, args ...string) error {
+		batch.script = args[0]
+		manifest, err := batch.run(cli.Output)
+		if manifest == nil {
+			return err
+		}
+		summary := manifest.summary()
+		fmt.Fprint(cli.Output, summary)
+		if err != nil {
+			return err
+		}
+		if failed := manifest.failed(); failed > 0 {
+			return newError(JobFailedError, nil, "%d of %d inputs failed, see %v", failed, len(manifest.Entries),
+				filepath.Join(batch.outputDir, BATCH_MANIFEST))
+		}
+		return nil
+	})
+	cmd.SetArity(1, "SCRIPT")
+	cmd.AddOption("input-dir", "i", "Directory containing the files to convert", "", italic("DIRECTORY"), func(name, folder string) error {
+		batch.inputDir = folder
+		return nil
+	}).Must(true)
+	cmd.AddOption("output-dir", "o", "Directory where the results are stored, mirroring the input tree", "", italic("DIRECTORY"), func(name, folder string) error {
+		batch.outputDir = folder
+		return nil
+	}).Must(true)
+	cmd.AddOption("pattern", "m", "Only convert the files whose name matches this pattern (default *)", "", italic("PATTERN"), func(name, pattern string) error {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s is not a valid pattern: %v", pattern, err)
+		}
+		batch.pattern = pattern
+		return nil
+	})
+	cmd.AddOption("parallel", "j", "Maximum number of jobs sent to the server at the same time (default 2)", "", italic("INTEGER"), func(name, value string) error {
+		var n int
+		if _, err := fmt.Sscanf(value, "%d", &n); err != nil || n < 1 {
+			return fmt.Errorf("--parallel must be a positive number (found %v)", value)
+		}
+		batch.parallel = n
+		return nil
+	})
+	cmd.AddOption("option", "x", "Script option as NAME=VALUE, can be repeated", "", "NAME=VALUE", func(name, value string) error {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("--option must be NAME=VALUE (found %v)", value)
+		}
+		batch.options[parts[0]] = parts[1]
+		return nil
+	})
+	cmd.AddOption("priority", "r", "Set the jobs' priority", "", "(high|"+underline("medium")+"|low)", func(name, priority string) error {
+		if !checkPriority(priority) {
+			return newError(ValidationError, nil, "%s is not a valid priority. Allowed values are high, medium and low",
+				priority)
+		}
+		batch.priority = priority
+		return nil
+	})
+	cmd.AddSwitch("force", "f", "Convert all the inputs, even the ones that already succeeded in a previous run", func(string, string) error {
+		batch.force = true
+		return nil
+	})
+}
+
+//Runs the batch and stores the manifest. The manifest is returned even if
+//some of the jobs failed
+func (b batchExecution) run(w io.Writer) (*batchManifest, error) {
+	script, err := b.link.Script(b.script)
+	if err != nil {
+		return nil, err
+	}
+	port, err := primaryPort(script)
+	if err != nil {
+		return nil, err
+	}
+	//check the options before sending anything
+	proto := newJobRequest()
+	proto.Script = script.Id
+	proto.Priority = b.priority
+	for name, value := range b.options {
+		option, ok := findOption(script, name)
+		if !ok {
+			return nil, fmt.Errorf("Script %v has no option %v", script.Id, name)
+		}
+		if err := optionFunc(proto, b.link, option.Type, option.Sequence)(name, value); err != nil {
+			return nil, err
+		}
+	}
+	inputs, err := findBatchInputs(b.inputDir, b.pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBatchOutputs(inputs); err != nil {
+		return nil, err
+	}
+	if err := mkdir(b.outputDir); err != nil {
+		return nil, err
+	}
+	manifest, err := loadBatchManifest(b.outputDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Script = script.Id
+
+	pending := []string{}
+	for _, input := range inputs {
+		if entry, ok := manifest.entry(input); ok && entry.Status == BATCH_SUCCESS && !b.force {
+			fmt.Fprintf(w, "Skipping %v (already converted)\n", input)
+			continue
+		}
+		pending = append(pending, input)
+	}
+
+	//bounded worker pool, no more inputs are sent once stop is closed
+	tasks := make(chan string)
+	results := make(chan batchEntry)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < b.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for input := range tasks {
+				results <- b.convert(input, port, *proto)
+			}
+		}()
+	}
+	go func() {
+	feed:
+		for _, input := range pending {
+			select {
+			case tasks <- input:
+			case <-stop:
+				break feed
+			}
+		}
+		close(tasks)
+		wg.Wait()
+		close(results)
+	}()
+	//the results are drained until the running conversions finish
+	var storeErr error
+	for entry := range results {
+		fmt.Fprintf(w, "[%v] %v\n", entry.Status, entry.Input)
+		manifest.update(entry)
+		if storeErr != nil {
+			continue
+		}
+		//store it every time so an interrupted batch can be resumed
+		if storeErr = manifest.store(b.outputDir); storeErr != nil {
+			close(stop)
+		}
+	}
+	return manifest, storeErr
+}
+
+//Path of the results of an input, relative to the output dir
+func batchOutput(input string) string {
+	return strings.TrimSuffix(input, filepath.Ext(input))
+}
+
+//Checks that the results of different inputs, like a.xml and a.html, don't
+//end up in the same folder
+func checkBatchOutputs(inputs []string) error {
+	outputs := make(map[string]string)
+	for _, input := range inputs {
+		output := batchOutput(input)
+		if other, ok := outputs[output]; ok {
+			return newError(ValidationError, nil, "The results of %v and %v would be stored in the same folder %v, use --pattern to select only one of them", other, input, output)
+		}
+		outputs[output] = input
+	}
+	return nil
+}
+
+//Converts a single input and downloads the results to the mirrored output path
+func (b batchExecution) convert(input string, port string, proto JobRequest) (entry batchEntry) {
+	output := batchOutput(input)
+	entry = batchEntry{Input: filepath.ToSlash(input), Output: filepath.ToSlash(output)}
+	fail := func(err error) batchEntry {
+		entry.Status = "ERROR"
+		entry.Error = err.Error()
+		return entry
+	}
+	req := proto
+	req.Nicename = filepath.ToSlash(input)
+	req.Inputs = map[string][]url.URL{}
+	req.Options = make(map[string][]string)
+	for name, values := range proto.Options {
+		//the values of path options are rewritten when packaging
+		req.Options[name] = append([]string{}, values...)
+	}
+	path := filepath.Join(b.inputDir, input)
+	if b.link.IsLocal() {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return fail(err)
+		}
+		u, err := pathToUri(abs, getBasePath(true))
+		if err != nil {
+			return fail(err)
+		}
+		req.Inputs[port] = []url.URL{*u}
+	} else {
+		req.Inputs[port] = []url.URL{url.URL{Opaque: filepath.ToSlash(path)}}
+		if err := req.packageLocalFiles(); err != nil {
+			return fail(err)
+		}
+	}
+	job, messages, err := b.link.Execute(context.Background(), req)
+	if err != nil {
+		return fail(err)
+	}
+	entry.Job = job.Id
+	//the job is removed from the server whatever happens
+	defer func() {
+		if _, err := b.link.Delete(job.Id); err != nil {
+			msg := fmt.Sprintf("Couldn't remove job %v from the server: %v", job.Id, err)
+			if entry.Error != "" {
+				msg = entry.Error + ". " + msg
+			}
+			entry.Error = msg
+		}
+	}()
+	status := job.Status
+	for msg := range messages {
+		if msg.Error != nil {
+			return fail(msg.Error)
+		}
+		if msg.Status != "" {
+			status = msg.Status
+		}
+	}
+	entry.Status = status
+	if status != "ERROR" {
+		wc, err := zipProcessor(filepath.Join(b.outputDir, output), false, defaultExtractOptions)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := b.link.Results(job.Id, wc); err != nil {
+			wc.Abort()
+			return fail(err)
+		}
+		if err := wc.Close(); err != nil {
+			return fail(err)
+		}
+	}
+	return entry
+}
+
+//The primary port is the first required input of the script, or the first
+//input if none is required
+func primaryPort(script pipeline.Script) (string, error) {
+	if len(script.Inputs) == 0 {
+		return "", fmt.Errorf("Script %v has no inputs", script.Id)
+	}
+	for _, input := range script.Inputs {
+		if input.Required {
+			return input.Name, nil
+		}
+	}
+	return script.Inputs[0].Name, nil
+}
+
+//Finds the option definition in the script
+func findOption(script pipeline.Script, name string) (pipeline.Option, bool) {
+	for _, option := range script.Options {
+		if option.Name == name {
+			return option, true
+		}
+	}
+	return pipeline.Option{}, false
+}
+
+//Walks the input dir and returns the paths, relative to it, of the files
+//whose name matches the pattern
+func findBatchInputs(root, pattern string) (inputs []string, err error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a directory", root)
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if ok, _ := filepath.Match(pattern, info.Name()); !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, rel)
+		return nil
+	})
+	if err == nil && len(inputs) == 0 {
+		err = fmt.Errorf("No files matching %v found in %v", pattern, root)
+	}
+	return
+}
+
+//Loads the manifest from the output dir, an empty one is returned if the
+//file doesn't exist
+func loadBatchManifest(folder string) (*batchManifest, error) {
+	manifest := &batchManifest{}
+	data, err := ioutil.ReadFile(filepath.Join(folder, BATCH_MANIFEST))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := goyaml.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("Error reading %v: %v", BATCH_MANIFEST, err)
+	}
+	return manifest, nil
+}
+
+//Stores the manifest in the output dir
+func (m *batchManifest) store(folder string) error {
+	sort.Slice(m.Entries, func(i, j int) bool {
+		return m.Entries[i].Input < m.Entries[j].Input
+	})
+	data, err := goyaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(folder, BATCH_MANIFEST), data, 0644)
+}
+
+func (m batchManifest) entry(input string) (batchEntry, bool) {
+	input = filepath.ToSlash(input)
+	for _, e := range m.Entries {
+		if e.Input == input {
+			return e, true
+		}
+	}
+	return batchEntry{}, false
+}
+
+//Adds or replaces the entry for the same input
+func (m *batchManifest) update(entry batchEntry) {
+	for idx, e := range m.Entries {
+		if e.Input == entry.Input {
+			m.Entries[idx] = entry
+			return
+		}
+	}
+	m.Entries = append(m.Entries, entry)
+}
+
+func (m batchManifest) failed() (n int) {
+	for _, e := range m.Entries {
+		if e.Status != BATCH_SUCCESS {
+			n++
+		}
+	}
+	return
+}
+
+//Returns a human readable summary of the batch
+func (m batchManifest) summary() string {
+	if len(m.Entries) == 0 {
+		return "Nothing to convert\n"
+	}
+	failed := m.failed()
+	str := fmt.Sprintf("\nBatch finished: %d succeeded, %d failed\n", len(m.Entries)-failed, failed)
+	for _, e := range m.Entries {
+		if e.Status != BATCH_SUCCESS {
+			str += fmt.Sprintf("\t%v [%v]", e.Input, e.Status)
+			if e.Error != "" {
+				str += " " + e.Error
+			}
+			str += "\n"
+		}
+	}
+	return str
+}
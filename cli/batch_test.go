@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//creates an input tree with a couple of xml files and a txt one
+func createBatchTree(t *testing.T) string {
+	root, err := ioutil.TempDir("", "dp2_batch_in")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	files := []string{"book1.xml", filepath.Join("sub", "book2.xml"), "notes.txt"}
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		if err := mkdir(filepath.Dir(path)); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("<dtbook/>"), 0644); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	return root
+}
+
+func TestFindBatchInputs(t *testing.T) {
+	root := createBatchTree(t)
+	defer os.RemoveAll(root)
+	inputs, err := findBatchInputs(root, "*.xml")
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if len(inputs) != 2 || inputs[0] != "book1.xml" || inputs[1] != filepath.Join("sub", "book2.xml") {
+		t.Errorf("Wrong inputs %v", inputs)
+	}
+	_, err = findBatchInputs(root, "*.html")
+	if err == nil {
+		t.Errorf("Expected error not thrown when nothing matches")
+	}
+}
+
+func TestPrimaryPort(t *testing.T) {
+	port, err := primaryPort(SCRIPT)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if port != "single" {
+		t.Errorf("The first input should be the primary port, got %v", port)
+	}
+	script := pipeline.Script{Id: "required", Inputs: []pipeline.Input{
+		pipeline.Input{Name: "optional"},
+		pipeline.Input{Name: "source", Required: true},
+	}}
+	if port, _ := primaryPort(script); port != "source" {
+		t.Errorf("The first required input should be the primary port, got %v", port)
+	}
+	if _, err := primaryPort(pipeline.Script{Id: "empty"}); err == nil {
+		t.Errorf("Expected error not thrown for a script without inputs")
+	}
+}
+
+func TestBatchManifest(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_batch_manifest")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	manifest, err := loadBatchManifest(folder)
+	if err != nil || len(manifest.Entries) != 0 {
+		t.Errorf("A missing manifest should be empty (%v)", err)
+	}
+	manifest.Script = "test"
+	manifest.update(batchEntry{Input: "b.xml", Status: "ERROR", Error: "boom"})
+	manifest.update(batchEntry{Input: "a.xml", Status: BATCH_SUCCESS})
+	manifest.update(batchEntry{Input: "b.xml", Status: BATCH_SUCCESS})
+	if err := manifest.store(folder); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	loaded, err := loadBatchManifest(folder)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if loaded.Script != "test" || len(loaded.Entries) != 2 || loaded.Entries[0].Input != "a.xml" {
+		t.Errorf("Manifest not loaded correctly %+v", loaded)
+	}
+	if loaded.failed() != 0 {
+		t.Errorf("Updated entries should replace the old ones")
+	}
+}
+
+func TestBatchCommand(t *testing.T) {
+	root := createBatchTree(t)
+	defer os.RemoveAll(root)
+	out, err := ioutil.TempDir("", "dp2_batch_out")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(out)
+
+	conf := copyConf()
+	conf[STARTING] = false
+	pipe := newPipelineTest(false)
+	link := &PipelineLink{pipeline: pipe, config: conf}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	w := overrideOutput(cli)
+	AddBatchCommand(cli, link)
+	err = cli.Run([]string{"batch", "-i", root, "-o", out, "-m", "*.xml", "-j", "1", "test"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if !strings.Contains(w.String(), "2 succeeded, 0 failed") {
+		t.Errorf("Wrong summary %q", w.String())
+	}
+	manifest, err := loadBatchManifest(out)
+	if err != nil || len(manifest.Entries) != 2 {
+		t.Errorf("Manifest not stored (%v) %+v", err, manifest)
+	}
+
+	//rerunning skips everything
+	w.Reset()
+	err = cli.Run([]string{"batch", "-i", root, "-o", out, "-m", "*.xml", "test"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if strings.Count(w.String(), "Skipping") != 2 {
+		t.Errorf("Converted inputs weren't skipped %q", w.String())
+	}
+}
+
+func TestBatchCommandUnknownOption(t *testing.T) {
+	root := createBatchTree(t)
+	defer os.RemoveAll(root)
+	conf := copyConf()
+	conf[STARTING] = false
+	link := &PipelineLink{pipeline: newPipelineTest(false), config: conf}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	AddBatchCommand(cli, link)
+	err = cli.Run([]string{"batch", "-i", root, "-o", root, "-x", "not-an-option=1", "test"})
+	if err == nil || !strings.Contains(err.Error(), "not-an-option") {
+		t.Errorf("Expected error not thrown (%v)", err)
+	}
+}
+
+//Runs the batch of the input tree with the pipeline, returns the output dir
+func runBatch(t *testing.T, pipe *PipelineTest, root string, prepare func(out string)) (string, error) {
+	out, err := ioutil.TempDir("", "dp2_batch_out")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if prepare != nil {
+		prepare(out)
+	}
+	conf := copyConf()
+	conf[STARTING] = false
+	conf[POLLFAILURES] = 0
+	link := &PipelineLink{pipeline: pipe, config: conf}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	AddBatchCommand(cli, link)
+	return out, cli.Run([]string{"batch", "-i", root, "-o", out, "-m", "*.xml", "-j", "1", "test"})
+}
+
+func TestBatchDeletesErroredJobs(t *testing.T) {
+	root := createBatchTree(t)
+	defer os.RemoveAll(root)
+	pipe := newPipelineTest(false)
+	pipe.job = func(string, int) (pipeline.Job, error) {
+		return pipeline.Job{}, errors.New("Job not found")
+	}
+	deleted := 0
+	pipe.delete = func(string) (bool, error) {
+		deleted++
+		return true, nil
+	}
+	out, err := runBatch(t, pipe, root, nil)
+	defer os.RemoveAll(out)
+	if err == nil {
+		t.Errorf("The failures weren't reported")
+	}
+	if deleted != 2 {
+		t.Errorf("%v jobs deleted instead of 2", deleted)
+	}
+}
+
+func TestBatchManifestStoreError(t *testing.T) {
+	root := createBatchTree(t)
+	defer os.RemoveAll(root)
+	done := make(chan error)
+	go func() {
+		//the manifest can't be written over a directory
+		out, err := runBatch(t, newPipelineTest(false), root, func(out string) {
+			os.Mkdir(filepath.Join(out, BATCH_MANIFEST), 0755)
+		})
+		os.RemoveAll(out)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("The error storing the manifest wasn't reported")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("The batch didn't finish")
+	}
+}
+
+func TestBatchOutputCollision(t *testing.T) {
+	if err := checkBatchOutputs([]string{"a.xml", filepath.Join("sub", "a.xml")}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	err := checkBatchOutputs([]string{"a.html", "a.xml"})
+	if ExitCode(err) != 6 || !strings.Contains(err.Error(), "a.html and a.xml") {
+		t.Errorf("Wrong error %v", err)
+	}
+}
@@ -3,14 +3,13 @@ package cli
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"strconv"
 	"strings"
 	"text/template"
 	"regexp"
 
 	"github.com/bertfrees/go-subcommand"
+	"github.com/daisy/pipeline-clientlib-go"
 )
 
 const (
@@ -102,6 +101,17 @@ type Cli struct {
 	StaticCommands []*subcommand.Command //commands which are always present
 	AdminCommands  []*subcommand.Command //admin commands
 	Output         io.Writer             //writer where to dump the output
+	Format         string                //output format (text, json or yaml)
+	command        string                //name of the command being run
+	args           []string              //command line being run
+	rawCommands    map[string]bool       //commands that parse the arguments that follow their parameter
+	rawArgs        []string              //arguments that follow the parameter of a raw command
+	offline        map[string]bool       //commands that don't need the webservice
+	scriptUsers    map[string]bool       //non script commands that need the scripts loaded
+	jobIdCommands  map[string]bool       //commands whose argument is a job id
+	flagTypes      map[string]map[string]pipeline.DataType //types of the script flags by command
+	overrides      Config                //configuration values set in the command line
+	base           Config                //configuration before applying the profile
 }
 
 //Script commands have a job request associated
@@ -113,8 +123,15 @@ type ScriptCommand struct {
 //Creates a new CLI with a name and pipeline link to perform queries
 func NewCli(name string, link *PipelineLink) (cli *Cli, err error) {
 	cli = &Cli{
-		Parser: subcommand.NewParser(name),
-		Output: os.Stdout,
+		Parser:    subcommand.NewParser(name),
+		Output:    os.Stdout,
+		Format:    TEXT_FORMAT,
+		offline:     make(map[string]bool),
+		scriptUsers: make(map[string]bool),
+		jobIdCommands: make(map[string]bool),
+		rawCommands: make(map[string]bool),
+		flagTypes:   make(map[string]map[string]pipeline.DataType),
+		overrides:   make(Config),
 	}
 	//set the help command
 	cli.setHelp()
@@ -122,15 +139,24 @@ func NewCli(name string, link *PipelineLink) (cli *Cli, err error) {
 	//initialise the link so we take into account the
 	//global configuration flags
 	cli.PostFlags(func() error {
+		if err = cli.applyLayers(link.config); err != nil {
+			return err
+		}
+		if cli.offline[cli.command] {
+			return nil
+		}
 		if err = link.Init(); err != nil {
 			return err
 		}
-		scripts, err := link.Scripts()
-		if err != nil {
-			fmt.Printf("Error loading scripts:\n\t%v\n", err)
-			os.Exit(-1)
+		if cli.needsScripts(cli.command) {
+			scripts, err := link.Scripts()
+			if err != nil {
+				return fmt.Errorf("Error loading scripts: %w", err)
+			}
+			if err := cli.AddScripts(scripts, link); err != nil {
+				return err
+			}
 		}
-		cli.AddScripts(scripts, link)
 		if !link.IsLocal() {
 			//it we are not in local mode we need to send the data
 			for _, cmd := range cli.Scripts {
@@ -143,6 +169,8 @@ func NewCli(name string, link *PipelineLink) (cli *Cli, err error) {
 	})
 	//add config flags
 	cli.addConfigOptions(link.config)
+	cli.addLoggingOptions(link.config)
+	cli.addFormatOption()
 	return
 }
 
@@ -166,46 +194,120 @@ func (c *Cli) setHelp() {
 		details = true
 		return nil
 	})
+	c.setScriptUser(cmd.Name)
 }
 
 //Adds the configuration global options to the parser
 func (c *Cli) addConfigOptions(conf Config) {
 	for option, desc := range config_descriptions {
+		if _, ok := loggingOptions[option]; ok {
+			continue
+		}
 		c.AddOption(option, "", fmt.Sprintf("%v (default %v)", desc, conf[option]), "", "", func(optName string, value string) error {
-			log.Println("option:", optName, "value:", value)
-			switch conf[optName].(type) {
-			case int:
-				val, err := strconv.Atoi(value)
-				if err != nil {
-					return fmt.Errorf("option %v must be a numeric value (found %v)", optName, value)
-				}
-				conf[optName] = val
-			case bool:
-				switch {
-				case value == "true":
-					conf[optName] = true
-				case value == "false":
-					conf[optName] = false
-				default:
-					return fmt.Errorf("option %v must be true or false (found %v)", optName, value)
-				}
-
-			case string:
-				conf[optName] = value
-
+			logDebug("option: %v value: %v", optName, value)
+			if err := conf.set(optName, value, ORIGIN_FLAG); err != nil {
+				return err
 			}
+			c.overrides[optName] = conf[optName]
 			conf.UpdateDebug()
 			return nil
 		})
 	}
 	//alternative configuration file
 	c.AddOption("file", "f", "Alternative configuration file", "", "", func(string, filePath string) error {
-		file, err := os.Open(filePath)
-		if err != nil {
-			log.Printf(err.Error())
+		if _, err := os.Stat(filePath); err != nil {
+			logDebug("%v", err)
 			return fmt.Errorf("File not found %v", filePath)
 		}
-		return conf.FromYaml(file)
+		return conf.FromFile(filePath)
+	})
+}
+
+//Applies the selected profile to the configuration, then the environment
+//variables and the command line values are applied again as they take
+//precedence over the profile and the configuration files
+func (c *Cli) applyLayers(conf Config) error {
+	if conf == nil {
+		return nil
+	}
+	if c.base == nil {
+		c.base = conf.copy()
+	}
+	name, _ := conf[PROFILE].(string)
+	return c.layer(conf, name)
+}
+
+func (c *Cli) layer(conf Config, name string) error {
+	if err := conf.UseProfile(name); err != nil {
+		return err
+	}
+	if err := conf.FromEnv(); err != nil {
+		return err
+	}
+	for key, value := range c.overrides {
+		conf[key] = value
+		conf.setOrigin(key, ORIGIN_FLAG)
+	}
+	conf.UpdateDebug()
+	return nil
+}
+
+//Returns the configuration that the profile would give, built from the
+//configuration before the active profile was applied. The logging keeps
+//the settings of the active configuration
+func (c *Cli) profileConfig(active Config, name string) (Config, error) {
+	base := c.base
+	if base == nil {
+		base = active
+	}
+	conf := base.copy()
+	defer active.UpdateDebug()
+	if err := c.layer(conf, name); err != nil {
+		return nil, err
+	}
+	conf[PROFILE] = name
+	return conf, nil
+}
+
+//Logging configuration items, they have their own options with friendlier names
+var loggingOptions = map[string]string{
+	LOGLEVEL:  "log-level",
+	LOGFILE:   "log-file",
+	TRACEHTTP: "trace-http",
+}
+
+//Adds the logging global options to the parser
+func (c *Cli) addLoggingOptions(conf Config) {
+	set := func(key, value string) error {
+		if err := conf.set(key, value, ORIGIN_FLAG); err != nil {
+			return err
+		}
+		c.overrides[key] = conf[key]
+		conf.UpdateDebug()
+		return nil
+	}
+	c.AddOption(loggingOptions[LOGLEVEL], "", fmt.Sprintf("%v (default %v)", config_descriptions[LOGLEVEL], conf[LOGLEVEL]), "", "(error|warn|info|debug)", func(name, level string) error {
+		if _, err := parseLogLevel(level); err != nil {
+			return err
+		}
+		return set(LOGLEVEL, strings.ToLower(level))
+	})
+	c.AddOption(loggingOptions[LOGFILE], "", "File where the log is appended instead of stderr", "", "FILE", func(name, path string) error {
+		return set(LOGFILE, path)
+	})
+	c.AddSwitch(loggingOptions[TRACEHTTP], "", "Log the http requests and responses, the signatures are hidden", func(string, string) error {
+		return set(TRACEHTTP, "true")
+	})
+}
+
+//Adds the output format global option to the parser
+func (c *Cli) addFormatOption() {
+	c.AddOption("format", "", "Output format: text, json or yaml (default text)", "", "(text|json|yaml)", func(name, format string) error {
+		if !checkFormat(format) {
+			return fmt.Errorf("%s is not a valid format. Allowed values are text, json and yaml", format)
+		}
+		c.Format = format
+		return nil
 	})
 }
 
@@ -223,6 +325,12 @@ func (c *Cli) AddCommand(name, desc string, fn func(string, ...string) error) *s
 	return cmd
 }
 
+//Marks the command as offline, the webservice won't be contacted
+//before running it
+func (c *Cli) setOffline(name string) {
+	c.offline[name] = true
+}
+
 //Adds admin related commands to the cli and keeps track of it for displaying help
 func (c *Cli) AddAdminCommand(name, desc string, fn func(string, ...string) error) *subcommand.Command {
 	cmd := c.Parser.AddCommand(name, desc, "", fn)
@@ -252,12 +360,111 @@ func flagsToStrings(flags []subcommand.Flag) []string {
 	return names
 }
 
+//Marks the command as parsing itself the arguments that follow its parameter,
+//e.g. options that depend on the parameter value. They are found in rawArgs
+func (c *Cli) setRawArgs(name string) {
+	c.rawCommands[name] = true
+}
+
+//Marks a command which isn't a script as needing the scripts, e.g. to list them
+func (c *Cli) setScriptUser(name string) {
+	c.scriptUsers[name] = true
+}
+
+//Returns true if the scripts have to be loaded to run the command, that is when
+//it's not one of the general or admin commands (it must be a script) or when it uses them
+func (c *Cli) needsScripts(name string) bool {
+	_, registered := c.Parser.Commands[name]
+	return !registered || c.scriptUsers[name]
+}
+
 //Runs the client
 func (c *Cli) Run(args []string) error {
+	c.args = args
+	c.rawArgs = nil
+	idx := c.commandIndex(args)
+	args = expandRequest(args, idx)
+	if idx >= 0 && c.rawCommands[args[idx]] && idx+2 < len(args) {
+		c.rawArgs = args[idx+2:]
+		args = args[:idx+2]
+	}
+	if idx >= 0 {
+		c.command = args[idx]
+		args = c.moveSwitches(args, idx)
+	} else {
+		c.command = ""
+	}
 	_, err := c.Parser.Parse(args)
 	return err
 }
 
+//Returns the position of the command that the arguments will run, skipping
+//the global options and their values, or -1 if there is none
+func (c *Cli) commandIndex(args []string) int {
+	options := optionNames(c.Flags())
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return i
+		}
+		if options[args[i]] {
+			i++
+		}
+	}
+	return -1
+}
+
+//The parser ignores the flags found after the command parameters, the switches
+//of the command placed after its parameters (e.g. config show --origin) are moved
+//right after the command name
+func (c *Cli) moveSwitches(args []string, idx int) []string {
+	cmd, ok := c.Parser.Commands[args[idx]]
+	if !ok {
+		return args
+	}
+	options := optionNames(cmd.Flags())
+	switches := make(map[string]bool)
+	for _, flag := range cmd.Flags() {
+		if flag.Type == subcommand.Switch {
+			switches["--"+flag.Long] = true
+			if flag.Short != "" {
+				switches["-"+flag.Short] = true
+			}
+		}
+	}
+	moved := []string{}
+	rest := []string{}
+	params := false
+	for i := idx + 1; i < len(args); i++ {
+		switch {
+		case params && switches[args[i]]:
+			moved = append(moved, args[i])
+		case options[args[i]] && i+1 < len(args):
+			rest = append(rest, args[i], args[i+1])
+			i++
+		default:
+			params = params || !strings.HasPrefix(args[i], "-")
+			rest = append(rest, args[i])
+		}
+	}
+	res := append([]string{}, args[:idx+1]...)
+	res = append(res, moved...)
+	return append(res, rest...)
+}
+
+//Returns the long and short names of the options (flags with values)
+func optionNames(flags []subcommand.Flag) map[string]bool {
+	options := make(map[string]bool)
+	for _, flag := range flags {
+		if flag.Type == subcommand.Option {
+			options["--"+flag.Long] = true
+			if flag.Short != "" {
+				options["-"+flag.Short] = true
+			}
+		}
+	}
+	return options
+}
+
 //Prints using the client output
 func (c *Cli) Printf(format string, vals ...interface{}) {
 	fmt.Fprintf(c.Output, format, vals...)
@@ -287,7 +494,7 @@ func printHelp(cli Cli, globals, admin, details bool, args ...string) error {
 		}
 		cmd, ok := cli.Parser.Commands[args[0]]
 		if !ok {
-			return fmt.Errorf("help: command %v not found ", args[0])
+			return newError(UsageError, nil, "help: command %v not found ", args[0])
 		}
 		if len(args) == 1 {
 			funcMap := template.FuncMap{
@@ -314,7 +521,7 @@ func printHelp(cli Cli, globals, admin, details bool, args ...string) error {
 					return nil
 				}
 			}
-			return fmt.Errorf("help: %v option %v not found ", cmd.Name, args[1])
+			return newError(UsageError, nil, "help: %v option %v not found ", cmd.Name, args[1])
 		}
 	}
 	return nil
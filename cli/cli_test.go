@@ -4,7 +4,9 @@ import (
 	//"github.com/bertfrees/go-subcommand"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/daisy/pipeline-clientlib-go"
@@ -269,8 +271,16 @@ func TestConfigOptions(t *testing.T) {
 		CLIENTKEY:    "rounded",
 		CLIENTSECRET: "he_likes_justin_beiber",
 		TIMEOUT:      3,
+		RETRIES:      1,
+		POLLFAILURES: 2,
 		DEBUG:        true,
+		LOGLEVEL:     "info",
+		LOGFILE:      filepath.Join(os.TempDir(), "dp2-test.log"),
+		TRACEHTTP:    true,
 		STARTING:     true,
+		PROFILE:      "",
+		SECRETFILE:   "",
+		SECRETCMD:    "",
 	}
 
 	err = cli.Run([]string{"--" + HOST, exp[HOST].(string),
@@ -281,15 +291,25 @@ func TestConfigOptions(t *testing.T) {
 		"--" + CLIENTKEY, exp[CLIENTKEY].(string),
 		"--" + CLIENTSECRET, exp[CLIENTSECRET].(string),
 		"--" + TIMEOUT, strconv.Itoa(exp[TIMEOUT].(int)),
+		"--" + RETRIES, strconv.Itoa(exp[RETRIES].(int)),
+		"--" + POLLFAILURES, strconv.Itoa(exp[POLLFAILURES].(int)),
 		"--" + DEBUG, strconv.FormatBool(true),
+		"--log-level", "INFO",
+		"--log-file", exp[LOGFILE].(string),
+		"--trace-http",
 		"--" + STARTING, strconv.FormatBool(true),
 		"help",
 	})
+	defer os.Remove(exp[LOGFILE].(string))
+	defer logs.configure(LogWarn, "", false)
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
 
 	for k := range res {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
 		if res[k] != exp[k] {
 			t.Errorf("Config item not set %v\n Expected: %v\nResult: %v", k, res[k], exp[k])
 		}
@@ -344,3 +364,54 @@ func TestConfigFile(t *testing.T) {
 	tCompareCnfs(res, EXP, t)
 
 }
+
+func TestCommandIndex(t *testing.T) {
+	link := &PipelineLink{pipeline: newPipelineTest(false), config: copyConf()}
+	cli, err := makeCli("testprog", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	tests := []struct {
+		args []string
+		idx  int
+	}{
+		{[]string{"jobs"}, 0},
+		{[]string{"--" + HOST, "http://localhost", "--debug", "true", "queue", "-x"}, 4},
+		{[]string{"--format", "json"}, -1},
+	}
+	for _, test := range tests {
+		if idx := cli.commandIndex(test.args); idx != test.idx {
+			t.Errorf("Wrong command index for %v: %v", test.args, idx)
+		}
+	}
+}
+
+func TestMoveSwitches(t *testing.T) {
+	link := &PipelineLink{pipeline: newPipelineTest(false), config: copyConf()}
+	cli, err := makeCli("testprog", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cmd := cli.AddCommand("cmd", "", func(string, ...string) error { return nil })
+	cmd.AddSwitch("sw", "s", "", func(string, string) error { return nil })
+	cmd.AddOption("opt", "o", "", "", "", func(string, string) error { return nil })
+	args := []string{"--debug", "false", "cmd", "-o", "val", "param", "--sw", "other"}
+	exp := []string{"--debug", "false", "cmd", "--sw", "-o", "val", "param", "other"}
+	res := cli.moveSwitches(args, 2)
+	if strings.Join(res, " ") != strings.Join(exp, " ") {
+		t.Errorf("Switches not moved\nexpected: %v\nresult:   %v", exp, res)
+	}
+}
+
+//An unknown profile makes the cli fail before contacting the webservice
+func TestProfileNotFound(t *testing.T) {
+	link := &PipelineLink{pipeline: newPipelineTest(false), config: copyConf()}
+	cli, err := makeCli("testprog", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	err = cli.Run([]string{"--" + PROFILE, "nope", "help"})
+	if err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("Expected error not thrown %v", err)
+	}
+}
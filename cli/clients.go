@@ -0,0 +1,413 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/daisy/pipeline-clientlib-go"
+	"launchpad.net/goyaml"
+)
+
+const (
+	CLIENT_CREATE = "create"
+	CLIENT_MODIFY = "modify"
+	CLIENT_DELETE = "delete"
+
+	TmplClientsPlan = `{{range .Changes}}{{.Describe}}
+{{else}}The clients are up to date
+{{end}}{{if .Changes}}{{.Summary}}
+{{end}}`
+)
+
+//Columns of the csv client files, the first line of the file must name them
+var clientColumns = []string{"id", "role", "contact", "priority", "secret"}
+
+//Client as written in the client files. The secret is a reference to where
+//it is kept: env:VARIABLE, file:PATH or command:COMMAND
+type clientEntry struct {
+	Id       string `json:"id" yaml:"id"`
+	Role     string `json:"role" yaml:"role"`
+	Contact  string `json:"contact,omitempty" yaml:"contact,omitempty"`
+	Priority string `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Secret   string `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+//Change needed to make a client of the webservice match the file
+type clientChange struct {
+	Action string   `json:"action" yaml:"action"` //create, modify or delete
+	Id     string   `json:"id" yaml:"id"`
+	Fields []string `json:"fields,omitempty" yaml:"fields,omitempty"` //what changes, secrets are never shown
+	client pipeline.Client
+}
+
+func (c clientChange) Describe() string {
+	sign := map[string]string{CLIENT_CREATE: "+", CLIENT_MODIFY: "~", CLIENT_DELETE: "-"}[c.Action]
+	if len(c.Fields) == 0 {
+		return sign + " " + c.Id
+	}
+	return fmt.Sprintf("%v %v (%v)", sign, c.Id, strings.Join(c.Fields, ", "))
+}
+
+//Changes needed to make the clients of the webservice match the file
+type clientsPlan struct {
+	Changes []clientChange `json:"changes" yaml:"changes"`
+	DryRun  bool           `json:"dry_run" yaml:"dry_run"`
+}
+
+func (p clientsPlan) count(action string) (n int) {
+	for _, change := range p.Changes {
+		if change.Action == action {
+			n++
+		}
+	}
+	return
+}
+
+func (p clientsPlan) Summary() string {
+	summary := fmt.Sprintf("%v to create, %v to modify and %v to delete", p.count(CLIENT_CREATE), p.count(CLIENT_MODIFY), p.count(CLIENT_DELETE))
+	if p.DryRun {
+		summary += ", nothing was changed (--dry-run)"
+	}
+	return summary
+}
+
+//Adds the clients command, it exports the clients to a file or makes them
+//match the ones in a file
+func (c *Cli) AddClientsCommand(link PipelineLink) {
+	dryRun, prune, resetSecrets := false, false, false
+	cmd := c.AddAdminCommand("clients", "Exports the clients to a csv or yaml file or imports them from it", func(command string, args ...string) error {
+		switch args[0] {
+		case "export":
+			return exportClients(c, link, args[1])
+		case "import":
+			return importClients(c, link, args[1], dryRun, prune, resetSecrets)
+		}
+		return newError(UsageError, nil, "clients: wrong action %v, expected export or import", args[0])
+	})
+	cmd.SetArity(2, "(export|import) FILE")
+	cmd.AddSwitch("dry-run", "d", "Only print the changes the import would make", func(string, string) error {
+		dryRun = true
+		return nil
+	})
+	cmd.AddSwitch("prune", "", "Delete the clients that aren't in the file when importing", func(string, string) error {
+		prune = true
+		return nil
+	})
+	cmd.AddSwitch("reset-secrets", "", "Replace the secrets of the existing clients with the ones of the file when importing, otherwise they are only used to create clients", func(string, string) error {
+		resetSecrets = true
+		return nil
+	})
+}
+
+//Writes the clients of the webservice, without their secrets
+func exportClients(c *Cli, link PipelineLink, path string) error {
+	clients, err := link.Clients()
+	if err != nil {
+		return err
+	}
+	entries := make([]clientEntry, 0, len(clients))
+	for _, client := range clients {
+		entries = append(entries, clientEntry{Id: client.Id, Role: client.Role, Contact: client.Contact, Priority: client.Priority})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Id < entries[j].Id })
+	if err := storeClientFile(path, entries); err != nil {
+		return err
+	}
+	c.Printf("%v clients exported to %v\n", len(entries), path)
+	return nil
+}
+
+//Prints the changes needed to match the file and applies them
+func importClients(c *Cli, link PipelineLink, path string, dryRun, prune, resetSecrets bool) error {
+	entries, err := loadClientFile(path)
+	if err != nil {
+		return err
+	}
+	current, err := link.Clients()
+	if err != nil {
+		return err
+	}
+	self, _ := link.config[CLIENTKEY].(string)
+	plan, err := planClients(entries, current, filepath.Dir(path), prune, resetSecrets, self)
+	if err != nil {
+		return err
+	}
+	if err := keepClientSecrets(link, plan); err != nil {
+		return err
+	}
+	plan.DryRun = dryRun
+	if err := (commandBuilder{template: TmplClientsPlan}).writeOutput(plan, c); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	for i, change := range plan.Changes {
+		if err := applyClientChange(link, change); err != nil {
+			return fmt.Errorf("Could not %v the client %v, %v of %v changes were applied: %w", change.Action, change.Id, i, len(plan.Changes), err)
+		}
+	}
+	if len(plan.Changes) > 0 && c.Format == TEXT_FORMAT {
+		c.Printf("The clients have been updated\n")
+	}
+	return nil
+}
+
+//The clients that are modified without resetting their secret are sent with the
+//secret they have, which isn't in the list of clients but in the details of
+//each client, the way modify does
+func keepClientSecrets(link PipelineLink, plan clientsPlan) error {
+	for i, change := range plan.Changes {
+		if change.Action != CLIENT_MODIFY || change.client.Secret != "" {
+			continue
+		}
+		old, err := link.Client(change.Id)
+		if err != nil {
+			return err
+		}
+		if old.Secret == "" {
+			return newError(ValidationError, nil, "The webservice doesn't give the secret of the client %v back, use --reset-secrets to set it", change.Id)
+		}
+		plan.Changes[i].client.Secret = old.Secret
+	}
+	return nil
+}
+
+func applyClientChange(link PipelineLink, change clientChange) (err error) {
+	switch change.Action {
+	case CLIENT_CREATE:
+		_, err = link.NewClient(change.client)
+	case CLIENT_MODIFY:
+		_, err = link.ModifyClient(change.client, change.Id)
+	case CLIENT_DELETE:
+		_, err = link.DeleteClient(change.Id)
+	}
+	return
+}
+
+//Compares the file with the clients of the webservice. The clients of the file
+//are created or modified in the order of the file, the fields left empty keep
+//their current value. The webservice doesn't give the secrets back so the ones
+//of the existing clients are only replaced when resetSecrets is set. The client
+//in use (self) is never deleted
+func planClients(entries []clientEntry, current []pipeline.Client, base string, prune, resetSecrets bool, self string) (plan clientsPlan, err error) {
+	existing := make(map[string]pipeline.Client)
+	for _, client := range current {
+		existing[client.Id] = client
+	}
+	listed := make(map[string]bool)
+	for _, entry := range entries {
+		if err = entry.validate(); err != nil {
+			return
+		}
+		if listed[entry.Id] {
+			return plan, newError(ValidationError, nil, "The client %v is listed twice", entry.Id)
+		}
+		listed[entry.Id] = true
+		old, ok := existing[entry.Id]
+		if ok && entry.Secret != "" && !resetSecrets {
+			logInfo("Keeping the secret of the client %v, use --reset-secrets to replace it", entry.Id)
+			entry.Secret = ""
+		}
+		secret, err := resolveClientSecret(entry, base)
+		if err != nil {
+			return plan, err
+		}
+		if !ok {
+			if entry.Role == "" {
+				return plan, newError(ValidationError, nil, "The new client %v needs a role, ADMIN or CLIENTAPP", entry.Id)
+			}
+			if secret == "" {
+				return plan, newError(ValidationError, nil, "The new client %v needs a secret", entry.Id)
+			}
+			client := pipeline.Client{Id: entry.Id, Role: entry.Role, Contact: entry.Contact, Priority: entry.Priority, Secret: secret}
+			fields := []string{"role " + entry.Role}
+			if entry.Contact != "" {
+				fields = append(fields, "contact "+entry.Contact)
+			}
+			if entry.Priority != "" {
+				fields = append(fields, "priority "+entry.Priority)
+			}
+			plan.Changes = append(plan.Changes, clientChange{Action: CLIENT_CREATE, Id: entry.Id, Fields: fields, client: client})
+			continue
+		}
+		client, fields := old, []string{}
+		//the list of clients can't be trusted for the secret
+		client.Secret = ""
+		update := func(name string, field *string, value string) {
+			if value != "" && value != *field {
+				fields = append(fields, fmt.Sprintf("%v %v -> %v", name, *field, value))
+				*field = value
+			}
+		}
+		update("role", &client.Role, entry.Role)
+		update("contact", &client.Contact, entry.Contact)
+		update("priority", &client.Priority, entry.Priority)
+		if secret != "" {
+			fields = append(fields, "secret reset")
+			client.Secret = secret
+		}
+		if len(fields) > 0 {
+			plan.Changes = append(plan.Changes, clientChange{Action: CLIENT_MODIFY, Id: entry.Id, Fields: fields, client: client})
+		}
+	}
+	if !prune {
+		return
+	}
+	ids := []string{}
+	for id := range existing {
+		if !listed[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if id == self {
+			logWarn("The client %v isn't in the file but it's not deleted as it's the one in use", id)
+			continue
+		}
+		plan.Changes = append(plan.Changes, clientChange{Action: CLIENT_DELETE, Id: id})
+	}
+	return
+}
+
+func (e clientEntry) validate() error {
+	if e.Id == "" {
+		return newError(ValidationError, nil, "There is a client without id")
+	}
+	//the role is only required to create the client
+	if e.Role != "" && e.Role != "ADMIN" && e.Role != "CLIENTAPP" {
+		return newError(ValidationError, nil, "%v is not a valid role for the client %v, expected ADMIN or CLIENTAPP", e.Role, e.Id)
+	}
+	if e.Priority != "" && !checkPriority(e.Priority) {
+		return newError(ValidationError, nil, "%s is not a valid priority for the client %v. Allowed values are high, medium and low", e.Priority, e.Id)
+	}
+	return nil
+}
+
+//Returns the secret the reference points to, relative files are resolved
+//against base. The secrets themselves aren't accepted so they don't end up in
+//the client files
+func resolveClientSecret(entry clientEntry, base string) (string, error) {
+	if entry.Secret == "" {
+		return "", nil
+	}
+	kind, value := entry.Secret, ""
+	if i := strings.Index(entry.Secret, ":"); i >= 0 {
+		kind, value = entry.Secret[:i], entry.Secret[i+1:]
+	}
+	var secret string
+	switch kind {
+	case "env":
+		secret = os.Getenv(value)
+		if secret == "" {
+			return "", newError(NotFoundError, nil, "The variable %v with the secret of the client %v is not set", value, entry.Id)
+		}
+	case "file":
+		if !filepath.IsAbs(value) {
+			value = filepath.Join(base, value)
+		}
+		data, err := ioutil.ReadFile(value)
+		if err != nil {
+			return "", newError(NotFoundError, err, "Could not read the secret of the client %v from %v", entry.Id, value)
+		}
+		secret = strings.TrimSpace(string(data))
+	case "command":
+		out, err := secretFromCommand(value)
+		if err != nil {
+			return "", newError(GenericError, err, "Could not get the secret of the client %v: %v", entry.Id, err)
+		}
+		secret = out
+	default:
+		return "", newError(ValidationError, nil, "The secret of the client %v must be a reference: env:VARIABLE, file:PATH or command:COMMAND", entry.Id)
+	}
+	if secret == "" {
+		return "", newError(ValidationError, nil, "The secret of the client %v is empty", entry.Id)
+	}
+	return secret, nil
+}
+
+//Whether the file is csv, otherwise it's yaml
+func isCsvFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+//Reads the clients from a csv or yaml file
+func loadClientFile(path string) ([]clientEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, newError(NotFoundError, err, "Could not read the client file %v", path)
+	}
+	entries := []clientEntry{}
+	if !isCsvFile(path) {
+		if err := goyaml.Unmarshal(data, &entries); err != nil {
+			return nil, newError(ValidationError, err, "Error parsing the client file %v: %v", path, err)
+		}
+		return entries, nil
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, newError(ValidationError, err, "Error parsing the client file %v: %v", path, err)
+	}
+	if len(records) == 0 {
+		return entries, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return nil, newError(ValidationError, nil, "The first line of %v must name the columns: %v", path, strings.Join(clientColumns, ","))
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	for _, record := range records[1:] {
+		entries = append(entries, clientEntry{
+			Id:       field(record, "id"),
+			Role:     field(record, "role"),
+			Contact:  field(record, "contact"),
+			Priority: field(record, "priority"),
+			Secret:   field(record, "secret"),
+		})
+	}
+	return entries, nil
+}
+
+//Writes the clients as csv or yaml depending on the extension of the file
+func storeClientFile(path string, entries []clientEntry) error {
+	var data []byte
+	if isCsvFile(path) {
+		buf := new(bytes.Buffer)
+		if err := writeClientsCsv(buf, entries); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	} else {
+		var err error
+		if data, err = goyaml.Marshal(entries); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func writeClientsCsv(w io.Writer, entries []clientEntry) error {
+	out := csv.NewWriter(w)
+	out.Write(clientColumns)
+	for _, entry := range entries {
+		out.Write([]string{entry.Id, entry.Role, entry.Contact, entry.Priority, entry.Secret})
+	}
+	out.Flush()
+	return out.Error()
+}
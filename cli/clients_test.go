@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Pipeline that keeps the clients in memory
+type clientStore struct {
+	*PipelineTest
+	clients map[string]pipeline.Client
+	calls   *[]string
+}
+
+func newClientStore(clients ...pipeline.Client) clientStore {
+	store := clientStore{newPipelineTest(false), make(map[string]pipeline.Client), &[]string{}}
+	for _, client := range clients {
+		store.clients[client.Id] = client
+	}
+	return store
+}
+
+func (s clientStore) Clients() (clients []pipeline.Client, err error) {
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	return
+}
+
+func (s clientStore) Client(id string) (pipeline.Client, error) {
+	client, ok := s.clients[id]
+	if !ok {
+		return client, errors.New("Client with id " + id + " not found")
+	}
+	return client, nil
+}
+
+func (s clientStore) NewClient(client pipeline.Client) (pipeline.Client, error) {
+	*s.calls = append(*s.calls, "create "+client.Id)
+	s.clients[client.Id] = client
+	return client, nil
+}
+
+func (s clientStore) ModifyClient(client pipeline.Client, id string) (pipeline.Client, error) {
+	*s.calls = append(*s.calls, "modify "+id)
+	if _, ok := s.clients[id]; !ok {
+		return client, errors.New("Client with id " + id + " not found")
+	}
+	s.clients[id] = client
+	return client, nil
+}
+
+func (s clientStore) DeleteClient(id string) (bool, error) {
+	*s.calls = append(*s.calls, "delete "+id)
+	delete(s.clients, id)
+	return true, nil
+}
+
+func makeClientsCli(store clientStore, t *testing.T) *Cli {
+	link := PipelineLink{pipeline: store, config: Config{CLIENTKEY: "admin"}}
+	cli, err := NewCli("test", &link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cli.AddClientsCommand(link)
+	return cli
+}
+
+//Writes the file in a temporary directory
+func clientFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func testClients() []pipeline.Client {
+	return []pipeline.Client{
+		{Id: "admin", Role: "ADMIN", Secret: "adminsecret"},
+		{Id: "library", Role: "CLIENTAPP", Contact: "old@library.org", Priority: "low", Secret: "libsecret"},
+		{Id: "old", Role: "CLIENTAPP", Secret: "oldsecret"},
+	}
+}
+
+func TestClientsImport(t *testing.T) {
+	os.Setenv("DP2_TEST_SECRET", "newsecret")
+	defer os.Unsetenv("DP2_TEST_SECRET")
+	path, clean := clientFile(t, "clients.csv", `id,role,contact,priority,secret
+library,CLIENTAPP,books@library.org,high,
+school,CLIENTAPP,it@school.org,,env:DP2_TEST_SECRET
+`)
+	defer clean()
+	store := newClientStore(testClients()...)
+	cli := makeClientsCli(store, t)
+	w := overrideOutput(cli)
+	log, restore := captureLog(LogWarn, false)
+	defer restore()
+	if err := cli.Run([]string{"clients", "--prune", "import", path}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(log.String(), "The client admin isn't in the file") {
+		t.Errorf("The client in use not reported %q", log.String())
+	}
+	out := w.String()
+	exp := []string{
+		"~ library (contact old@library.org -> books@library.org, priority low -> high)",
+		"+ school (role CLIENTAPP, contact it@school.org)",
+		"- old",
+		"1 to create, 1 to modify and 1 to delete",
+		"The clients have been updated",
+	}
+	for _, line := range exp {
+		if !strings.Contains(out, line) {
+			t.Errorf("%q not found in\n%v", line, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Secrets shown\n%v", out)
+	}
+	if _, ok := store.clients["admin"]; !ok {
+		t.Errorf("The client in use was deleted")
+	}
+	if _, ok := store.clients["old"]; ok {
+		t.Errorf("The client wasn't pruned")
+	}
+	if school := store.clients["school"]; school.Secret != "newsecret" {
+		t.Errorf("Wrong secret %+v", school)
+	}
+	if library := store.clients["library"]; library.Secret != "libsecret" || library.Priority != "high" || library.Role != "CLIENTAPP" {
+		t.Errorf("Wrong client %+v", library)
+	}
+}
+
+func TestClientsImportDryRun(t *testing.T) {
+	path, clean := clientFile(t, "clients.yml", `- id: library
+  role: ADMIN
+- id: school
+  role: CLIENTAPP
+  secret: file:school.secret
+`)
+	defer clean()
+	ioutil.WriteFile(filepath.Join(filepath.Dir(path), "school.secret"), []byte("filesecret\n"), 0600)
+	store := newClientStore(testClients()...)
+	cli := makeClientsCli(store, t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"clients", "--dry-run", "import", path}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(*store.calls) != 0 {
+		t.Errorf("Changes applied %v", *store.calls)
+	}
+	out := w.String()
+	if !strings.Contains(out, "~ library (role CLIENTAPP -> ADMIN)") || !strings.Contains(out, "nothing was changed (--dry-run)") {
+		t.Errorf("Wrong output\n%v", out)
+	}
+	if strings.Contains(out, "- old") {
+		t.Errorf("Deleted without --prune\n%v", out)
+	}
+}
+
+func TestClientsImportSecrets(t *testing.T) {
+	os.Setenv("DP2_TEST_SECRET", "newsecret")
+	defer os.Unsetenv("DP2_TEST_SECRET")
+	//the role of existing clients may be left empty
+	path, clean := clientFile(t, "clients.csv", `id,role,contact,priority,secret
+library,,,,env:DP2_TEST_SECRET
+`)
+	defer clean()
+	store := newClientStore(testClients()...)
+	cli := makeClientsCli(store, t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"clients", "import", path}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(*store.calls) != 0 || !strings.Contains(w.String(), "The clients are up to date") {
+		t.Errorf("Secret changed without --reset-secrets %v\n%v", *store.calls, w.String())
+	}
+	w = overrideOutput(cli)
+	if err := cli.Run([]string{"clients", "--reset-secrets", "import", path}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(w.String(), "~ library (secret reset)") {
+		t.Errorf("Wrong output\n%v", w.String())
+	}
+	if library := store.clients["library"]; library.Secret != "newsecret" || library.Role != "CLIENTAPP" || library.Contact != "old@library.org" {
+		t.Errorf("Wrong client %+v", library)
+	}
+}
+
+func TestClientsImportJson(t *testing.T) {
+	path, clean := clientFile(t, "clients.yml", "- id: library\n  role: CLIENTAPP\n  contact: old@library.org\n  priority: low\n")
+	defer clean()
+	store := newClientStore(testClients()...)
+	cli := makeClientsCli(store, t)
+	w := overrideOutput(cli)
+	_, restore := captureLog(LogWarn, false)
+	defer restore()
+	if err := cli.Run([]string{"--format", "json", "clients", "--prune", "import", path}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	plan := clientsPlan{}
+	if err := json.Unmarshal(w.Bytes(), &plan); err != nil {
+		t.Fatalf("Not json %v\n%v", err, w.String())
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Action != CLIENT_DELETE || plan.Changes[0].Id != "old" {
+		t.Errorf("Wrong plan %+v", plan)
+	}
+}
+
+func TestClientsImportErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		exp     string
+	}{
+		{"id,role\nschool,CLIENTAPP\n", "needs a secret"},
+		{"id,role\nschool,USER\n", "not a valid role"},
+		{"id,role,secret\nschool,,env:HOME\n", "needs a role"},
+		{"id,role,priority\nlibrary,CLIENTAPP,urgent\n", "not a valid priority"},
+		{"id,role\nlibrary,CLIENTAPP\nlibrary,ADMIN\n", "listed twice"},
+		{"id,role,secret\nschool,CLIENTAPP,plainsecret\n", "must be a reference"},
+		{"role,secret\nCLIENTAPP,env:HOME\n", "must name the columns"},
+	}
+	for _, test := range tests {
+		path, clean := clientFile(t, "clients.csv", test.content)
+		store := newClientStore(testClients()...)
+		cli := makeClientsCli(store, t)
+		overrideOutput(cli)
+		err := cli.Run([]string{"clients", "import", path})
+		if err == nil || !strings.Contains(err.Error(), test.exp) || ExitCode(err) != 6 {
+			t.Errorf("%q: wrong error %v", test.content, err)
+		}
+		if len(*store.calls) != 0 {
+			t.Errorf("%q: changes applied %v", test.content, *store.calls)
+		}
+		clean()
+	}
+}
+
+func TestClientsExport(t *testing.T) {
+	for _, name := range []string{"clients.csv", "clients.yaml"} {
+		path, clean := clientFile(t, name, "")
+		cli := makeClientsCli(newClientStore(testClients()...), t)
+		w := overrideOutput(cli)
+		if err := cli.Run([]string{"clients", "export", path}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !strings.Contains(w.String(), "3 clients exported") {
+			t.Errorf("Wrong output %q", w.String())
+		}
+		data, _ := ioutil.ReadFile(path)
+		if strings.Contains(string(data), "secret:") || strings.Contains(string(data), "libsecret") {
+			t.Errorf("Secrets exported\n%s", data)
+		}
+		entries, err := loadClientFile(path)
+		if err != nil || len(entries) != 3 {
+			t.Fatalf("Wrong export %v %v", entries, err)
+		}
+		exp := clientEntry{Id: "library", Role: "CLIENTAPP", Contact: "old@library.org", Priority: "low"}
+		if entries[1] != exp {
+			t.Errorf("%v: %+v != %+v", name, entries[1], exp)
+		}
+		//importing the export changes nothing
+		store := newClientStore(testClients()...)
+		cli = makeClientsCli(store, t)
+		w = overrideOutput(cli)
+		if err := cli.Run([]string{"clients", "--prune", "import", path}); err != nil || len(*store.calls) != 0 {
+			t.Errorf("%v: changes applied %v %v", name, *store.calls, err)
+		}
+		if !strings.Contains(w.String(), "The clients are up to date") {
+			t.Errorf("Wrong output %q", w.String())
+		}
+		clean()
+	}
+}
+
+//Pipeline whose list of clients doesn't have the secrets
+type clientsWithoutSecrets struct {
+	clientStore
+	hidden bool //the details don't have them either
+}
+
+func (s clientsWithoutSecrets) Clients() (clients []pipeline.Client, err error) {
+	clients, err = s.clientStore.Clients()
+	for i := range clients {
+		clients[i].Secret = ""
+	}
+	return
+}
+
+func (s clientsWithoutSecrets) Client(id string) (pipeline.Client, error) {
+	client, err := s.clientStore.Client(id)
+	if s.hidden {
+		client.Secret = ""
+	}
+	return client, err
+}
+
+func TestClientsImportKeepsSecrets(t *testing.T) {
+	path, clean := clientFile(t, "clients.csv", "id,role,contact\nlibrary,ADMIN,new@library.org\n")
+	defer clean()
+	for _, hidden := range []bool{false, true} {
+		store := newClientStore(testClients()...)
+		link := PipelineLink{pipeline: clientsWithoutSecrets{store, hidden}, config: Config{CLIENTKEY: "admin"}}
+		cli, err := NewCli("test", &link)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		cli.AddClientsCommand(link)
+		overrideOutput(cli)
+		err = cli.Run([]string{"clients", "import", path})
+		if hidden {
+			if ExitCode(err) != 6 || len(*store.calls) != 0 {
+				t.Errorf("Client modified without its secret %v %v", err, *store.calls)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if library := store.clients["library"]; library.Secret != "libsecret" || library.Role != "ADMIN" {
+			t.Errorf("Wrong client %+v", library)
+		}
+	}
+}
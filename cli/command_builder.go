@@ -62,7 +62,12 @@ func (c *commandBuilder) buildAdmin(cli *Cli) (cmd *subcommand.Command) {
 	})
 }
 
+//Writes the data using the template or, if a machine-readable format was
+//requested, serialises it as a document
 func (c commandBuilder) writeOutput(data interface{}, cli *Cli) error {
+	if data != nil && cli.Format != TEXT_FORMAT {
+		return writeDocument(cli.Output, cli.Format, toDocument(data))
+	}
 	funcs := template.FuncMap{
 		"printAsPercentage": func(val float64) string {
 			return fmt.Sprintf("%.1f%%", val * 100)
@@ -80,9 +85,9 @@ func (c commandBuilder) writeOutput(data interface{}, cli *Cli) error {
 
 //Builds a command and configures it to expect a job id
 func (c *commandBuilder) buildWithId(cli *Cli) (cmd *subcommand.Command) {
-	lastId := new(bool)
+	sel := new(idSelector)
 	cmd = cli.AddCommand(c.name, c.desc, func(command string, args ...string) error {
-		id, err := checkId(*lastId, command, args...)
+		id, err := checkId(*sel, command, args...)
 		if err != nil {
 			return err
 		}
@@ -93,6 +98,7 @@ func (c *commandBuilder) buildWithId(cli *Cli) (cmd *subcommand.Command) {
 		return c.writeOutput(data, cli)
 	})
 
-	addLastId(cmd, lastId)
+	addLastId(cmd, sel)
+	cli.jobIdCommands[cmd.Name] = true
 	return
 }
@@ -1,8 +1,12 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/daisy/pipeline-clientlib-go"
@@ -86,20 +90,14 @@ func AddDeleteCommand(cli *Cli, link PipelineLink) {
 func AddResultsCommand(cli *Cli, link PipelineLink) {
 	outputPath := ""
 	zipped := false
+	opts := defaultExtractOptions
 	cmd := newCommandBuilder("results", "Stores the results from a job").
 		withCall(func(args ...string) (v interface{}, err error) {
 
-		wc, err := zipProcessor(outputPath, zipped)
+		ok, err := downloadResults(link, args[0], outputPath, zipped, opts, os.Stderr)
 		if err != nil {
 			return
 		}
-		ok, err := link.Results(args[0], wc)
-		if err != nil {
-			return
-		}
-		if err = wc.Close(); err != nil {
-			return
-		}
 
 		var extra string
 		if zipped {
@@ -120,6 +118,98 @@ func AddResultsCommand(cli *Cli, link PipelineLink) {
 		zipped = true
 		return nil
 	}).Must(false)
+	addExtractOptions(cmd, &opts)
+}
+
+//Stores the job results into the output folder or zip file
+//Progress is reported to the progress writer if not nil. If the download fails or is
+//interrupted the partial results are removed
+func downloadResults(link PipelineLink, id, outputPath string, zipped bool, opts extractOptions, progress io.Writer) (ok bool, err error) {
+	wc, err := zipProcessor(outputPath, zipped, opts)
+	if err != nil {
+		return
+	}
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	pw := newProgressWriter(wc, progress, interrupt)
+	ok, err = link.Results(id, pw)
+	pw.done()
+	if err != nil || !ok {
+		wc.Abort()
+		if err != nil && pw.written > 0 {
+			err = fmt.Errorf("Error downloading the results, partial data removed: %v", err)
+		}
+		return
+	}
+	if err = wc.Close(); err != nil {
+		err = fmt.Errorf("Error storing the results: %v", err)
+	}
+	return
+}
+
+func AddWatchCommand(cli *Cli, link PipelineLink) {
+	outputPath := ""
+	zipped := false
+	verbose := true
+	opts := defaultExtractOptions
+	fn := func(args ...string) (interface{}, error) {
+		id := args[0]
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		//the messages would break the machine-readable output
+		out := cli.Output
+		if cli.Format != TEXT_FORMAT {
+			out = os.Stderr
+		}
+		status, err := followMessages(out, link.Watch(ctx, id), verbose, interrupt)
+		signal.Stop(interrupt)
+		if err == errInterrupted {
+			return fmt.Sprintf("\nStopped watching job %v, it is still in the server\n", id), nil
+		} else if err != nil {
+			return nil, err
+		}
+		recordJobStatus(id, status)
+		msg := fmt.Sprintf("\nJob finished with status: %v\n", status)
+		if outputPath != "" && status != "ERROR" {
+			ok, err := downloadResults(link, id, outputPath, zipped, opts, out)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				msg += fmt.Sprintf("Results stored into %v\n", outputPath)
+			} else {
+				msg += "No results available\n"
+			}
+		}
+		if err := jobStatusError(id, status); err != nil {
+			if err := (commandBuilder{template: SimpleTemplate}).writeOutput(msg, cli); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		return msg, nil
+	}
+	cmd := newCommandBuilder("watch", "Follows the messages and progress of a job until it finishes").
+		withCall(fn).buildWithId(cli)
+	cmd.AddOption("output", "o", "Store the results into this directory once the job is finished", "", "DIRECTORY", func(name, folder string) error {
+		outputPath = folder
+		return nil
+	})
+	cmd.AddSwitch("zipped", "z", "Store the results into a zipfile rather than to folder", func(string, string) error {
+		zipped = true
+		return nil
+	})
+	cmd.AddSwitch("quiet", "q", "Do not print the job's messages", func(string, string) error {
+		verbose = false
+		return nil
+	})
+	addExtractOptions(cmd, &opts)
 }
 
 func AddLogCommand(cli *Cli, link PipelineLink) {
@@ -157,7 +247,7 @@ func AddHaltCommand(cli *Cli, link PipelineLink) {
 	fn := func(...string) (val interface{}, err error) {
 		key, err := loadKey()
 		if err != nil {
-			return nil, fmt.Errorf("Coudn't open key file: %s", err.Error())
+			return nil, err
 		}
 		err = link.Halt(key)
 		if err != nil {
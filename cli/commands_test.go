@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -435,6 +436,68 @@ func TestResultsCommand(t *testing.T) {
 
 }
 
+//Tests that watch follows the job and downloads the results once it's finished
+func TestWatchCommand(t *testing.T) {
+	data := createZipFile(t)
+	cli, link, _ := makeReturningCli(data, t)
+	r := overrideOutput(cli)
+	AddWatchCommand(cli, link)
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	err = cli.Run([]string{"watch", "-o", dir, "job1"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if getCall(link) != RESULTS_CALL {
+		t.Errorf("results weren't downloaded")
+	}
+	msg := r.String()
+	if !strings.Contains(msg, "Message 2") {
+		t.Errorf("Job messages not printed %q", msg)
+	}
+	if !strings.Contains(msg, "Job finished with status: SUCCESS") {
+		t.Errorf("Final status not printed %q", msg)
+	}
+	if !strings.Contains(msg, fmt.Sprintf("Results stored into %v\n", dir)) {
+		t.Errorf("Results message not printed %q", msg)
+	}
+}
+
+//Tests that watch only prints the document with --format json
+func TestWatchCommandJson(t *testing.T) {
+	cli, link, _ := makeReturningCli(nil, t)
+	r := overrideOutput(cli)
+	AddWatchCommand(cli, link)
+	err := cli.Run([]string{"--format", "json", "watch", "job1"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	doc := textDocument{}
+	if err := json.Unmarshal(r.Bytes(), &doc); err != nil {
+		t.Fatalf("Not json %v %q", err, r.String())
+	}
+	if doc.Message != "Job finished with status: SUCCESS" {
+		t.Errorf("Wrong document %+v", doc)
+	}
+}
+
+//Tests that watch doesn't download anything without --output
+func TestWatchCommandNoOutput(t *testing.T) {
+	cli, link, _ := makeReturningCli(nil, t)
+	overrideOutput(cli)
+	AddWatchCommand(cli, link)
+	err := cli.Run([]string{"watch", "-q", "job1"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if getCall(link) != JOB_CALL {
+		t.Errorf("watch shouldn't call anything but job %v", getCall(link))
+	}
+}
+
 //Checks that results link entry has been called and data has been stored
 func TestResultsCommandToZip(t *testing.T) {
 	data := createZipFile(t)
@@ -496,6 +559,45 @@ func TestResultsCommandError(t *testing.T) {
 
 }
 
+//Checks that a failed download doesn't leave a partial zip file
+func TestResultsCommandErrorCleanup(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_results")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	cli, link, pipe := makeReturningCli(createZipFile(t), t)
+	pipe.failOnCall = RESULTS_CALL
+	AddResultsCommand(cli, link)
+	err = cli.Run([]string{"results", "-z", "-o", filepath.Join(folder, "results.zip"), "id"})
+	if err == nil {
+		t.Errorf("Expected ws error not returned ")
+	}
+	if entries, _ := ioutil.ReadDir(folder); len(entries) != 0 {
+		t.Errorf("Partial results not removed %v", entries)
+	}
+}
+
+//Checks the overwrite policy of the zipped results
+func TestResultsCommandOverwrite(t *testing.T) {
+	file, err := ioutil.TempFile("", "dp2_results")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	cli, link, _ := makeReturningCli(createZipFile(t), t)
+	AddResultsCommand(cli, link)
+	err = cli.Run([]string{"results", "-z", "--overwrite", "never", "-o", file.Name(), "id"})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected error not thrown %v", err)
+	}
+	err = cli.Run([]string{"results", "-z", "--overwrite", "sometimes", "-o", file.Name(), "id"})
+	if err == nil {
+		t.Errorf("Expected error not thrown for an invalid policy")
+	}
+}
+
 //test the list of jobs
 func TestJobs(t *testing.T) {
 	jobs := pipeline.Jobs{Jobs: []pipeline.Job{JOB_1, JOB_2}}
@@ -0,0 +1,313 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/bertfrees/go-subcommand"
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+const JOBS_CACHE_FILE = "jobs"
+
+//File with the ids of the known jobs, one per line, so the shells can complete
+//them without contacting the webservice. An empty path disables it
+var JobsCachePath = filepath.Join(filepath.Dir(LastIdPath), JOBS_CACHE_FILE)
+
+//Values of the generic options, the script options get them from their type
+var optionCompletions = map[string]completionFlag{
+	"priority":   completionFlag{Values: []string{"high", "medium", "low"}},
+	"format":     completionFlag{Values: []string{TEXT_FORMAT, JSON_FORMAT, YAML_FORMAT}},
+	"overwrite":  completionFlag{Values: []string{OVERWRITE_NEVER, OVERWRITE_ALWAYS, OVERWRITE_NEWER}},
+	"output":     completionFlag{Dirs: true},
+	"output-dir": completionFlag{Dirs: true},
+	"input-dir":  completionFlag{Dirs: true},
+	"data":       completionFlag{Files: true},
+	"save-job":   completionFlag{Files: true},
+	"file":       completionFlag{Files: true},
+	"debug":      completionFlag{Values: []string{"true", "false"}},
+	"starting":   completionFlag{Values: []string{"true", "false"}},
+}
+
+//Flag as seen by the completion scripts
+type completionFlag struct {
+	Long   string
+	Short  string
+	Desc   string
+	Switch bool
+	Values []string //values to complete
+	Files  bool     //the value is a file
+	Dirs   bool     //the value is a directory
+}
+
+//Command as seen by the completion scripts
+type completionCommand struct {
+	Name   string
+	Desc   string
+	Flags  []completionFlag
+	Args   []string //values of the parameter
+	JobIds bool     //the parameter is a job id
+}
+
+//Everything the completion scripts need
+type completionModel struct {
+	Program  string
+	Globals  []completionFlag
+	Commands []completionCommand
+	JobsFile string
+}
+
+//Names of the commands
+func (m completionModel) CommandNames() []string {
+	names := make([]string, len(m.Commands))
+	for idx, cmd := range m.Commands {
+		names[idx] = cmd.Name
+	}
+	return names
+}
+
+//Adds the completion command. It doesn't start the webservice, the scripts and
+//their options are the ones in the scripts cache
+func AddCompletionCommand(cli *Cli, link *PipelineLink) {
+	cmd := cli.AddCommand("completion", "Prints the shell completion script for bash, zsh or fish", func(name string, args ...string) error {
+		tmpl, ok := completionTemplates[args[0]]
+		if !ok {
+			return fmt.Errorf("completion: %v is not a supported shell, use bash, zsh or fish", args[0])
+		}
+		if scripts, ok := storedScripts(ScriptsCachePath, link.config.Url()); ok {
+			if err := cli.AddScripts(scripts, link); err != nil {
+				return err
+			}
+		} else {
+			logInfo("No scripts cached for %v, run %v scripts to complete them", link.config.Url(), cli.Name)
+		}
+		return writeCompletion(cli, tmpl)
+	})
+	cmd.SetArity(1, "(bash|zsh|fish)")
+	cli.setOffline(cmd.Name)
+}
+
+func writeCompletion(cli *Cli, tmpl string) error {
+	funcs := template.FuncMap{
+		"join":  strings.Join,
+		"quote": func(s string) string { return "'" + strings.Replace(s, "'", `'\''`, -1) + "'" },
+		"funcName": func(s string) string {
+			return strings.NewReplacer("-", "_", ".", "_").Replace(s)
+		},
+		"words":     completionWords,
+		"valueOpts": valueOptions,
+	}
+	t := template.Must(template.New("completion").Funcs(funcs).Parse(tmpl))
+	return t.Execute(cli.Output, completionData(cli))
+}
+
+//Builds the completion model from the cli
+func completionData(cli *Cli) completionModel {
+	model := completionModel{Program: cli.Name, JobsFile: JobsCachePath}
+	model.Globals = completionFlags(cli.Flags(), nil)
+	names := make([]string, 0, len(cli.Parser.Commands))
+	for name := range cli.Parser.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	shells := make([]string, 0, len(completionTemplates))
+	for shell := range completionTemplates {
+		shells = append(shells, shell)
+	}
+	sort.Strings(shells)
+	args := map[string][]string{"completion": shells, "help": names, "server": serverActions, "clients": {"export", "import"}}
+	for _, name := range names {
+		cmd := cli.Parser.Commands[name]
+		model.Commands = append(model.Commands, completionCommand{
+			Name:   name,
+			Desc:   firstLine(uncolor(cmd.ShortDesc)),
+			Flags:  completionFlags(cmd.Flags(), cli.flagTypes[name]),
+			Args:   args[name],
+			JobIds: cli.jobIdCommands[name],
+		})
+	}
+	return model
+}
+
+func completionFlags(flags []subcommand.Flag, types map[string]pipeline.DataType) []completionFlag {
+	res := make([]completionFlag, 0, len(flags))
+	for _, flag := range flags {
+		comp, ok := optionCompletions[flag.Long]
+		if dataType, isScript := types[flag.Long]; isScript {
+			comp = typeCompletion(dataType)
+		} else if !ok {
+			comp = completionFlag{}
+		}
+		comp.Long = flag.Long
+		comp.Short = flag.Short
+		comp.Desc = firstLine(uncolor(flag.ShortDesc))
+		comp.Switch = flag.Type == subcommand.Switch
+		res = append(res, comp)
+	}
+	return res
+}
+
+//Completion of the values of a script option
+func typeCompletion(dataType pipeline.DataType) completionFlag {
+	switch t := dataType.(type) {
+	case pipeline.AnyFileURI:
+		return completionFlag{Files: true}
+	case pipeline.AnyDirURI:
+		return completionFlag{Dirs: true}
+	case pipeline.XsBoolean:
+		return completionFlag{Values: []string{"true", "false"}}
+	case pipeline.Choice:
+		comp := completionFlag{}
+		for _, value := range t.Values {
+			comp.Values = append(comp.Values, typeCompletion(value).Values...)
+		}
+		return comp
+	case pipeline.Value:
+		if t.Value != "" {
+			return completionFlag{Values: []string{t.Value}}
+		}
+	}
+	return completionFlag{}
+}
+
+//Flag names as typed in the command line
+func completionWords(flags []completionFlag) string {
+	words := []string{}
+	for _, flag := range flags {
+		words = append(words, "--"+flag.Long)
+		if flag.Short != "" {
+			words = append(words, "-"+flag.Short)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+//Flags that take a value
+func valueOptions(flags []completionFlag) []completionFlag {
+	res := []completionFlag{}
+	for _, flag := range flags {
+		if !flag.Switch {
+			res = append(res, flag)
+		}
+	}
+	return res
+}
+
+//Stores the ids of the jobs in the jobs file
+func storeJobIds(path string, ids []string) error {
+	if path == "" {
+		return nil
+	}
+	if err := mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	data := strings.Join(ids, "\n")
+	if len(ids) > 0 {
+		data += "\n"
+	}
+	return ioutil.WriteFile(path, []byte(data), 0644)
+}
+
+//Reads the ids of the jobs file
+func loadJobIds(path string) []string {
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+//Adds or removes an id of the jobs file
+func updateJobIds(path, id string, add bool) error {
+	ids := []string{}
+	for _, known := range loadJobIds(path) {
+		if known != id {
+			ids = append(ids, known)
+		}
+	}
+	if add {
+		ids = append(ids, id)
+	}
+	return storeJobIds(path, ids)
+}
+
+var completionTemplates = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+const bashCompletion = `# bash completion for {{.Program}}, generated by {{.Program}} completion bash
+# load it with: source <({{.Program}} completion bash)
+_{{funcName .Program}}() {
+    local cur prev cmd="" i
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    for ((i=1; i<COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+{{- range valueOpts .Globals}}
+        --{{.Long}}{{if .Short}}|-{{.Short}}{{end}}) ((i++)) ;;
+{{- end}}
+        -*) ;;
+        *) cmd="${COMP_WORDS[i]}"; break ;;
+        esac
+    done
+    case "$cmd" in
+    "")
+        case "$prev" in
+{{- range valueOpts .Globals}}
+        --{{.Long}}{{if .Short}}|-{{.Short}}{{end}}){{if .Values}} COMPREPLY=($(compgen -W {{quote (join .Values " ")}} -- "$cur"));{{else if .Dirs}} COMPREPLY=($(compgen -d -- "$cur"));{{else if .Files}} COMPREPLY=($(compgen -f -- "$cur"));{{end}} return ;;
+{{- end}}
+        esac
+        COMPREPLY=($(compgen -W {{quote (words .Globals)}}' '{{quote (join $.CommandNames " ")}} -- "$cur")) ;;
+{{- range .Commands}}
+    {{.Name}})
+        case "$prev" in
+{{- range valueOpts .Flags}}
+        --{{.Long}}{{if .Short}}|-{{.Short}}{{end}}){{if .Values}} COMPREPLY=($(compgen -W {{quote (join .Values " ")}} -- "$cur"));{{else if .Dirs}} COMPREPLY=($(compgen -d -- "$cur"));{{else if .Files}} COMPREPLY=($(compgen -f -- "$cur"));{{end}} return ;;
+{{- end}}
+        esac
+        COMPREPLY=($(compgen -W {{quote (words .Flags)}}{{if .Args}}' '{{quote (join .Args " ")}}{{end}} -- "$cur"))
+{{- if .JobIds}}
+        if [[ "$cur" != -* ]]; then
+            COMPREPLY+=($(compgen -W "$(cat {{quote $.JobsFile}} 2>/dev/null)" -- "$cur"))
+        fi
+{{- end}} ;;
+{{- end}}
+    esac
+}
+complete -o filenames -F _{{funcName .Program}} {{.Program}}
+`
+
+const zshCompletion = `# zsh completion for {{.Program}}, generated by {{.Program}} completion zsh
+# load it with: source <({{.Program}} completion zsh)
+autoload -U +X bashcompinit && bashcompinit
+` + bashCompletion
+
+const fishCompletion = `# fish completion for {{.Program}}, generated by {{.Program}} completion fish
+# load it with: {{.Program}} completion fish | source
+complete -c {{.Program}} -f
+{{- range .Globals}}
+complete -c {{$.Program}} -n __fish_use_subcommand -l {{.Long}}{{if eq (len .Short) 1}} -s {{.Short}}{{end}}{{if not .Switch}} -r{{end}}{{if .Values}} -a {{quote (join .Values " ")}}{{else if .Files}} -F{{else if .Dirs}} -a '(__fish_complete_directories)'{{end}} -d {{quote .Desc}}
+{{- end}}
+{{- range .Commands}}
+complete -c {{$.Program}} -n __fish_use_subcommand -a {{.Name}} -d {{quote .Desc}}
+{{- $name := .Name}}
+{{- range .Flags}}
+complete -c {{$.Program}} -n '__fish_seen_subcommand_from {{$name}}' -l {{.Long}}{{if eq (len .Short) 1}} -s {{.Short}}{{end}}{{if not .Switch}} -r{{end}}{{if .Values}} -a {{quote (join .Values " ")}}{{else if .Files}} -F{{else if .Dirs}} -a '(__fish_complete_directories)'{{end}} -d {{quote .Desc}}
+{{- end}}
+{{- if .Args}}
+complete -c {{$.Program}} -n '__fish_seen_subcommand_from {{$name}}' -a {{quote (join .Args " ")}}
+{{- end}}
+{{- if .JobIds}}
+complete -c {{$.Program}} -n '__fish_seen_subcommand_from {{$name}}' -a "(cat {{quote $.JobsFile}} 2>/dev/null)" -d 'Job id'
+{{- end}}
+{{- end}}
+`
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+func makeCompletionCli(t *testing.T) *Cli {
+	config := copyConf()
+	config[STARTING] = false
+	pipe := newPipelineTest(false)
+	link := &PipelineLink{pipeline: pipe, config: config}
+	cli, err := makeCli("dp2", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddJobStatusCommand(cli, *link)
+	AddCompletionCommand(cli, link)
+	return cli
+}
+
+//Caches the test script for the webservice of the test configuration
+func cacheCompletionScripts(t *testing.T) func() {
+	restore := mockScriptsCache(t)
+	if err := storeScripts(ScriptsCachePath, copyConf().Url(), "1.0", []pipeline.Script{SCRIPT}, time.Now().Add(-2*SCRIPTS_CACHE_TTL)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return restore
+}
+
+func TestTypeCompletion(t *testing.T) {
+	if comp := typeCompletion(SCRIPT.Options[1].Type); !reflect.DeepEqual(comp.Values, []string{"foo", "bar"}) {
+		t.Errorf("Wrong choice values %v", comp.Values)
+	}
+	if comp := typeCompletion(pipeline.XsBoolean{}); !reflect.DeepEqual(comp.Values, []string{"true", "false"}) {
+		t.Errorf("Wrong boolean values %v", comp.Values)
+	}
+	if comp := typeCompletion(pipeline.AnyFileURI{}); !comp.Files {
+		t.Errorf("Files not completed %+v", comp)
+	}
+	if comp := typeCompletion(pipeline.AnyDirURI{}); !comp.Dirs {
+		t.Errorf("Directories not completed %+v", comp)
+	}
+	if comp := typeCompletion(pipeline.XsString{}); comp.Files || comp.Dirs || len(comp.Values) > 0 {
+		t.Errorf("Strings should not be completed %+v", comp)
+	}
+}
+
+func TestCompletionCommand(t *testing.T) {
+	defer cacheCompletionScripts(t)()
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		cli := makeCompletionCli(t)
+		w := overrideOutput(cli)
+		if err := cli.Run([]string{"completion", shell}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		for _, exp := range []string{"test", "status", "another-opt", "foo bar", "output"} {
+			if !strings.Contains(w.String(), exp) {
+				t.Errorf("%v: %q not found in the output\n%v", shell, exp, w.String())
+			}
+		}
+	}
+	cli := makeCompletionCli(t)
+	if err := cli.Run([]string{"completion", "powershell"}); err == nil {
+		t.Errorf("Expected error not thrown for an unsupported shell")
+	}
+}
+
+func TestCompletionOffline(t *testing.T) {
+	defer mockScriptsCache(t)()
+	config := copyConf()
+	config[STARTING] = true
+	pipe := newPipelineTest(true)
+	link := &PipelineLink{pipeline: pipe, config: config}
+	cli, err := makeCli("dp2", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddCompletionCommand(cli, link)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"completion", "bash"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if pipe.call != "" {
+		t.Errorf("The webservice was contacted (%v)", pipe.call)
+	}
+	if strings.Contains(w.String(), "Error") || strings.Contains(w.String(), "another-opt") {
+		t.Errorf("Wrong output\n%v", w.String())
+	}
+}
+
+func TestCompletionQuotesJobsFile(t *testing.T) {
+	old := JobsCachePath
+	defer func() {
+		JobsCachePath = old
+	}()
+	JobsCachePath = "/home/my user/jobs"
+	for _, shell := range []string{"bash", "fish"} {
+		cli := makeCompletionCli(t)
+		w := overrideOutput(cli)
+		if err := cli.Run([]string{"completion", shell}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !strings.Contains(w.String(), "cat '/home/my user/jobs'") {
+			t.Errorf("%v: the jobs file isn't quoted\n%v", shell, w.String())
+		}
+	}
+}
+
+func TestCompletionJobIds(t *testing.T) {
+	cli := makeCompletionCli(t)
+	model := completionData(cli)
+	for _, cmd := range model.Commands {
+		if cmd.JobIds != (cmd.Name == "status") {
+			t.Errorf("Wrong job id completion for %v", cmd.Name)
+		}
+	}
+}
+
+func TestJobIdsCache(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_jobs_cache")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	path := filepath.Join(folder, JOBS_CACHE_FILE)
+	if err := storeJobIds(path, []string{"job1", "job2"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	updateJobIds(path, "job3", true)
+	updateJobIds(path, "job1", false)
+	if ids := loadJobIds(path); !reflect.DeepEqual(ids, []string{"job2", "job3"}) {
+		t.Errorf("Wrong ids %v", ids)
+	}
+	if ids := loadJobIds(""); ids != nil {
+		t.Errorf("An empty path should disable the cache %v", ids)
+	}
+}
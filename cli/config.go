@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/kardianos/osext"
 	"launchpad.net/goyaml"
@@ -21,17 +23,49 @@ const (
 	EXECLINE     = "exec_line"
 	CLIENTKEY    = "client_key"
 	CLIENTSECRET = "client_secret"
+	SECRETFILE   = "client_secret_file"
+	SECRETCMD    = "client_secret_command"
 	TIMEOUT      = "timeout"
+	RETRIES      = "retries"
+	POLLFAILURES = "poll_failures"
 	DEBUG        = "debug"
+	LOGLEVEL     = "log_level"
+	LOGFILE      = "log_file"
+	TRACEHTTP    = "trace_http"
 	STARTING     = "starting"
+	PROFILE      = "profile"
+	PROFILES     = "profiles"
 )
 
+//Internal keys, they are not meant to be set in the yaml files
+const (
+	SOURCE_FILE = "_source_file" //file with the highest precedence that was loaded
+	ORIGINS     = "_origins"     //layer every value comes from
+)
+
+//Origins of the configuration values that don't come from a file
+const (
+	ORIGIN_DEFAULT = "default"
+	ORIGIN_FLAG    = "command line"
+)
+
+//Prefix of the environment variables that override the configuration
+//values, e.g. DP2_HOST or DP2_CLIENT_KEY
+const ENV_PREFIX = "DP2_"
+
 //Other convinience constants
 const (
 	ERR_STR      = "Error parsing configuration: %v"
 	DEFAULT_FILE = "config.yml"
+	CONFIG_DIR   = "daisy-pipeline"
+	CONFIG_FILE  = "dp2.yml"
 )
 
+//configuration locations service
+var configLocations = func() []string {
+	return getConfigLocations(runtime.GOOS)
+}
+
 //Config is just a map
 type Config map[string]interface{}
 
@@ -45,9 +79,17 @@ var config = Config{
 	EXECLINE:     "",
 	CLIENTKEY:    "",
 	CLIENTSECRET: "",
+	SECRETFILE:   "",
+	SECRETCMD:    "",
 	TIMEOUT:      10,
+	RETRIES:      3,
+	POLLFAILURES: 5,
 	DEBUG:        false,
+	LOGLEVEL:     DEFAULT_LOG_LEVEL,
+	LOGFILE:      "",
+	TRACEHTTP:    false,
 	STARTING:     false,
+	PROFILE:      "",
 }
 
 //Config items descriptions
@@ -60,9 +102,17 @@ var config_descriptions = map[string]string{
 	EXECLINE:     "Pipeline webserivice executable path",
 	CLIENTKEY:    "Client key for authenticated requests",
 	CLIENTSECRET: "Client secrect for authenticated requests",
+	SECRETFILE:   "File containing the client secret",
+	SECRETCMD:    "Command that prints the client secret",
 	TIMEOUT:      "Http connection timeout in seconds",
+	RETRIES:      "Times a failed GET request is retried",
+	POLLFAILURES: "Consecutive failures getting the status of a running job before giving up",
 	DEBUG:        "Print debug messages. true or false. ",
+	LOGLEVEL:     "Messages written to the log: error, warn, info or debug",
+	LOGFILE:      "File where the log is appended, stderr if empty",
+	TRACEHTTP:    "Log the http requests and responses. true or false",
 	STARTING:     "Start the webservice in the local computer if it is not running. true or false",
+	PROFILE:      "Configuration profile to use, see the config command",
 }
 
 //Makes a copy of the default config
@@ -74,58 +124,317 @@ func copyConf() Config {
 	return ret
 }
 
-//Tries to load the default configuration file ( folder where the executable is located / config.yml) if not possible
-//returns a minimal configuration setup
+//Loads the configuration files found in the default locations (see getConfigLocations) and
+//then applies the DP2_* environment variables. If no file is found the built-in
+//defaults are used
 func NewConfig() Config {
 	cnf := copyConf()
-	if err := loadDefault(cnf); err != nil {
-		fmt.Println("Warning : no default configuration file found")
-		log.Println(err.Error())
-		return copyConf()
+	if loaded := cnf.loadFiles(configLocations()); loaded == 0 {
+		logWarn("No default configuration file found")
 	}
+	if err := cnf.FromEnv(); err != nil {
+		logWarn("%v", err)
+	}
+	cnf.UpdateDebug()
 	return cnf
 }
 
-//Loads the default configuration file
-func loadDefault(cnf Config) error {
-	folder, err := osext.ExecutableFolder()
-	if err != nil {
-		return err
+//Returns the configuration files in increasing order of precedence: the system file,
+//the XDG user file, the legacy user file and the file next to the executable
+func getConfigLocations(currentOs string) []string {
+	locations := []string{}
+	if currentOs == "windows" {
+		if data := os.Getenv("PROGRAMDATA"); data != "" {
+			locations = append(locations, filepath.Join(data, CONFIG_DIR, CONFIG_FILE))
+		}
+	} else {
+		locations = append(locations, filepath.Join("/etc", CONFIG_DIR, CONFIG_FILE))
+	}
+	if xdg := xdgConfigHome(currentOs); xdg != "" {
+		locations = append(locations, filepath.Join(xdg, CONFIG_DIR, CONFIG_FILE))
+	}
+	if homePath() != "" {
+		locations = append(locations, filepath.Join(homePath(), ".daisy-pipeline", "dp2", DEFAULT_FILE))
+	}
+	if folder, err := osext.ExecutableFolder(); err == nil {
+		locations = append(locations, filepath.Join(folder, DEFAULT_FILE))
+	}
+	return locations
+}
+
+//Returns $XDG_CONFIG_HOME or its platform default
+func xdgConfigHome(currentOs string) string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return xdg
+	}
+	if currentOs == "windows" {
+		return os.Getenv("APPDATA")
+	}
+	if homePath() != "" {
+		return filepath.Join(homePath(), ".config")
 	}
-	file, err := os.Open(folder + string(os.PathSeparator) + DEFAULT_FILE)
+	return ""
+}
+
+//Returns the file where the user settings are written when no
+//configuration file was loaded
+func userConfigFile() string {
+	return filepath.Join(xdgConfigHome(runtime.GOOS), CONFIG_DIR, CONFIG_FILE)
+}
+
+//Loads the existing files, the values of each file override the ones of the
+//previous files. Returns the number of files loaded
+func (c Config) loadFiles(paths []string) (loaded int) {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			logDebug("%v", err)
+			continue
+		}
+		if err := c.FromFile(path); err != nil {
+			logWarn("Error loading the configuration file %v: %v", path, err)
+			continue
+		}
+		loaded++
+	}
+	return
+}
+
+//Loads the yaml file into the configuration and remembers where it came from
+func (c Config) FromFile(path string) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	err = cnf.FromYaml(file)
+	err = c.fromYaml(file, path)
 	if err != nil {
 		return err
 	}
+	c[SOURCE_FILE] = path
 	return nil
 }
 
 //Loads the contents of the yaml file into the configuration
 func (c Config) FromYaml(r io.Reader) error {
+	return c.fromYaml(r, "yaml")
+}
+
+//Merges the contents of the yaml file into the configuration, the origin
+//is recorded for every value
+func (c Config) fromYaml(r io.Reader, origin string) error {
 	bytes, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	err = goyaml.Unmarshal(bytes, c)
+	layer := make(Config)
+	err = goyaml.Unmarshal(bytes, layer)
 	if err != nil {
 		return err
 	}
+	for key, value := range layer {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		if key == PROFILES {
+			value = mergeProfiles(c[PROFILES], value)
+		}
+		c[key] = value
+		c.setOrigin(key, origin)
+	}
 	c.UpdateDebug()
-	return err
+	return nil
+}
+
+//Profiles from different files are merged by name
+func mergeProfiles(old, profiles interface{}) interface{} {
+	oldMap, ok := old.(map[interface{}]interface{})
+	newMap, isMap := profiles.(map[interface{}]interface{})
+	if !ok || !isMap {
+		return profiles
+	}
+	merged := make(map[interface{}]interface{})
+	for name, profile := range oldMap {
+		merged[name] = profile
+	}
+	for name, profile := range newMap {
+		merged[name] = profile
+	}
+	return merged
+}
+
+//Overrides the configuration values with the DP2_* environment variables
+func (c Config) FromEnv() error {
+	for key := range config_descriptions {
+		name := envName(key)
+		if value := os.Getenv(name); value != "" {
+			if err := c.set(key, value, "env "+name); err != nil {
+				return fmt.Errorf("%v: %v", name, err)
+			}
+		}
+	}
+	return nil
 }
 
-//This method should be called if the DEBUG configuration is changed. The internal Config methods
-//do this automatically
+//Returns the name of the environment variable for the given key
+func envName(key string) string {
+	return ENV_PREFIX + strings.ToUpper(key)
+}
+
+//Sets the value from its string representation, checking that it has
+//the same type as the current one
+func (c Config) set(key, value, origin string) error {
+	switch c[key].(type) {
+	case int:
+		val, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("option %v must be a numeric value (found %v)", key, value)
+		}
+		c[key] = val
+	case bool:
+		switch {
+		case value == "true":
+			c[key] = true
+		case value == "false":
+			c[key] = false
+		default:
+			return fmt.Errorf("option %v must be true or false (found %v)", key, value)
+		}
+	case string:
+		c[key] = value
+	}
+	c.setOrigin(key, origin)
+	return nil
+}
+
+//Records where the value of key comes from
+func (c Config) setOrigin(key, origin string) {
+	origins, ok := c[ORIGINS].(map[string]string)
+	if !ok {
+		origins = make(map[string]string)
+		c[ORIGINS] = origins
+	}
+	origins[key] = origin
+}
+
+//Returns the layer where the value of key comes from
+func (c Config) Origin(key string) string {
+	if origin, ok := c[ORIGINS].(map[string]string)[key]; ok {
+		return origin
+	}
+	return ORIGIN_DEFAULT
+}
+
+//Returns a copy of the configuration that can be modified without
+//affecting this one
+func (c Config) copy() Config {
+	cp := make(Config)
+	for key, value := range c {
+		cp[key] = value
+	}
+	if origins, ok := c[ORIGINS].(map[string]string); ok {
+		cp[ORIGINS] = make(map[string]string)
+		for key, origin := range origins {
+			cp.setOrigin(key, origin)
+		}
+	}
+	return cp
+}
+
+//Returns the profiles defined in the configuration by name
+func (c Config) Profiles() map[string]Config {
+	profiles := make(map[string]Config)
+	raw, ok := c[PROFILES].(map[interface{}]interface{})
+	if !ok {
+		return profiles
+	}
+	for name, values := range raw {
+		profile := make(Config)
+		if items, ok := values.(map[interface{}]interface{}); ok {
+			for key, value := range items {
+				profile[fmt.Sprint(key)] = value
+			}
+		}
+		profiles[fmt.Sprint(name)] = profile
+	}
+	return profiles
+}
+
+//Overrides the configuration values with the ones from the profile. An empty
+//name means no profile
+func (c Config) UseProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, ok := c.Profiles()[name]
+	if !ok {
+		return fmt.Errorf("Profile %v not found in the configuration", name)
+	}
+	for key, value := range profile {
+		c[key] = value
+		c.setOrigin(key, "profile "+name)
+	}
+	c[PROFILE] = name
+	c.UpdateDebug()
+	return nil
+}
+
+//Sets the default profile in the configuration file, the rest of the file
+//is left untouched
+func storeDefaultProfile(path, name string) error {
+	mode := os.FileMode(0644)
+	data := []byte{}
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := mkdir(filepath.Dir(path)); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		mode = info.Mode()
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+	}
+	line := PROFILE + ": " + name
+	lines := strings.Split(string(data), "\n")
+	found := false
+	for idx, l := range lines {
+		if strings.HasPrefix(l, PROFILE+":") {
+			lines[idx] = line
+			found = true
+		}
+	}
+	if !found {
+		lines = append([]string{line}, lines...)
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), mode)
+}
+
+//Configures the logger, this method should be called if the DEBUG, LOGLEVEL, LOGFILE
+//or TRACEHTTP configuration is changed. The internal Config methods do this automatically
 func (c Config) UpdateDebug() {
-	if !c[DEBUG].(bool) {
-		log.SetOutput(ioutil.Discard)
-	} else {
-		log.SetOutput(os.Stdout)
+	path, _ := c[LOGFILE].(string)
+	if err := logs.configure(c.logLevel(), path, c[TRACEHTTP] == true); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning : %v\n", err)
+	}
+}
+
+//Returns the log level, debug is kept as a shortcut of the debug level
+func (c Config) logLevel() LogLevel {
+	if c[DEBUG] == true {
+		return LogDebug
+	}
+	name, ok := c[LOGLEVEL].(string)
+	if !ok || name == "" {
+		name = DEFAULT_LOG_LEVEL
+	}
+	level, err := parseLogLevel(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning : %v\n", err)
 	}
+	return level
 }
 
 //Returns the Url composed by HOSTNAME:PORT/PATH/
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	ProfileListTemplate = `{{range .}}{{if .Active}}*{{else}} {{end}} {{.Name}}	{{.Url}}
+{{end}}`
+
+	ConfigShowTemplate = `{{range $key, $value := .}}{{$key}}: {{$value}}
+{{end}}`
+
+	ConfigOriginTemplate = `{{range $key, $value := .}}{{$key}}: {{$value.Value}}	({{$value.Origin}})
+{{end}}`
+)
+
+//Profile summary used by config list
+type profileInfo struct {
+	Name   string `json:"name" yaml:"name"`
+	Url    string `json:"url" yaml:"url"`
+	Active bool   `json:"active" yaml:"active"`
+}
+
+//Configuration value along with the layer it comes from
+type configValue struct {
+	Value  interface{} `json:"value" yaml:"value"`
+	Origin string      `json:"origin" yaml:"origin"`
+}
+
+//Adds the config command, it doesn't need the webservice to be running
+func AddConfigCommand(cli *Cli, link *PipelineLink) {
+	origin := false
+	cmd := cli.AddCommand("config", "Lists, selects and shows the configuration profiles", func(name string, args ...string) error {
+		return configCommand(cli, link.config, origin, args...)
+	})
+	cmd.SetArity(-1, "(list|use PROFILE|show [PROFILE])")
+	cmd.AddSwitch("origin", "", "Show where every value of config show comes from", func(string, string) error {
+		origin = true
+		return nil
+	})
+	cli.setOffline(cmd.Name)
+}
+
+//Dispatches the config actions
+func configCommand(cli *Cli, conf Config, origin bool, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("config: one of list, use or show expected")
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		profiles, err := listProfiles(cli, conf)
+		if err != nil {
+			return err
+		}
+		return commandBuilder{template: ProfileListTemplate}.writeOutput(profiles, cli)
+	case args[0] == "use" && len(args) == 2:
+		if _, ok := conf.Profiles()[args[1]]; !ok {
+			return fmt.Errorf("Profile %v not found in the configuration", args[1])
+		}
+		path, ok := conf[SOURCE_FILE].(string)
+		if !ok {
+			path = userConfigFile()
+		}
+		if err := storeDefaultProfile(path, args[1]); err != nil {
+			return err
+		}
+		cli.Printf("Default profile set to %v in %v\n", args[1], path)
+		return nil
+	case args[0] == "show" && len(args) <= 2:
+		shown := conf
+		if len(args) == 2 {
+			var err error
+			if shown, err = cli.profileConfig(conf, args[1]); err != nil {
+				return err
+			}
+		}
+		if origin {
+			return commandBuilder{template: ConfigOriginTemplate}.writeOutput(showOrigins(shown), cli)
+		}
+		return commandBuilder{template: ConfigShowTemplate}.writeOutput(showConfig(shown), cli)
+	}
+	return fmt.Errorf("config: wrong arguments %v, expected list, use PROFILE or show [PROFILE]", args)
+}
+
+//Returns the profiles sorted by name
+func listProfiles(cli *Cli, conf Config) ([]profileInfo, error) {
+	profiles := conf.Profiles()
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	infos := make([]profileInfo, 0, len(names))
+	for _, name := range names {
+		withProfile, err := cli.profileConfig(conf, name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, profileInfo{
+			Name:   name,
+			Url:    withProfile.Url(),
+			Active: conf[PROFILE] == name,
+		})
+	}
+	return infos, nil
+}
+
+//Returns the configuration values that can be set, hiding the client secret
+func showConfig(conf Config) map[string]interface{} {
+	values := make(map[string]interface{})
+	for key := range config_descriptions {
+		values[key] = conf[key]
+	}
+	if secret, ok := conf[CLIENTSECRET].(string); ok && secret != "" {
+		values[CLIENTSECRET] = "****"
+	}
+	return values
+}
+
+//Returns the configuration values along with their origin
+func showOrigins(conf Config) map[string]configValue {
+	values := make(map[string]configValue)
+	for key, value := range showConfig(conf) {
+		values[key] = configValue{value, conf.Origin(key)}
+	}
+	return values
+}
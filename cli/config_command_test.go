@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+//Creates a cli with the config command whose configuration is loaded from a
+//file containing the test profiles. The pipeline fails so any attempt
+//to contact it will make the command fail
+func makeProfilesCli(t *testing.T) (*Cli, Config, *bytes.Buffer, string) {
+	file, err := ioutil.TempFile("", "dp2_profiles")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	file.WriteString(PROFILES_YAML)
+	file.Close()
+	conf := copyConf()
+	if err := conf.FromFile(file.Name()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	link := &PipelineLink{pipeline: newPipelineTest(true), config: conf}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddConfigCommand(cli, link)
+	return cli, conf, overrideOutput(cli), file.Name()
+}
+
+func TestConfigList(t *testing.T) {
+	cli, _, w, path := makeProfilesCli(t)
+	defer os.Remove(path)
+	if err := cli.Run([]string{"config", "list"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	exp := "* local\thttp://localhost:9000/ws/\n  staging\thttp://staging.org:80/ws/\n"
+	if w.String() != exp {
+		t.Errorf("Wrong profile list\nexpected: %q\nresult:   %q", exp, w.String())
+	}
+}
+
+//The command line values are more important than the profile ones
+func TestConfigShowOverrides(t *testing.T) {
+	cli, _, w, path := makeProfilesCli(t)
+	defer os.Remove(path)
+	err := cli.Run([]string{"--port", "1234", "--profile", "staging", "--format", "json", "config", "show"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(w.Bytes(), &values); err != nil {
+		t.Fatalf("Output is not json %v: %s", err, w.String())
+	}
+	if values[HOST] != "http://staging.org" || values[PORT] != 1234.0 || values[PROFILE] != "staging" {
+		t.Errorf("Wrong effective configuration %v", values)
+	}
+	if values[CLIENTSECRET] != "****" {
+		t.Errorf("The client secret should be hidden %v", values[CLIENTSECRET])
+	}
+}
+
+func TestConfigShowProfile(t *testing.T) {
+	cli, _, w, path := makeProfilesCli(t)
+	defer os.Remove(path)
+	if err := cli.Run([]string{"config", "show", "staging"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if !strings.Contains(w.String(), "host: http://staging.org\n") {
+		t.Errorf("Profile values not shown %q", w.String())
+	}
+	if err := cli.Run([]string{"config", "show", "nope"}); err == nil {
+		t.Errorf("Expected error not thrown for a missing profile")
+	}
+}
+
+func TestConfigUse(t *testing.T) {
+	cli, _, _, path := makeProfilesCli(t)
+	defer os.Remove(path)
+	if err := cli.Run([]string{"config", "use", "staging"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	conf := copyConf()
+	if err := conf.FromFile(path); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if conf[PROFILE] != "staging" {
+		t.Errorf("Default profile not stored %v", conf[PROFILE])
+	}
+	if err := cli.Run([]string{"config", "use", "nope"}); err == nil {
+		t.Errorf("Expected error not thrown for a missing profile")
+	}
+	if err := cli.Run([]string{"config", "remove"}); err == nil {
+		t.Errorf("Expected error not thrown for an unknown action")
+	}
+}
+
+//The environment variables override the profile values and the command
+//line overrides both
+func TestConfigShowOrigin(t *testing.T) {
+	cli, _, w, path := makeProfilesCli(t)
+	defer os.Remove(path)
+	os.Setenv("DP2_HOST", "http://env.org")
+	os.Setenv("DP2_TIMEOUT", "30")
+	defer os.Unsetenv("DP2_HOST")
+	defer os.Unsetenv("DP2_TIMEOUT")
+	err := cli.Run([]string{"--profile", "staging", "--timeout", "5", "config", "show", "--origin"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	exp := []string{
+		"host: http://env.org\t(env DP2_HOST)\n",
+		"port: 80\t(profile staging)\n",
+		"timeout: 5\t(command line)\n",
+		"ws_path: ws\t(default)\n",
+		"client_secret: ****\t(" + path + ")\n",
+	}
+	for _, line := range exp {
+		if !strings.Contains(w.String(), line) {
+			t.Errorf("Line %q not found in %q", line, w.String())
+		}
+	}
+}
+
+//The values the inspected profile doesn't set aren't taken from the active one
+func TestConfigShowOtherProfile(t *testing.T) {
+	file, err := ioutil.TempFile("", "dp2_profiles")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`
+host: http://localhost
+port: 8181
+ws_path: ws
+profile: active
+profiles:
+  active:
+    port: 9000
+    ws_path: alt
+  other:
+    host: http://other.org
+`)
+	file.Close()
+	conf := copyConf()
+	if err := conf.FromFile(file.Name()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	link := &PipelineLink{pipeline: newPipelineTest(true), config: conf}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddConfigCommand(cli, link)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"config", "show", "other"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, line := range []string{"host: http://other.org\n", "port: 8181\n", "ws_path: ws\n", "profile: other\n"} {
+		if !strings.Contains(w.String(), line) {
+			t.Errorf("Line %q not found in %q", line, w.String())
+		}
+	}
+	w = overrideOutput(cli)
+	if err := cli.Run([]string{"config", "list"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	exp := "* active\thttp://localhost:9000/alt/\n  other\thttp://other.org:8181/ws/\n"
+	if w.String() != exp {
+		t.Errorf("Wrong profile list\nexpected: %q\nresult:   %q", exp, w.String())
+	}
+	if conf[PORT] != 9000 {
+		t.Errorf("The active configuration changed %v", conf[PORT])
+	}
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -169,3 +170,163 @@ func TestBuildPath(t *testing.T) {
 	}
 
 }
+
+var PROFILES_YAML = `
+host: http://localhost
+port: 8181
+client_secret: shh
+profile: local
+profiles:
+  local:
+    port: 9000
+  staging:
+    host: http://staging.org
+    port: 80
+    debug: true
+`
+
+func TestConfigProfiles(t *testing.T) {
+	cnf := copyConf()
+	if err := cnf.FromYaml(bytes.NewBufferString(PROFILES_YAML)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	profiles := cnf.Profiles()
+	if len(profiles) != 2 {
+		t.Fatalf("Expected 2 profiles got %v", profiles)
+	}
+	if profiles["staging"][HOST] != "http://staging.org" || profiles["staging"][PORT] != 80 {
+		t.Errorf("Staging profile not loaded %v", profiles["staging"])
+	}
+	if len(copyConf().Profiles()) != 0 {
+		t.Errorf("The default configuration shouldn't have profiles")
+	}
+}
+
+func TestConfigUseProfile(t *testing.T) {
+	cnf := copyConf()
+	if err := cnf.FromYaml(bytes.NewBufferString(PROFILES_YAML)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := cnf.UseProfile("staging"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if cnf.Url() != "http://staging.org:80/ws/" {
+		t.Errorf("Profile values not applied %v", cnf.Url())
+	}
+	if cnf[PROFILE] != "staging" || !cnf[DEBUG].(bool) {
+		t.Errorf("Profile values not applied %v", cnf)
+	}
+	if err := cnf.UseProfile("production"); err == nil {
+		t.Errorf("Expected error not thrown for a missing profile")
+	}
+	if err := cnf.UseProfile(""); err != nil {
+		t.Errorf("An empty profile shouldn't fail %v", err)
+	}
+}
+
+func TestNewConfigProfileEnv(t *testing.T) {
+	os.Setenv(envName(PROFILE), "staging")
+	defer os.Unsetenv(envName(PROFILE))
+	cnf := NewConfig()
+	if cnf[PROFILE] != "staging" {
+		t.Errorf("Profile not taken from %v: %v", envName(PROFILE), cnf[PROFILE])
+	}
+}
+
+func TestStoreDefaultProfile(t *testing.T) {
+	file, err := ioutil.TempFile("", "dp2_profiles")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("#comment\nhost: http://localhost\n")
+	file.Close()
+	for _, name := range []string{"local", "staging"} {
+		if err := storeDefaultProfile(file.Name(), name); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	}
+	data, _ := ioutil.ReadFile(file.Name())
+	if string(data) != "profile: staging\n#comment\nhost: http://localhost\n" {
+		t.Errorf("Default profile not stored correctly %q", data)
+	}
+}
+
+func TestGetConfigLocations(t *testing.T) {
+	oldHome := homePath
+	defer func() { homePath = oldHome }()
+	homePath = func() string { return "/home/user" }
+	os.Setenv("XDG_CONFIG_HOME", "")
+	locations := getConfigLocations("linux")
+	exp := []string{
+		"/etc/daisy-pipeline/dp2.yml",
+		"/home/user/.config/daisy-pipeline/dp2.yml",
+		"/home/user/.daisy-pipeline/dp2/config.yml",
+	}
+	if len(locations) != 4 {
+		t.Fatalf("Wrong number of locations %v", locations)
+	}
+	for idx, path := range exp {
+		if locations[idx] != path {
+			t.Errorf("Wrong location %v expected %v", locations[idx], path)
+		}
+	}
+	os.Setenv("XDG_CONFIG_HOME", "/xdg")
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	if locations := getConfigLocations("linux"); locations[1] != "/xdg/daisy-pipeline/dp2.yml" {
+		t.Errorf("XDG_CONFIG_HOME not used %v", locations[1])
+	}
+}
+
+//Tests that the later files override the former ones and that the
+//origin of the values is tracked
+func TestConfigLoadFiles(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_layers")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	system := filepath.Join(folder, "system.yml")
+	user := filepath.Join(folder, "user.yml")
+	ioutil.WriteFile(system, []byte("host: http://system\nport: 1\nprofiles:\n  a:\n    port: 2\n"), 0644)
+	ioutil.WriteFile(user, []byte("port: 3\nprofiles:\n  b:\n    port: 4\n"), 0644)
+
+	cnf := copyConf()
+	loaded := cnf.loadFiles([]string{system, filepath.Join(folder, "missing.yml"), user})
+	if loaded != 2 {
+		t.Errorf("Expected 2 files loaded got %v", loaded)
+	}
+	if cnf[HOST] != "http://system" || cnf[PORT] != 3 {
+		t.Errorf("Files not merged in order %v %v", cnf[HOST], cnf[PORT])
+	}
+	if len(cnf.Profiles()) != 2 {
+		t.Errorf("Profiles not merged %v", cnf.Profiles())
+	}
+	if cnf.Origin(HOST) != system || cnf.Origin(PORT) != user || cnf.Origin(PATH) != ORIGIN_DEFAULT {
+		t.Errorf("Wrong origins %v", cnf[ORIGINS])
+	}
+	if cnf[SOURCE_FILE] != user {
+		t.Errorf("The source file should be the last one loaded %v", cnf[SOURCE_FILE])
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	os.Setenv("DP2_PORT", "9090")
+	os.Setenv("DP2_CLIENT_KEY", "envkey")
+	defer os.Unsetenv("DP2_PORT")
+	defer os.Unsetenv("DP2_CLIENT_KEY")
+	cnf := copyConf()
+	if err := cnf.FromEnv(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if cnf[PORT] != 9090 || cnf[CLIENTKEY] != "envkey" {
+		t.Errorf("Environment not applied %v %v", cnf[PORT], cnf[CLIENTKEY])
+	}
+	if cnf.Origin(PORT) != "env DP2_PORT" {
+		t.Errorf("Wrong origin %v", cnf.Origin(PORT))
+	}
+	os.Setenv("DP2_PORT", "ninety")
+	if err := copyConf().FromEnv(); err == nil {
+		t.Errorf("Expected error not thrown for a non numeric port")
+	}
+}
@@ -0,0 +1,326 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"launchpad.net/goyaml"
+)
+
+const (
+	CREDENTIALS_FILE = "credentials.yml"
+	KEYRING_SERVICE  = "dp2"
+	//backends where the secret is stored
+	BACKEND_FILE    = "file"
+	BACKEND_KEYRING = "keyring"
+)
+
+//File where the credentials are stored, next to the last id file
+var CredentialsPath = filepath.Join(filepath.Dir(LastIdPath), CREDENTIALS_FILE)
+
+//Standard input where the secrets are read from
+var stdin io.Reader = os.Stdin
+
+//Credentials stored for a webservice url, the secret is only present if the
+//backend is the file
+type credentials struct {
+	ClientKey    string `yaml:"client_key"`
+	ClientSecret string `yaml:"client_secret,omitempty"`
+	Backend      string `yaml:"backend"`
+}
+
+//System keyring, the secrets are stored by service and account
+type keyring interface {
+	Get(service, account string) (string, error)
+	Set(service, account, secret string) error
+}
+
+//keyring service, nil if there is no keyring available in the system
+var systemKeyring = func() keyring {
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return secretToolKeyring{}
+		}
+	case "darwin":
+		if _, err := exec.LookPath("security"); err == nil {
+			return macKeyring{}
+		}
+	}
+	return nil
+}
+
+//libsecret keyring through secret-tool
+type secretToolKeyring struct{}
+
+func (secretToolKeyring) Get(service, account string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", service, "account", account).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret not found in the keyring (%v)", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (secretToolKeyring) Set(service, account, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "DAISY Pipeline 2 ("+account+")",
+		"service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error storing the secret in the keyring: %v %s", err, out)
+	}
+	return nil
+}
+
+//macOS keychain through the security command
+type macKeyring struct{}
+
+func (macKeyring) Get(service, account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("secret not found in the keychain (%v)", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (macKeyring) Set(service, account, secret string) error {
+	//the interactive mode reads the command from stdin so the secret is
+	//not visible in the process list
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(securityAddCommand(service, account, secret))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error storing the secret in the keychain: %v %s", err, out)
+	}
+	return nil
+}
+
+//Command line for security -i that stores the secret. The secret is given in
+//hexadecimal (-X) so any byte is stored as is, the rest of the arguments are
+//quoted the way the security command line parser expects
+func securityAddCommand(service, account, secret string) string {
+	quote := func(value string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+	return fmt.Sprintf("add-generic-password -U -s %v -a %v -X %v\n", quote(service), quote(account), hex.EncodeToString([]byte(secret)))
+}
+
+//Loads the stored credentials by url, a missing file means no credentials
+func loadCredentials(path string) (map[string]credentials, error) {
+	creds := make(map[string]credentials)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return creds, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("The credentials file %v can be accessed by other users, please run chmod 600 %v", path, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := goyaml.Unmarshal(data, creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+//Stores the credentials file, only the current user can read it
+func storeCredentials(path string, creds map[string]credentials) error {
+	data, err := goyaml.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if err := mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	//WriteFile keeps the permissions of existing files
+	return os.Chmod(path, 0600)
+}
+
+//Stores the credentials for the url in the keyring if available and useKeyring is true,
+//otherwise in the credentials file. Returns the backend used
+func login(url, key, secret string, useKeyring bool) (backend string, err error) {
+	creds, err := loadCredentials(CredentialsPath)
+	if err != nil {
+		return "", err
+	}
+	entry := credentials{ClientKey: key, Backend: BACKEND_FILE, ClientSecret: secret}
+	if ring := systemKeyring(); useKeyring && ring != nil {
+		if err := ring.Set(KEYRING_SERVICE, url, secret); err != nil {
+			return "", err
+		}
+		entry.Backend = BACKEND_KEYRING
+		entry.ClientSecret = ""
+	}
+	creds[url] = entry
+	return entry.Backend, storeCredentials(CredentialsPath, creds)
+}
+
+//Returns the client key and secret to use with the webservice. The secret is taken, in this order,
+//from the client_secret value, the output of client_secret_command, the contents of client_secret_file
+//and finally the credentials stored through dp2 login
+func resolveCredentials(conf Config) (key, secret string, err error) {
+	key, _ = conf[CLIENTKEY].(string)
+	secret, _ = conf[CLIENTSECRET].(string)
+	if secret == "" {
+		if command, _ := conf[SECRETCMD].(string); command != "" {
+			if secret, err = secretFromCommand(command); err != nil {
+				return
+			}
+		} else if file, _ := conf[SECRETFILE].(string); file != "" {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return key, secret, fmt.Errorf("Error reading the client secret file: %v", err)
+			}
+			secret = strings.TrimSpace(string(data))
+		}
+	}
+	if key != "" && secret != "" {
+		return
+	}
+	creds, err := loadCredentials(CredentialsPath)
+	if err != nil {
+		return
+	}
+	stored, ok := creds[conf.Url()]
+	if !ok || (key != "" && key != stored.ClientKey) {
+		return
+	}
+	key = stored.ClientKey
+	if secret != "" {
+		return
+	}
+	switch stored.Backend {
+	case BACKEND_KEYRING:
+		ring := systemKeyring()
+		if ring == nil {
+			return key, secret, fmt.Errorf("The secret for %v is stored in the keyring but no keyring is available", conf.Url())
+		}
+		secret, err = ring.Get(KEYRING_SERVICE, conf.Url())
+	default:
+		secret = stored.ClientSecret
+	}
+	return
+}
+
+//Runs the command through the shell and returns its output as the secret
+func secretFromCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Error running the client secret command: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+//Reads a line from the reader, used to get the secret from stdin
+func readLine(r io.Reader) (string, error) {
+	var buf bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			buf.WriteByte(b[0])
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimRight(buf.String(), "\r"), nil
+}
+
+//Asks for the secret in the terminal, echo is disabled where possible
+var askSecret = func(w io.Writer, prompt string) (string, error) {
+	fmt.Fprint(w, prompt)
+	if runtime.GOOS != "windows" {
+		stty := exec.Command("stty", "-echo")
+		stty.Stdin = os.Stdin
+		if stty.Run() == nil {
+			defer func() {
+				restore := exec.Command("stty", "echo")
+				restore.Stdin = os.Stdin
+				restore.Run()
+				fmt.Fprintln(w)
+			}()
+		}
+	}
+	return readLine(stdin)
+}
+
+//Adds the login command, it stores the credentials for the webservice
+//configured (see the profiles) without contacting it
+func AddLoginCommand(cli *Cli, link *PipelineLink) {
+	key := ""
+	fromStdin := false
+	noKeyring := false
+	cmd := cli.AddCommand("login", "Stores the client credentials for the webservice", func(string, ...string) error {
+		if key == "" {
+			key, _ = link.config[CLIENTKEY].(string)
+		}
+		if key == "" {
+			return fmt.Errorf("login: no client key given, use --client-key")
+		}
+		var secret string
+		var err error
+		if fromStdin {
+			secret, err = readLine(stdin)
+		} else {
+			secret, err = askSecret(cli.Output, "Client secret: ")
+		}
+		if err != nil {
+			return err
+		}
+		if secret == "" {
+			return fmt.Errorf("login: the client secret is empty")
+		}
+		url := link.config.Url()
+		backend, err := login(url, key, secret, !noKeyring)
+		if err != nil {
+			return err
+		}
+		where := CredentialsPath
+		if backend == BACKEND_KEYRING {
+			where = "the system keyring"
+		}
+		cli.Printf("Credentials for %v stored in %v\n", url, where)
+		return nil
+	})
+	cmd.SetArity(0, "")
+	cmd.AddOption("client-key", "k", "Client key (default the client_key configuration value)", "", "", func(name, value string) error {
+		key = value
+		return nil
+	})
+	cmd.AddSwitch("stdin", "", "Read the client secret from the standard input instead of asking for it", func(string, string) error {
+		fromStdin = true
+		return nil
+	})
+	cmd.AddSwitch("no-keyring", "", "Store the secret in the credentials file even if a keyring is available", func(string, string) error {
+		noKeyring = true
+		return nil
+	})
+	cli.setOffline(cmd.Name)
+}
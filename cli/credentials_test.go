@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//in memory keyring
+type keyringTest map[string]string
+
+func (k keyringTest) Get(service, account string) (string, error) {
+	secret, ok := k[service+account]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return secret, nil
+}
+
+func (k keyringTest) Set(service, account, secret string) error {
+	k[service+account] = secret
+	return nil
+}
+
+//Points the credentials file to a temporary folder and replaces the
+//keyring, returns a function that restores everything
+func mockCredentials(t *testing.T, ring keyring) func() {
+	folder, err := ioutil.TempDir("", "dp2_credentials")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	oldPath, oldRing := CredentialsPath, systemKeyring
+	CredentialsPath = filepath.Join(folder, "dp2", CREDENTIALS_FILE)
+	systemKeyring = func() keyring {
+		if ring == nil {
+			return nil
+		}
+		return ring
+	}
+	return func() {
+		CredentialsPath, systemKeyring = oldPath, oldRing
+		os.RemoveAll(folder)
+	}
+}
+
+func TestCredentialsFile(t *testing.T) {
+	defer mockCredentials(t, nil)()
+	creds := map[string]credentials{
+		"http://localhost:8181/ws/": credentials{ClientKey: "key", ClientSecret: "shh", Backend: BACKEND_FILE},
+	}
+	if err := storeCredentials(CredentialsPath, creds); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	info, err := os.Stat(CredentialsPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("The credentials file should only be readable by the user (%v)", err)
+	}
+	loaded, err := loadCredentials(CredentialsPath)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if loaded["http://localhost:8181/ws/"] != creds["http://localhost:8181/ws/"] {
+		t.Errorf("Credentials not loaded %v", loaded)
+	}
+	os.Chmod(CredentialsPath, 0644)
+	if _, err := loadCredentials(CredentialsPath); err == nil {
+		t.Errorf("Expected error not thrown for a file readable by others")
+	}
+}
+
+func TestResolveCredentialsSources(t *testing.T) {
+	defer mockCredentials(t, nil)()
+	cnf := copyConf()
+	cnf[CLIENTKEY] = "key"
+	cnf[SECRETCMD] = "echo fromcommand"
+	if _, secret, err := resolveCredentials(cnf); err != nil || secret != "fromcommand" {
+		t.Errorf("Secret not read from the command %v (%v)", secret, err)
+	}
+	file, err := ioutil.TempFile("", "dp2_secret")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("fromfile\n")
+	file.Close()
+	cnf[SECRETCMD] = ""
+	cnf[SECRETFILE] = file.Name()
+	if _, secret, err := resolveCredentials(cnf); err != nil || secret != "fromfile" {
+		t.Errorf("Secret not read from the file %v (%v)", secret, err)
+	}
+	cnf[CLIENTSECRET] = "fromconfig"
+	if _, secret, err := resolveCredentials(cnf); err != nil || secret != "fromconfig" {
+		t.Errorf("The configuration value should have precedence %v (%v)", secret, err)
+	}
+	cnf[CLIENTSECRET] = ""
+	cnf[SECRETFILE] = "/tmp/dp2_this_secret_file_does_not_exist"
+	if _, _, err := resolveCredentials(cnf); err == nil {
+		t.Errorf("Expected error not thrown for a missing secret file")
+	}
+}
+
+func TestResolveStoredCredentials(t *testing.T) {
+	ring := keyringTest{}
+	defer mockCredentials(t, ring)()
+	cnf := copyConf()
+	if _, err := login(cnf.Url(), "key", "inring", true); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := login("http://other:80/ws/", "other", "infile", false); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	data, _ := ioutil.ReadFile(CredentialsPath)
+	if strings.Contains(string(data), "inring") {
+		t.Errorf("The secret stored in the keyring shouldn't be in the file")
+	}
+	key, secret, err := resolveCredentials(cnf)
+	if err != nil || key != "key" || secret != "inring" {
+		t.Errorf("Credentials not taken from the keyring %v %v (%v)", key, secret, err)
+	}
+	cnf[HOST] = "http://other"
+	cnf[PORT] = 80
+	key, secret, err = resolveCredentials(cnf)
+	if err != nil || key != "other" || secret != "infile" {
+		t.Errorf("Credentials not taken from the file %v %v (%v)", key, secret, err)
+	}
+	//a different key doesn't use the stored secret
+	cnf[CLIENTKEY] = "another"
+	if _, secret, _ := resolveCredentials(cnf); secret != "" {
+		t.Errorf("The stored secret belongs to another client %v", secret)
+	}
+}
+
+func TestInitStoredCredentials(t *testing.T) {
+	defer mockCredentials(t, nil)()
+	cnf := copyConf()
+	cnf[STARTING] = false
+	if _, err := login(cnf.Url(), "key", "shh", true); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	pipe := newPipelineTest(false)
+	pipe.authentication = true
+	link := PipelineLink{pipeline: pipe, config: cnf}
+	if err := link.Init(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if pipe.key != "key" || pipe.secret != "shh" {
+		t.Errorf("Stored credentials not used %v %v", pipe.key, pipe.secret)
+	}
+}
+
+func TestLoginCommand(t *testing.T) {
+	ring := keyringTest{}
+	defer mockCredentials(t, ring)()
+	oldStdin := stdin
+	defer func() { stdin = oldStdin }()
+	stdin = strings.NewReader("supersecret\n")
+
+	conf := copyConf()
+	link := &PipelineLink{pipeline: newPipelineTest(true), config: conf}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	w := overrideOutput(cli)
+	AddLoginCommand(cli, link)
+	if err := cli.Run([]string{"login", "--client-key", "me", "--stdin"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if ring[KEYRING_SERVICE+conf.Url()] != "supersecret" {
+		t.Errorf("Secret not stored in the keyring %v", ring)
+	}
+	if !strings.Contains(w.String(), "keyring") {
+		t.Errorf("Wrong output %q", w.String())
+	}
+	stdin = strings.NewReader("")
+	if err := cli.Run([]string{"login", "-k", "me", "--stdin"}); err == nil {
+		t.Errorf("Expected error not thrown for an empty secret")
+	}
+}
+
+func TestSecurityAddCommand(t *testing.T) {
+	line := securityAddCommand(`http://host/"ws"\`, "key", "s\"e c\\r\n")
+	exp := `add-generic-password -U -s "http://host/\"ws\"\\" -a "key" -X 73226520635c720a` + "\n"
+	if line != exp {
+		t.Errorf("%q != %q", line, exp)
+	}
+}
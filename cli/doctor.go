@@ -0,0 +1,267 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+//Results of the doctor checks
+const (
+	CHECK_PASS = "pass"
+	CHECK_WARN = "warn"
+	CHECK_FAIL = "fail"
+)
+
+const DoctorTemplate = `Configuration file: {{if .Source}}{{.Source}}{{else}}none, using the defaults{{end}}
+{{range $key, $value := .Config}}        {{$key}}: {{$value}}
+{{end}}
+{{range .Checks}}[{{.Label}}] {{.Name}}: {{.Message}}
+{{if .Hint}}       {{.Hint}}
+{{end}}{{end}}`
+
+//Result of a check along with how to fix it
+type doctorCheck struct {
+	Name    string `json:"name" yaml:"name"`
+	Status  string `json:"status" yaml:"status"` //pass, warn or fail
+	Message string `json:"message" yaml:"message"`
+	Hint    string `json:"hint,omitempty" yaml:"hint,omitempty"` //what to do when it doesn't pass
+}
+
+func (c doctorCheck) Label() string {
+	switch c.Status {
+	case CHECK_PASS:
+		return "PASS"
+	case CHECK_WARN:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+//What the doctor found
+type doctorReport struct {
+	Source string                 `json:"source" yaml:"source"` //configuration file loaded
+	Config map[string]interface{} `json:"config" yaml:"config"`
+	Checks []doctorCheck          `json:"checks" yaml:"checks"`
+}
+
+//Number of failed checks
+func (r doctorReport) failed() (n int) {
+	for _, check := range r.Checks {
+		if check.Status == CHECK_FAIL {
+			n++
+		}
+	}
+	return
+}
+
+//Adds the doctor command, it checks the configuration, the connection to the
+//webservice and the local installation without starting the webservice
+func AddDoctorCommand(cli *Cli, link *PipelineLink) {
+	cmd := cli.AddCommand("doctor", "Checks the configuration, the webservice and the local installation", func(name string, args ...string) error {
+		report := diagnose(link)
+		if err := (commandBuilder{template: DoctorTemplate}).writeOutput(report, cli); err != nil {
+			return err
+		}
+		if failed := report.failed(); failed > 0 {
+			return newError(GenericError, nil, "%v of %v checks failed", failed, len(report.Checks))
+		}
+		return nil
+	})
+	cli.setOffline(cmd.Name)
+}
+
+//Runs all the checks
+func diagnose(link *PipelineLink) doctorReport {
+	source, _ := link.config[SOURCE_FILE].(string)
+	report := doctorReport{Source: source, Config: showConfig(link.config)}
+	transport := link.connect()
+	add := func(check doctorCheck) {
+		report.Checks = append(report.Checks, check)
+	}
+	add(checkConfigFile(source))
+	resolved := checkResolve(link.config)
+	add(resolved)
+	if resolved.Status == CHECK_PASS {
+		add(checkConnect(link.config))
+	}
+	alive := checkAlive(link)
+	add(alive)
+	if alive.Status == CHECK_PASS {
+		add(checkCredentials(link, transport))
+	}
+	starting, _ := link.config[STARTING].(bool)
+	add(checkJava(starting))
+	add(checkExecLine(link.config, starting))
+	add(checkWritable(filepath.Dir(LastIdPath)))
+	add(checkHaltKey(alive.Status == CHECK_PASS && link.IsLocal()))
+	return report
+}
+
+func checkConfigFile(source string) doctorCheck {
+	check := doctorCheck{Name: "Configuration", Status: CHECK_PASS, Message: "Loaded from " + source}
+	if source == "" {
+		check.Status = CHECK_WARN
+		check.Message = "No configuration file found, using the defaults and DP2_* variables"
+		check.Hint = "Create " + userConfigFile() + " or use --file"
+	}
+	return check
+}
+
+//Returns the host name and port of the webservice url
+func hostPort(conf Config) (string, string, error) {
+	u, err := url.Parse(fmt.Sprint(conf[HOST]))
+	if err != nil || u.Hostname() == "" {
+		return "", "", fmt.Errorf("%v is not a valid host, it should look like http://localhost", conf[HOST])
+	}
+	return u.Hostname(), fmt.Sprint(conf[PORT]), nil
+}
+
+func checkResolve(conf Config) doctorCheck {
+	check := doctorCheck{Name: "Host", Hint: "Check the host setting and the network"}
+	host, _, err := hostPort(conf)
+	if err != nil {
+		check.Status, check.Message = CHECK_FAIL, err.Error()
+		return check
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		check.Status, check.Message = CHECK_FAIL, fmt.Sprintf("Could not resolve %v: %v", host, err)
+		return check
+	}
+	check.Status, check.Message, check.Hint = CHECK_PASS, fmt.Sprintf("%v resolves to %v", host, addrs[0]), ""
+	return check
+}
+
+func checkConnect(conf Config) doctorCheck {
+	check := doctorCheck{Name: "Connection"}
+	host, port, _ := hostPort(conf)
+	address := net.JoinHostPort(host, port)
+	timeout := time.Duration(configInt(conf, TIMEOUT)) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		check.Status, check.Message = CHECK_FAIL, fmt.Sprintf("Could not connect to %v: %v", address, err)
+		check.Hint = "Start the webservice with dp2 server start or check the host and port settings"
+		return check
+	}
+	conn.Close()
+	check.Status, check.Message = CHECK_PASS, "Connected to "+address
+	return check
+}
+
+func checkAlive(link *PipelineLink) doctorCheck {
+	check := doctorCheck{Name: "Webservice"}
+	alive, err := link.pipeline.Alive()
+	if err != nil {
+		check.Status, check.Message = CHECK_FAIL, fmt.Sprintf("%v doesn't answer: %v", link.config.Url(), ErrorMessage(err, false))
+		check.Hint = "Check the ws_path setting, start the webservice with dp2 server start or see dp2 server status"
+		return check
+	}
+	link.setAlive(alive)
+	check.Status = CHECK_PASS
+	check.Message = fmt.Sprintf("Version %v at %v, local mode %v, authentication %v", alive.Version, link.config.Url(), alive.FsAllow, alive.Authentication)
+	return check
+}
+
+//Calls an endpoint that needs authentication
+func checkCredentials(link *PipelineLink, transport *retryTransport) doctorCheck {
+	check := doctorCheck{Name: "Credentials"}
+	if !link.Authentication {
+		check.Status, check.Message = CHECK_PASS, "The webservice doesn't require authentication"
+		return check
+	}
+	err := link.authenticate(transport)
+	if err == nil {
+		_, err = link.pipeline.Jobs()
+	}
+	if err != nil {
+		check.Status, check.Message = CHECK_FAIL, ErrorMessage(err, false)
+		check.Hint = "Check client_key and client_secret (or client_secret_file, client_secret_command) or run dp2 login"
+		return check
+	}
+	check.Status, check.Message = CHECK_PASS, fmt.Sprintf("Authenticated as %v", link.config[CLIENTKEY])
+	return check
+}
+
+//Java is only needed to start the webservice in this computer
+func checkJava(starting bool) doctorCheck {
+	check := doctorCheck{Name: "Java"}
+	found, err := CheckJava(MIN_JAVA_VERSION)
+	if err != nil {
+		check.Status, check.Message = CHECK_WARN, err.Error()
+		if starting {
+			check.Status = CHECK_FAIL
+		}
+		check.Hint = fmt.Sprintf("Install java %v or greater, or point JAVA_HOME to it", MIN_JAVA_VERSION)
+		return check
+	}
+	check.Status, check.Message = CHECK_PASS, found
+	return check
+}
+
+func checkExecLine(conf Config, starting bool) doctorCheck {
+	check := doctorCheck{Name: "Executable", Hint: "Set exec_line to the pipeline2 script of the installation"}
+	fail := CHECK_WARN
+	if starting {
+		fail = CHECK_FAIL
+	}
+	if execLine, _ := conf[EXECLINE].(string); execLine == "" {
+		check.Status, check.Message = fail, EXECLINE+" is not set, the webservice can't be started by dp2"
+		return check
+	}
+	path := conf.ExecPath()
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		check.Status, check.Message = fail, fmt.Sprintf("%v not found", path)
+	case info.IsDir():
+		check.Status, check.Message = fail, fmt.Sprintf("%v is a directory", path)
+	case runtime.GOOS != "windows" && info.Mode()&0111 == 0:
+		check.Status, check.Message = fail, fmt.Sprintf("%v is not executable", path)
+		check.Hint = "Run chmod +x " + path
+	default:
+		check.Status, check.Message, check.Hint = CHECK_PASS, path, ""
+	}
+	return check
+}
+
+//The lastid, history and caches are stored in the directory
+func checkWritable(dir string) doctorCheck {
+	check := doctorCheck{Name: "Data directory"}
+	file, err := ioutil.TempFile(dir, ".dp2-doctor-")
+	if err != nil {
+		check.Status, check.Message = CHECK_FAIL, fmt.Sprintf("Could not write into %v: %v", dir, err)
+		check.Hint = "Check the permissions of " + dir
+		return check
+	}
+	file.Close()
+	os.Remove(file.Name())
+	check.Status, check.Message = CHECK_PASS, dir+" is writable"
+	return check
+}
+
+//The halt key is only needed to halt a webservice running in this computer
+func checkHaltKey(local bool) doctorCheck {
+	check := doctorCheck{Name: "Halt key"}
+	key, err := loadKey()
+	if err != nil || key == "" {
+		check.Status, check.Message = CHECK_WARN, fmt.Sprintf("%v not found, halt won't work", filepath.Join(os.TempDir(), keyFile))
+		if local {
+			check.Hint = "The webservice writes it when it starts, restart it with dp2 server restart"
+		} else {
+			check.Hint = "It's only needed to halt a webservice running in this computer"
+		}
+		return check
+	}
+	check.Status, check.Message = CHECK_PASS, "Found in "+os.TempDir()+" ("+strconv.Itoa(len(key))+" characters)"
+	return check
+}
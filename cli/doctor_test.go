@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//Runs the doctor against the pipeline with a working java and data directory
+func runDoctor(t *testing.T, pipe PipelineApi, conf Config, args ...string) (string, error) {
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldJava, oldPath := javaVersionService, LastIdPath
+	defer func() {
+		javaVersionService, LastIdPath = oldJava, oldPath
+	}()
+	javaVersionService = func() (string, error) {
+		return `openjdk version "17.0.2" 2022-01-18`, nil
+	}
+	LastIdPath = filepath.Join(dir, "lastid")
+	link := &PipelineLink{pipeline: pipe, config: conf}
+	cli, err := NewCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddDoctorCommand(cli, link)
+	w := overrideOutput(cli)
+	err = cli.Run(append(args, "doctor"))
+	return w.String(), err
+}
+
+//Listens in a local port so the connection check passes
+func doctorListener(t *testing.T) (net.Listener, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Couldn't listen %v", err)
+	}
+	return listener, listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestDoctor(t *testing.T) {
+	listener, port := doctorListener(t)
+	defer listener.Close()
+	pipe := newPipelineTest(false)
+	pipe.authentication = true
+	conf := Config{HOST: "http://127.0.0.1", PORT: port, PATH: "ws", CLIENTKEY: "key", CLIENTSECRET: "secret", SOURCE_FILE: "/etc/dp2/config.yml"}
+	out, err := runDoctor(t, pipe, conf)
+	if err != nil {
+		t.Fatalf("Unexpected error %v\n%v", err, out)
+	}
+	exp := []string{
+		"Configuration file: /etc/dp2/config.yml",
+		"client_secret: ****",
+		"[PASS] Host: 127.0.0.1 resolves to 127.0.0.1",
+		"[PASS] Connection: Connected to 127.0.0.1:" + strconv.Itoa(port),
+		"[PASS] Webservice: Version version-test",
+		"[PASS] Credentials: Authenticated as key",
+		`[PASS] Java: openjdk version "17.0.2"`,
+		"[WARN] Executable: exec_line is not set",
+		"[PASS] Data directory:",
+	}
+	for _, line := range exp {
+		if !strings.Contains(out, line) {
+			t.Errorf("%q not found in\n%v", line, out)
+		}
+	}
+	if strings.Contains(out, "secret\n") {
+		t.Errorf("The secret is shown\n%v", out)
+	}
+	if pipe.call != JOBS_CALL {
+		t.Errorf("No authenticated call was made")
+	}
+}
+
+func TestDoctorFailures(t *testing.T) {
+	conf := Config{HOST: "http://127.0.0.1", PORT: 1, PATH: "ws"}
+	out, err := runDoctor(t, newPipelineTest(true), conf)
+	if err == nil || !strings.Contains(err.Error(), "checks failed") {
+		t.Errorf("Wrong error %v", err)
+	}
+	for _, line := range []string{"[WARN] Configuration:", "[FAIL] Connection:", "[FAIL] Webservice:", "dp2 server start"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("%q not found in\n%v", line, out)
+		}
+	}
+	if strings.Contains(out, "Credentials") {
+		t.Errorf("Credentials checked without a webservice\n%v", out)
+	}
+}
+
+func TestDoctorJson(t *testing.T) {
+	listener, port := doctorListener(t)
+	defer listener.Close()
+	pipe := newPipelineTest(false)
+	pipe.authentication = true
+	conf := Config{HOST: "http://127.0.0.1", PORT: port, PATH: "ws"}
+	out, err := runDoctor(t, pipe, conf, "--format", "json")
+	if err == nil {
+		t.Errorf("Missing credentials not reported")
+	}
+	report := doctorReport{}
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("Not json %v\n%v", err, out)
+	}
+	checks := map[string]doctorCheck{}
+	for _, check := range report.Checks {
+		checks[check.Name] = check
+	}
+	if check := checks["Credentials"]; check.Status != CHECK_FAIL || !strings.Contains(check.Hint, "dp2 login") {
+		t.Errorf("Wrong credentials check %+v", check)
+	}
+	if checks["Webservice"].Status != CHECK_PASS {
+		t.Errorf("Wrong webservice check %+v", checks["Webservice"])
+	}
+}
+
+func TestCheckExecLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "pipeline2")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0644)
+	if check := checkExecLine(Config{EXECLINE: script}, true); check.Status != CHECK_FAIL || !strings.Contains(check.Hint, "chmod +x") {
+		t.Errorf("Not executable script accepted %+v", check)
+	}
+	os.Chmod(script, 0755)
+	if check := checkExecLine(Config{EXECLINE: script}, true); check.Status != CHECK_PASS {
+		t.Errorf("Wrong check %+v", check)
+	}
+	if check := checkExecLine(Config{EXECLINE: filepath.Join(dir, "missing")}, false); check.Status != CHECK_WARN {
+		t.Errorf("Missing script not reported %+v", check)
+	}
+}
+
+func TestDoctorJava(t *testing.T) {
+	back := javaVersionService
+	defer func() {
+		javaVersionService = back
+	}()
+	javaVersionService = func() (string, error) {
+		return `java version "1.8.0_45"`, nil
+	}
+	if check := checkJava(false); check.Status != CHECK_WARN {
+		t.Errorf("Old java not reported %+v", check)
+	}
+	if check := checkJava(true); check.Status != CHECK_FAIL || check.Hint == "" {
+		t.Errorf("Old java not reported %+v", check)
+	}
+}
+
+func TestCheckHaltKey(t *testing.T) {
+	backup := keyFile
+	defer func() {
+		keyFile = backup
+	}()
+	keyFile = "fakeDoctorKey"
+	if check := checkHaltKey(true); check.Status != CHECK_WARN || !strings.Contains(check.Hint, "restart") {
+		t.Errorf("Missing key not reported %+v", check)
+	}
+	file, err := createKeyFile(keyFile, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if check := checkHaltKey(true); check.Status != CHECK_PASS {
+		t.Errorf("Key not found %+v", check)
+	}
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/bertfrees/go-subcommand"
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Kind of error, every kind has its own exit code (see ExitCode)
+type ErrorKind int
+
+const (
+	GenericError     ErrorKind = iota //anything else
+	UsageError                        //wrong command line
+	ConnectionError                   //the webservice can't be reached
+	AuthError                         //wrong or missing credentials, not enough permissions
+	NotFoundError                     //unknown job, script, client or file
+	ValidationError                   //wrong value of an input or option
+	JobFailedError                    //the job finished with status FAIL, e.g. the input didn't validate
+	JobErroredError                   //the job finished with status ERROR
+	InterruptedError                  //stopped by the user
+)
+
+//Exit codes of the kinds of errors
+var exitCodes = map[ErrorKind]int{
+	GenericError:     1,
+	UsageError:       2,
+	ConnectionError:  3,
+	AuthError:        4,
+	NotFoundError:    5,
+	ValidationError:  6,
+	JobFailedError:   7,
+	JobErroredError:  8,
+	InterruptedError: 130,
+}
+
+//Error meant for the user: the message is what happened in plain words and the
+//cause, only shown with --debug, the technical detail
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Cause   error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+//Creates a new error of the given kind
+func newError(kind ErrorKind, cause error, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Cause: cause}
+}
+
+//Returns the error corresponding to the final status of a job, nil if it succeeded
+func jobStatusError(id, status string) error {
+	switch status {
+	case "FAIL":
+		return newError(JobFailedError, nil, "Job %v failed, check its messages and log (dp2 log %v)", id, id)
+	case "ERROR":
+		return newError(JobErroredError, nil, "Job %v finished with an error, check its messages and log (dp2 log %v)", id, id)
+	}
+	return nil
+}
+
+//Returns the error as an *Error, guessing the kind of the errors that come from
+//the client library, the network or the command line parser
+func asError(err error) *Error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+	var parsing subcommand.ParsingError
+	var netErr net.Error
+	var urlErr *url.Error
+	msg := err.Error()
+	switch {
+	case err == errInterrupted:
+		return newError(InterruptedError, nil, msg)
+	case errors.As(err, &parsing):
+		return newError(UsageError, nil, msg)
+	case errors.Is(err, syscall.ECONNREFUSED), errors.As(err, &netErr), errors.As(err, &urlErr):
+		return newError(ConnectionError, err, "Could not connect to the webservice, check that it's running and the host and port settings")
+	case msg == pipeline.ERR_401:
+		return newError(AuthError, err, "The webservice rejected the credentials, check the client key and secret or run dp2 login")
+	case strings.HasPrefix(msg, strings.TrimSuffix(pipeline.ERR_404, "%v")):
+		return newError(NotFoundError, err, "Not found in the webservice, check the job id or script name")
+	}
+	return newError(GenericError, nil, msg)
+}
+
+//Whether a request that failed with the error may succeed later: network errors
+//and the server errors (5xx), not the ones about the request itself like 404 or 401
+func transientError(err error) bool {
+	if asError(err).Kind == ConnectionError {
+		return true
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, strings.TrimSuffix(pipeline.ERR_500, "%v")) {
+		return true
+	}
+	var status int
+	if _, err := fmt.Sscanf(msg, pipeline.ERR_DEFAULT, &status); err == nil {
+		return status >= 500
+	}
+	return false
+}
+
+//Returns the exit code for the error, 0 if there is no error
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return exitCodes[asError(err).Kind]
+}
+
+//Returns the message to show to the user, the technical detail is only added
+//when debugging
+func ErrorMessage(err error, debug bool) string {
+	e := asError(err)
+	if debug && e.Cause != nil && e.Cause.Error() != e.Message {
+		return fmt.Sprintf("%v\n\tDetail: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/bertfrees/go-subcommand"
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+func TestAsError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{errors.New("Error"), GenericError},
+		{errInterrupted, InterruptedError},
+		{subcommand.ParsingError{Description: "wrong"}, UsageError},
+		{&url.Error{Op: "Get", URL: "http://localhost:8181/ws/alive", Err: syscall.ECONNREFUSED}, ConnectionError},
+		{errors.New(pipeline.ERR_401), AuthError},
+		{fmt.Errorf(pipeline.ERR_404, "http://localhost:8181/ws/jobs/job1"), NotFoundError},
+		{fmt.Errorf("wrapped: %w", newError(ValidationError, nil, "wrong value")), ValidationError},
+		{jobStatusError("job1", "FAIL"), JobFailedError},
+		{jobStatusError("job1", "ERROR"), JobErroredError},
+	}
+	for _, test := range tests {
+		if kind := asError(test.err).Kind; kind != test.kind {
+			t.Errorf("%v: kind %v != %v", test.err, kind, test.kind)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := map[error]int{
+		nil:                                       0,
+		errors.New("Error"):                       1,
+		newError(UsageError, nil, "usage"):        2,
+		newError(ConnectionError, nil, "conn"):    3,
+		errors.New(pipeline.ERR_401):              4,
+		newError(NotFoundError, nil, "not found"): 5,
+		newError(ValidationError, nil, "value"):   6,
+		jobStatusError("job1", "FAIL"):            7,
+		jobStatusError("job1", "ERROR"):           8,
+		errInterrupted:                            130,
+	}
+	for err, exp := range tests {
+		if code := ExitCode(err); code != exp {
+			t.Errorf("%v: exit code %v != %v", err, code, exp)
+		}
+	}
+	if jobStatusError("job1", "SUCCESS") != nil {
+		t.Errorf("Successful jobs aren't errors")
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &url.Error{Op: "Get", URL: "http://localhost:8181/ws/alive", Err: syscall.ECONNREFUSED}
+	msg := ErrorMessage(err, false)
+	if strings.Contains(msg, "localhost") || !strings.Contains(msg, "Could not connect") {
+		t.Errorf("Technical detail shown without debug %q", msg)
+	}
+	msg = ErrorMessage(err, true)
+	if !strings.HasPrefix(msg, "Could not connect") || !strings.Contains(msg, "Detail: "+err.Error()) {
+		t.Errorf("Technical detail not shown with debug %q", msg)
+	}
+	if msg := ErrorMessage(errors.New("Error"), true); msg != "Error" {
+		t.Errorf("Plain errors should be shown as they are %q", msg)
+	}
+}
+
+func finishingJob(status string) func(string, int) (pipeline.Job, error) {
+	return func(id string, _ int) (pipeline.Job, error) {
+		return pipeline.Job{Id: id, Status: status}, nil
+	}
+}
+
+func TestScriptFailedJob(t *testing.T) {
+	defer mockHistory(t)()
+	folder := testTree(t, "book.xml", "a.xml")
+	defer os.RemoveAll(folder)
+	for status, kind := range map[string]ErrorKind{"FAIL": JobFailedError, "ERROR": JobErroredError} {
+		pipe := newPipelineTest(false)
+		pipe.job = finishingJob(status)
+		link := &PipelineLink{FsAllow: true, pipeline: pipe}
+		cli, err := makeCli("test", link)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		overrideOutput(cli)
+		if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		err = cli.Run([]string{"test", "-o", filepath.Join(folder, "out-"+status), "--source", filepath.Join(folder, "a.xml"),
+			"--single", filepath.Join(folder, "book.xml"), "--test-opt", "x", "--another-opt", "foo"})
+		if err == nil || asError(err).Kind != kind {
+			t.Errorf("%v: wrong error %v", status, err)
+		}
+	}
+}
+
+func TestWatchCommandFailedJob(t *testing.T) {
+	pipe := newPipelineTest(false)
+	pipe.job = finishingJob("FAIL")
+	link := PipelineLink{pipeline: pipe}
+	cli, err := makeCli("test", &link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	w := overrideOutput(cli)
+	AddWatchCommand(cli, link)
+	err = cli.Run([]string{"watch", "-q", "job1"})
+	if ExitCode(err) != 7 {
+		t.Errorf("Wrong error %v", err)
+	}
+	if !strings.Contains(w.String(), "Job finished with status: FAIL") {
+		t.Errorf("Final status not printed %q", w.String())
+	}
+}
+
+func TestLinkNotStarting(t *testing.T) {
+	link := PipelineLink{pipeline: newPipelineTest(true), config: Config{STARTING: false, HOST: "http://localhost", PORT: 8181, PATH: "ws"}}
+	err := bringUp(&link)
+	if ExitCode(err) != 3 {
+		t.Errorf("Wrong error %v", err)
+	}
+	if !strings.Contains(err.Error(), "http://localhost:8181/ws/") {
+		t.Errorf("The url isn't shown %v", err)
+	}
+}
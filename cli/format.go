@@ -0,0 +1,354 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/daisy/pipeline-clientlib-go"
+	"launchpad.net/goyaml"
+)
+
+//Output formats accepted by the --format global option
+const (
+	TEXT_FORMAT = "text"
+	JSON_FORMAT = "json"
+	YAML_FORMAT = "yaml"
+)
+
+//Checks that a string defines a valid output format
+func checkFormat(format string) bool {
+	return format == TEXT_FORMAT || format == JSON_FORMAT ||
+		format == YAML_FORMAT
+}
+
+//The following structures define the schema of the machine-readable
+//documents, they are decoupled from the clientlib structs so the output stays
+//stable even if the xml bindings change. (See the README for the schema)
+
+//Job document
+type jobDocument struct {
+	Id       string            `json:"id" yaml:"id"`
+	Nicename string            `json:"nicename,omitempty" yaml:"nicename,omitempty"`
+	Script   string            `json:"script,omitempty" yaml:"script,omitempty"`
+	Status   string            `json:"status" yaml:"status"`
+	Priority string            `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Progress float64           `json:"progress" yaml:"progress"`
+	Messages []messageDocument `json:"messages,omitempty" yaml:"messages,omitempty"`
+}
+
+//Job message document, nested messages are kept nested
+type messageDocument struct {
+	Sequence int               `json:"sequence" yaml:"sequence"`
+	Level    string            `json:"level" yaml:"level"`
+	Content  string            `json:"content" yaml:"content"`
+	Messages []messageDocument `json:"messages,omitempty" yaml:"messages,omitempty"`
+}
+
+//Execution queue entry document
+type queueJobDocument struct {
+	Id               string  `json:"id" yaml:"id"`
+	ComputedPriority float64 `json:"computed_priority" yaml:"computed_priority"`
+	JobPriority      string  `json:"job_priority" yaml:"job_priority"`
+	ClientPriority   string  `json:"client_priority" yaml:"client_priority"`
+	RelativeTime     float64 `json:"relative_time" yaml:"relative_time"`
+	TimeStamp        int64   `json:"timestamp" yaml:"timestamp"`
+}
+
+//Client document, the secret is never printed
+type clientDocument struct {
+	Id       string `json:"id" yaml:"id"`
+	Role     string `json:"role" yaml:"role"`
+	Contact  string `json:"contact,omitempty" yaml:"contact,omitempty"`
+	Priority string `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+//Client document with the secret generated for it, only present if it wasn't
+//stored elsewhere
+type clientSecretDocument struct {
+	Id       string `json:"id" yaml:"id"`
+	Role     string `json:"role" yaml:"role"`
+	Contact  string `json:"contact,omitempty" yaml:"contact,omitempty"`
+	Priority string `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Secret   string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	StoredIn string `json:"stored_in,omitempty" yaml:"stored_in,omitempty"`
+}
+
+//Runtime property document
+type propertyDocument struct {
+	Name       string `json:"name" yaml:"name"`
+	Value      string `json:"value" yaml:"value"`
+	BundleName string `json:"bundle_name" yaml:"bundle_name"`
+	BundleId   string `json:"bundle_id" yaml:"bundle_id"`
+}
+
+//Job data sizes document
+type sizesDocument struct {
+	Total int               `json:"total" yaml:"total"`
+	Jobs  []jobSizeDocument `json:"jobs" yaml:"jobs"`
+}
+
+type jobSizeDocument struct {
+	Id      string `json:"id" yaml:"id"`
+	Context int    `json:"context" yaml:"context"`
+	Output  int    `json:"output" yaml:"output"`
+	Log     int    `json:"log" yaml:"log"`
+	Total   int    `json:"total" yaml:"total"`
+}
+
+//Version document
+type versionDocument struct {
+	ClientVersion   string `json:"client_version" yaml:"client_version"`
+	PipelineVersion string `json:"pipeline_version" yaml:"pipeline_version"`
+	Authentication  bool   `json:"authentication" yaml:"authentication"`
+}
+
+//Script list entry document
+type scriptSummaryDocument struct {
+	Id          string `json:"id" yaml:"id"`
+	Version     string `json:"version" yaml:"version"`
+	Description string `json:"description" yaml:"description"`
+}
+
+//Script document, it holds what is needed to build the script command (or a form)
+type scriptDocument struct {
+	Id          string           `json:"id" yaml:"id"`
+	Nicename    string           `json:"nicename" yaml:"nicename"`
+	Version     string           `json:"version" yaml:"version"`
+	Description string           `json:"description" yaml:"description"`
+	Homepage    string           `json:"homepage,omitempty" yaml:"homepage,omitempty"`
+	Inputs      []inputDocument  `json:"inputs" yaml:"inputs"`
+	Options     []optionDocument `json:"options" yaml:"options"`
+}
+
+//Script input port document
+type inputDocument struct {
+	Name            string   `json:"name" yaml:"name"`
+	Nicename        string   `json:"nicename,omitempty" yaml:"nicename,omitempty"`
+	Description     string   `json:"description" yaml:"description"`
+	LongDescription string   `json:"long_description,omitempty" yaml:"long_description,omitempty"`
+	Required        bool     `json:"required" yaml:"required"`
+	Sequence        bool     `json:"sequence" yaml:"sequence"`
+	MediaTypes      []string `json:"media_types" yaml:"media_types"`
+}
+
+//Script option document
+type optionDocument struct {
+	Name            string       `json:"name" yaml:"name"`
+	Nicename        string       `json:"nicename,omitempty" yaml:"nicename,omitempty"`
+	Description     string       `json:"description" yaml:"description"`
+	LongDescription string       `json:"long_description,omitempty" yaml:"long_description,omitempty"`
+	Required        bool         `json:"required" yaml:"required"`
+	Sequence        bool         `json:"sequence" yaml:"sequence"`
+	Default         string       `json:"default" yaml:"default"`
+	MediaTypes      []string     `json:"media_types,omitempty" yaml:"media_types,omitempty"`
+	Type            typeDocument `json:"type" yaml:"type"`
+}
+
+//Option type document. kind is one of string, integer, nonNegativeInteger, boolean,
+//anyURI, anyFileURI, anyDirURI, pattern (see pattern), value (see value) or choice
+//(see choices)
+type typeDocument struct {
+	Kind          string         `json:"kind" yaml:"kind"`
+	Documentation string         `json:"documentation,omitempty" yaml:"documentation,omitempty"`
+	Pattern       string         `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Value         string         `json:"value,omitempty" yaml:"value,omitempty"`
+	Choices       []typeDocument `json:"choices,omitempty" yaml:"choices,omitempty"`
+}
+
+//Short description of the type used by the text output
+func (t typeDocument) String() string {
+	switch t.Kind {
+	case "pattern":
+		return "/" + t.Pattern + "/"
+	case "value":
+		return fmt.Sprintf("%q", t.Value)
+	case "choice":
+		choices := make([]string, len(t.Choices))
+		for idx, choice := range t.Choices {
+			choices[idx] = choice.String()
+		}
+		return "(" + strings.Join(choices, "|") + ")"
+	}
+	return t.Kind
+}
+
+//Plain text results (e.g. "Job removed") are wrapped in a message document
+type textDocument struct {
+	Message string `json:"message" yaml:"message"`
+}
+
+func newJobDocument(job pipeline.Job, withMessages bool) jobDocument {
+	doc := jobDocument{
+		Id:       job.Id,
+		Nicename: job.Nicename,
+		Script:   job.Script.Id,
+		Status:   job.Status,
+		Priority: job.Priority,
+		Progress: job.Messages.Progress,
+	}
+	if withMessages {
+		doc.Messages = newMessageDocuments(job.Messages.Message)
+	}
+	return doc
+}
+
+func newMessageDocuments(msgs []pipeline.Message) []messageDocument {
+	if len(msgs) == 0 {
+		return nil
+	}
+	docs := make([]messageDocument, len(msgs))
+	for idx, msg := range msgs {
+		docs[idx] = messageDocument{
+			Sequence: msg.Sequence,
+			Level:    msg.Level,
+			Content:  msg.Content,
+			Messages: newMessageDocuments(msg.Message),
+		}
+	}
+	return docs
+}
+
+func newSizesDocument(sizes pipeline.JobSizes) sizesDocument {
+	doc := sizesDocument{
+		Total: sizes.Total,
+		Jobs:  make([]jobSizeDocument, len(sizes.JobSizes)),
+	}
+	for idx, size := range sizes.JobSizes {
+		doc.Jobs[idx] = jobSizeDocument{
+			Id:      size.Id,
+			Context: size.Context,
+			Output:  size.Output,
+			Log:     size.Log,
+			Total:   size.Context + size.Output + size.Log,
+		}
+	}
+	return doc
+}
+
+func newScriptDocument(script pipeline.Script) scriptDocument {
+	doc := scriptDocument{
+		Id:          script.Id,
+		Nicename:    script.Nicename,
+		Version:     script.Version,
+		Description: strings.TrimSpace(script.Description),
+		Homepage:    script.Homepage,
+		Inputs:      make([]inputDocument, len(script.Inputs)),
+		Options:     make([]optionDocument, len(script.Options)),
+	}
+	for idx, input := range script.Inputs {
+		doc.Inputs[idx] = inputDocument{
+			Name:            input.Name,
+			Nicename:        input.NiceName,
+			Description:     input.ShortDesc,
+			LongDescription: input.LongDesc,
+			Required:        input.Required,
+			Sequence:        input.Sequence,
+			MediaTypes:      strings.Fields(input.Mediatype),
+		}
+	}
+	for idx, option := range script.Options {
+		doc.Options[idx] = optionDocument{
+			Name:            option.Name,
+			Nicename:        option.NiceName,
+			Description:     option.ShortDesc,
+			LongDescription: option.LongDesc,
+			Required:        option.Required,
+			Sequence:        option.Sequence,
+			Default:         option.Default,
+			MediaTypes:      strings.Fields(option.Mediatype),
+			Type:            newTypeDocument(option.Type),
+		}
+	}
+	return doc
+}
+
+//The kinds are the ones of the scripts cache so both stay in sync
+func newTypeDocument(dataType pipeline.DataType) typeDocument {
+	return typeDocumentFrom(encodeType(dataType))
+}
+
+func typeDocumentFrom(c cachedType) typeDocument {
+	doc := typeDocument{Kind: c.Kind, Documentation: c.Documentation, Pattern: c.Pattern, Value: c.Value}
+	if doc.Kind == "" {
+		doc.Kind = "string"
+	}
+	for _, value := range c.Values {
+		doc.Choices = append(doc.Choices, typeDocumentFrom(value))
+	}
+	return doc
+}
+
+//Converts the values returned by the command calls into their document
+//counterpart. Unknown values are returned untouched
+func toDocument(data interface{}) interface{} {
+	switch v := data.(type) {
+	case *printableJob:
+		return newJobDocument(v.Data, v.Verbose)
+	case pipeline.Job:
+		return newJobDocument(v, true)
+	case []pipeline.Job:
+		docs := make([]jobDocument, len(v))
+		for idx, job := range v {
+			docs[idx] = newJobDocument(job, false)
+		}
+		return docs
+	case []pipeline.QueueJob:
+		docs := make([]queueJobDocument, len(v))
+		for idx, job := range v {
+			docs[idx] = queueJobDocument{
+				Id:               job.Id,
+				ComputedPriority: job.ComputedPriority,
+				JobPriority:      job.JobPriority,
+				ClientPriority:   job.ClientPriority,
+				RelativeTime:     job.RelativeTime,
+				TimeStamp:        job.TimeStamp,
+			}
+		}
+		return docs
+	case pipeline.Client:
+		return clientDocument{v.Id, v.Role, v.Contact, v.Priority}
+	case clientSecret:
+		return clientSecretDocument{v.Id, v.Role, v.Contact, v.Priority, v.Generated, v.StoredIn}
+	case []pipeline.Client:
+		docs := make([]clientDocument, len(v))
+		for idx, client := range v {
+			docs[idx] = clientDocument{client.Id, client.Role, client.Contact, client.Priority}
+		}
+		return docs
+	case []pipeline.Property:
+		docs := make([]propertyDocument, len(v))
+		for idx, prop := range v {
+			docs[idx] = propertyDocument{prop.Name, prop.Value, prop.BundleName, prop.BundleId}
+		}
+		return docs
+	case pipeline.JobSizes:
+		return newSizesDocument(v)
+	case Version:
+		return versionDocument{v.CliVersion, v.PipelineLink.Version, v.Authentication}
+	case string:
+		return textDocument{strings.TrimSpace(v)}
+	}
+	return data
+}
+
+//Serialises the document using the given format
+func writeDocument(w io.Writer, format string, doc interface{}) error {
+	var out []byte
+	var err error
+	switch format {
+	case JSON_FORMAT:
+		out, err = json.MarshalIndent(doc, "", "  ")
+		out = append(out, '\n')
+	case YAML_FORMAT:
+		out, err = goyaml.Marshal(doc)
+	default:
+		return fmt.Errorf("%v is not a valid output format", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
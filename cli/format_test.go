@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+func TestCheckFormat(t *testing.T) {
+	for _, format := range []string{"text", "json", "yaml"} {
+		if !checkFormat(format) {
+			t.Errorf("Format %v should be valid", format)
+		}
+	}
+	if checkFormat("xml") {
+		t.Errorf("Format xml shouldn't be valid")
+	}
+}
+
+//Tests that the job messages are only included when asked for
+func TestToDocumentJob(t *testing.T) {
+	doc := toDocument(&printableJob{Data: JOB_1}).(jobDocument)
+	if doc.Id != JOB_1.Id || doc.Status != JOB_1.Status || doc.Progress != JOB_1.Messages.Progress {
+		t.Errorf("Job document doesn't match the job %+v", doc)
+	}
+	if len(doc.Messages) != 0 {
+		t.Errorf("Messages shouldn't be present in the non verbose document")
+	}
+	doc = toDocument(&printableJob{Data: JOB_1, Verbose: true}).(jobDocument)
+	if len(doc.Messages) != 2 || doc.Messages[1].Content != "Message 2" {
+		t.Errorf("Messages not converted %+v", doc.Messages)
+	}
+}
+
+//Tests that the client secret never makes it to the document
+func TestToDocumentClientSecret(t *testing.T) {
+	client := pipeline.Client{Id: "paco", Secret: "shh", Role: "ADMIN"}
+	doc := toDocument([]pipeline.Client{client})
+	var buf strings.Builder
+	if err := writeDocument(&buf, JSON_FORMAT, doc); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if strings.Contains(buf.String(), "shh") {
+		t.Errorf("The secret was serialised %s", buf.String())
+	}
+}
+
+func TestToDocumentEmptyList(t *testing.T) {
+	var buf strings.Builder
+	if err := writeDocument(&buf, JSON_FORMAT, toDocument([]pipeline.Job{})); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("Empty lists should be serialised as [] got %q", buf.String())
+	}
+}
+
+func TestWriteDocumentUnknownFormat(t *testing.T) {
+	var buf strings.Builder
+	if err := writeDocument(&buf, "xml", textDocument{"hi"}); err == nil {
+		t.Errorf("Expected error not thrown")
+	}
+}
+
+//Tests the json output of a builder command
+func TestQueueCommandJson(t *testing.T) {
+	cli, link, _ := makeReturningCli(queue, t)
+	r := overrideOutput(cli)
+	AddQueueCommand(cli, link)
+	err := cli.Run([]string{"--format", "json", "queue"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	var docs []map[string]interface{}
+	if err := json.Unmarshal(r.Bytes(), &docs); err != nil {
+		t.Errorf("Output is not json %v: %s", err, r.String())
+		return
+	}
+	if len(docs) != 1 || docs[0]["id"] != "job1" || docs[0]["client_priority"] != "high" {
+		t.Errorf("Queue document doesn't match %v", docs)
+	}
+}
+
+//Tests the yaml output of the sizes command
+func TestSizesYaml(t *testing.T) {
+	sizes := pipeline.JobSizes{
+		JobSizes: []pipeline.JobSize{
+			pipeline.JobSize{Id: "id", Context: 2, Output: 3, Log: 3},
+		},
+		Total: 8,
+	}
+	cli, link, _ := makeReturningCli(sizes, t)
+	r := overrideOutput(cli)
+	cli.AddSizesCommand(link)
+	err := cli.Run([]string{"--format", "yaml", "sizes"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	res := r.String()
+	if !strings.HasPrefix(res, "total: 8\n") || !strings.Contains(res, "id: id") {
+		t.Errorf("Sizes yaml doesn't match %q", res)
+	}
+}
+
+func TestFormatOptionError(t *testing.T) {
+	cli, link, _ := makeReturningCli(queue, t)
+	overrideOutput(cli)
+	AddQueueCommand(cli, link)
+	if err := cli.Run([]string{"--format", "xml", "queue"}); err == nil {
+		t.Errorf("Expected error not thrown")
+	}
+}
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HISTORY_FILE = "history.jsonl"
+
+	HistoryTemplate = `Job Id          Submitted          Script          [STATUS]        Profile
+{{range .}}{{.Id}}	{{.Submitted.Format "2006-01-02 15:04"}}	{{.Script}}	[{{if .Status}}{{.Status}}{{else}}UNKNOWN{{end}}]	{{.Profile}}
+{{end}}`
+)
+
+//Append-only file with a JSON record per line for every job sent by the client.
+//Records of the same job that come later replace the previous ones. An empty
+//path disables it
+var HistoryPath = filepath.Join(filepath.Dir(LastIdPath), HISTORY_FILE)
+
+//Job sent by the client
+type historyEntry struct {
+	Id        string    `json:"id" yaml:"id"`
+	Script    string    `json:"script" yaml:"script"`
+	Args      []string  `json:"args,omitempty" yaml:"args,omitempty"` //command line used to send it
+	Profile   string    `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Server    string    `json:"server" yaml:"server"`
+	Submitted time.Time `json:"submitted" yaml:"submitted"`
+	Status    string    `json:"status,omitempty" yaml:"status,omitempty"` //last status known by the client
+	Output    string    `json:"output,omitempty" yaml:"output,omitempty"`
+	Job       jobFile   `json:"job" yaml:"job"` //the invocation, used to rerun it
+}
+
+//Filters applied when listing the history
+type historyFilter struct {
+	script  string
+	status  string
+	profile string
+	limit   int //only the last n entries, 0 lists all of them
+}
+
+func (f historyFilter) match(entry historyEntry) bool {
+	return (f.script == "" || f.script == entry.Script) &&
+		(f.status == "" || strings.EqualFold(f.status, entry.Status)) &&
+		(f.profile == "" || f.profile == entry.Profile)
+}
+
+//Adds the history command, it lists the jobs sent by this client and reruns them
+func AddHistoryCommand(cli *Cli, link *PipelineLink) {
+	filter := historyFilter{}
+	cmd := cli.AddCommand("history", "Lists the jobs sent from this computer or reruns one of them", func(name string, args ...string) error {
+		if len(args) == 2 && args[0] == "rerun" {
+			entry, ok := findHistoryEntry(HistoryPath, args[1])
+			if !ok {
+				return newError(NotFoundError, nil, "Job %v not found in the history", args[1])
+			}
+			exec, err := entry.Job.execution(link, "")
+			if err != nil {
+				return err
+			}
+			exec.args = cli.args
+			return exec.run(cli.Output)
+		} else if len(args) > 0 {
+			return fmt.Errorf("history: wrong arguments %v, expected nothing or rerun JOB_ID", args)
+		}
+		entries, err := loadHistory(HistoryPath)
+		if err != nil {
+			return err
+		}
+		return commandBuilder{template: HistoryTemplate}.writeOutput(filterHistory(entries, filter), cli)
+	})
+	cmd.SetArity(-1, "[rerun JOB_ID]")
+	cmd.AddOption("script", "s", "Only list the jobs of this script", "", italic("SCRIPT"), func(name, value string) error {
+		filter.script = value
+		return nil
+	})
+	cmd.AddOption("status", "", "Only list the jobs with this status", "", italic("STATUS"), func(name, value string) error {
+		filter.status = value
+		return nil
+	})
+	cmd.AddOption("profile", "", "Only list the jobs sent using this configuration profile", "", italic("PROFILE"), func(name, value string) error {
+		filter.profile = value
+		return nil
+	})
+	cmd.AddOption("limit", "n", "Only list the last N jobs", "", italic("N"), func(name, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("--limit: %v is not a positive number", value)
+		}
+		filter.limit = n
+		return nil
+	})
+}
+
+//Command line without the values of the options that hold secrets
+func historyArgs(args []string) []string {
+	res := make([]string, len(args))
+	copy(res, args)
+	for idx, arg := range res {
+		if arg == "--"+CLIENTSECRET && idx+1 < len(res) {
+			res[idx+1] = "***"
+		} else if strings.HasPrefix(arg, "--"+CLIENTSECRET+"=") {
+			res[idx] = "--" + CLIENTSECRET + "=***"
+		}
+	}
+	return res
+}
+
+//Returns the entries that match the filter in submission order
+func filterHistory(entries []historyEntry, filter historyFilter) []historyEntry {
+	res := []historyEntry{}
+	for _, entry := range entries {
+		if filter.match(entry) {
+			res = append(res, entry)
+		}
+	}
+	if filter.limit > 0 && len(res) > filter.limit {
+		res = res[len(res)-filter.limit:]
+	}
+	return res
+}
+
+//Loads the history, the entries are sorted by the time they were first recorded
+func loadHistory(path string) ([]historyEntry, error) {
+	entries := []historyEntry{}
+	if path == "" {
+		return entries, nil
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	positions := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		entry := historyEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logWarn("Ignoring line %v of %v: %v", line, path, err)
+			continue
+		}
+		if pos, ok := positions[entry.Id]; ok {
+			entries[pos] = entry
+		} else {
+			positions[entry.Id] = len(entries)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+//Appends the entry to the history
+func appendHistory(path string, entry historyEntry) error {
+	if path == "" {
+		return nil
+	}
+	if err := mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+//Returns the last record of the job
+func findHistoryEntry(path, id string) (historyEntry, bool) {
+	entries, err := loadHistory(path)
+	if err != nil {
+		logWarn("Error loading the history: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Id == id {
+			return entry, true
+		}
+	}
+	return historyEntry{}, false
+}
+
+//Records the new status of a job present in the history
+func updateHistoryStatus(path, id, status string) error {
+	if status == "" {
+		return nil
+	}
+	entry, ok := findHistoryEntry(path, id)
+	if !ok || entry.Status == status {
+		return nil
+	}
+	entry.Status = status
+	return appendHistory(path, entry)
+}
+
+//Records the status of the job in the history, errors are only logged
+func recordJobStatus(id, status string) {
+	if err := updateHistoryStatus(HistoryPath, id, status); err != nil {
+		logWarn("Error updating the history: %v", err)
+	}
+}
+
+//Returns the id of the nth most recent job in the history, counting from 1
+func lastHistoryId(path string, n int) (string, error) {
+	entries, err := loadHistory(path)
+	if err != nil {
+		return "", err
+	}
+	if n < 1 || n > len(entries) {
+		return "", newError(NotFoundError, nil, "The history contains %v jobs, job %v not found", len(entries), n)
+	}
+	return entries[len(entries)-n].Id, nil
+}
+
+//Returns the id of the most recent job of the script in the history
+func lastScriptId(path, script string) (string, error) {
+	entries, err := loadHistory(path)
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Script == script {
+			return entries[i].Id, nil
+		}
+	}
+	return "", newError(NotFoundError, nil, "No jobs of the script %v in the history", script)
+}
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Points the history to a temporary file, returns a function that restores it
+func mockHistory(t *testing.T) func() {
+	folder, err := ioutil.TempDir("", "dp2_history")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	old := HistoryPath
+	HistoryPath = filepath.Join(folder, HISTORY_FILE)
+	return func() {
+		HistoryPath = old
+		os.RemoveAll(folder)
+	}
+}
+
+func TestHistoryAppendAndLoad(t *testing.T) {
+	defer mockHistory(t)()
+	now := time.Now()
+	appendHistory(HistoryPath, historyEntry{Id: "1", Script: "a", Submitted: now})
+	appendHistory(HistoryPath, historyEntry{Id: "2", Script: "b", Submitted: now})
+	if err := updateHistoryStatus(HistoryPath, "1", "SUCCESS"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := updateHistoryStatus(HistoryPath, "unknown", "SUCCESS"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	file, _ := os.OpenFile(HistoryPath, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("{broken\n")
+	file.Close()
+	entries, err := loadHistory(HistoryPath)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(entries) != 2 || entries[0].Id != "1" || entries[1].Id != "2" {
+		t.Fatalf("Wrong entries %+v", entries)
+	}
+	if entries[0].Status != "SUCCESS" || !entries[0].Submitted.Equal(now) {
+		t.Errorf("Entry not updated %+v", entries[0])
+	}
+	if entries, _ := loadHistory(""); len(entries) != 0 {
+		t.Errorf("An empty path should disable the history")
+	}
+}
+
+func TestFilterHistory(t *testing.T) {
+	entries := []historyEntry{
+		historyEntry{Id: "1", Script: "a", Status: "SUCCESS", Profile: "local"},
+		historyEntry{Id: "2", Script: "b", Status: "ERROR", Profile: "remote"},
+		historyEntry{Id: "3", Script: "a", Status: "ERROR", Profile: "remote"},
+	}
+	tests := []struct {
+		filter historyFilter
+		ids    []string
+	}{
+		{historyFilter{}, []string{"1", "2", "3"}},
+		{historyFilter{script: "a"}, []string{"1", "3"}},
+		{historyFilter{status: "error"}, []string{"2", "3"}},
+		{historyFilter{profile: "local"}, []string{"1"}},
+		{historyFilter{status: "error", limit: 1}, []string{"3"}},
+	}
+	for _, test := range tests {
+		ids := []string{}
+		for _, entry := range filterHistory(entries, test.filter) {
+			ids = append(ids, entry.Id)
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("Filter %+v: %v != %v", test.filter, ids, test.ids)
+		}
+	}
+}
+
+func TestHistoryArgs(t *testing.T) {
+	args := []string{"--client_secret", "s3cr3t", "--client_secret=other", "test", "--source", "a.xml"}
+	res := historyArgs(args)
+	if strings.Contains(strings.Join(res, " "), "s3cr3t") || strings.Contains(strings.Join(res, " "), "other") {
+		t.Errorf("Secret recorded %v", res)
+	}
+	if args[1] != "s3cr3t" || res[5] != "a.xml" {
+		t.Errorf("Wrong arguments %v %v", args, res)
+	}
+}
+
+func TestCheckIdFromHistory(t *testing.T) {
+	defer mockHistory(t)()
+	appendHistory(HistoryPath, historyEntry{Id: "1", Script: "a"})
+	appendHistory(HistoryPath, historyEntry{Id: "2", Script: "b"})
+	appendHistory(HistoryPath, historyEntry{Id: "3", Script: "b"})
+	tests := map[string]idSelector{
+		"3": idSelector{last: 1},
+		"1": idSelector{last: 3},
+		"2": idSelector{lastScript: "b", last: 2},
+	}
+	for exp, sel := range tests {
+		if id, err := checkId(sel, "status"); err != nil || id != exp {
+			t.Errorf("%+v: %v != %v (%v)", sel, id, exp, err)
+		}
+	}
+	if id, _ := checkId(idSelector{lastScript: "a"}, "status"); id != "1" {
+		t.Errorf("Wrong job of the script %v", id)
+	}
+	if _, err := checkId(idSelector{last: 4}, "status"); err == nil {
+		t.Errorf("Expected error not thrown for a job out of the history")
+	}
+	if _, err := checkId(idSelector{lastScript: "c"}, "status"); err == nil {
+		t.Errorf("Expected error not thrown for a script without jobs")
+	}
+}
+
+func TestScriptRecordsHistory(t *testing.T) {
+	defer mockHistory(t)()
+	folder := testTree(t, "book.xml", "a.xml")
+	defer os.RemoveAll(folder)
+	pipe := newPipelineTest(false)
+	link := &PipelineLink{FsAllow: true, pipeline: pipe}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddHistoryCommand(cli, link)
+	args := []string{"test", "-b", "--source", filepath.Join(folder, "a.xml"),
+		"--single", filepath.Join(folder, "book.xml"), "--test-opt", "x", "--another-opt", "foo"}
+	if err := cli.Run(args); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	entry, ok := findHistoryEntry(HistoryPath, "job-1")
+	if !ok {
+		t.Fatalf("Job not recorded")
+	}
+	if entry.Script != "test" || !reflect.DeepEqual(entry.Args, args) || !entry.Job.Background {
+		t.Errorf("Wrong entry %+v", entry)
+	}
+	if jobFileValues(entry.Job.Options["another-opt"])[0] != "foo" {
+		t.Errorf("Invocation not recorded %+v", entry.Job)
+	}
+
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"history", "--script", "test"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(w.String(), "job-1") {
+		t.Errorf("Job not listed %q", w.String())
+	}
+	if err := cli.Run([]string{"history", "rerun", "job-1"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, ok := findHistoryEntry(HistoryPath, "job-2"); !ok || pipe.sent != 2 {
+		t.Errorf("Job not rerun")
+	}
+	if err := cli.Run([]string{"history", "rerun", "unknown"}); err == nil {
+		t.Errorf("Expected error not thrown for a job out of the history")
+	}
+}
+
+//Jobs ending in error are recorded by the script commands and watch
+func TestHistoryRecordsErrors(t *testing.T) {
+	defer mockHistory(t)()
+	folder := testTree(t, "book.xml", "a.xml")
+	defer os.RemoveAll(folder)
+	pipe := newPipelineTest(false)
+	pipe.job = func(id string, msgSeq int) (pipeline.Job, error) {
+		return pipeline.Job{Id: id, Status: "ERROR"}, nil
+	}
+	link := &PipelineLink{FsAllow: true, pipeline: pipe}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddWatchCommand(cli, *link)
+	args := []string{"test", "-o", folder, "--source", filepath.Join(folder, "a.xml"),
+		"--single", filepath.Join(folder, "book.xml"), "--test-opt", "x", "--another-opt", "foo"}
+	if err := cli.Run(args); ExitCode(err) != 8 {
+		t.Errorf("Wrong error %v", err)
+	}
+	if entry, _ := findHistoryEntry(HistoryPath, "job-1"); entry.Status != "ERROR" {
+		t.Errorf("Status not recorded %+v", entry)
+	}
+	appendHistory(HistoryPath, historyEntry{Id: "job-2", Script: "test", Status: "RUNNING"})
+	if err := cli.Run([]string{"watch", "-q", "job-2"}); ExitCode(err) != 8 {
+		t.Errorf("Wrong error %v", err)
+	}
+	if entry, _ := findHistoryEntry(HistoryPath, "job-2"); entry.Status != "ERROR" {
+		t.Errorf("Status not recorded by watch %+v", entry)
+	}
+}
+
+//The paths of the jobs sent to a remote webservice are recorded before they
+//are packaged
+func TestHistoryRemotePaths(t *testing.T) {
+	defer mockHistory(t)()
+	folder := testTree(t, "books/book.xml", "books/a.xml")
+	defer os.RemoveAll(folder)
+	pipe := newPipelineTest(false)
+	pipe.fsallow = false
+	link := &PipelineLink{pipeline: pipe, config: copyConf()}
+	link.config[STARTING] = false
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	source, single := filepath.Join(folder, "books", "a.xml"), filepath.Join(folder, "books", "book.xml")
+	args := []string{"test", "-b", "--source", source, "--single", single, "--test-opt", "x", "--another-opt", "foo"}
+	if err := cli.Run(args); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !pipe.requested {
+		t.Fatalf("Job not sent")
+	}
+	entry, ok := findHistoryEntry(HistoryPath, "job-1")
+	if !ok {
+		t.Fatalf("Job not recorded")
+	}
+	if paths := jobFileValues(entry.Job.Inputs["source"]); len(paths) != 1 || paths[0] != source {
+		t.Errorf("Wrong source recorded %v", paths)
+	}
+	if paths := jobFileValues(entry.Job.Inputs["single"]); len(paths) != 1 || paths[0] != single {
+		t.Errorf("Wrong single recorded %v", paths)
+	}
+}
@@ -0,0 +1,274 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/daisy/pipeline-clientlib-go"
+	"launchpad.net/goyaml"
+)
+
+//Declarative description of a script invocation, see dp2 run and --save-job
+type jobFile struct {
+	Script     string                 `json:"script" yaml:"script"`
+	Nicename   string                 `json:"nicename,omitempty" yaml:"nicename,omitempty"`
+	Priority   string                 `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Inputs     map[string]interface{} `json:"inputs,omitempty" yaml:"inputs,omitempty"`   //a value or a list of values by port
+	Options    map[string]interface{} `json:"options,omitempty" yaml:"options,omitempty"` //a value or a list of values by option
+	Data       string                 `json:"data,omitempty" yaml:"data,omitempty"`       //zip file with the inputs for remote servers
+	Output     string                 `json:"output,omitempty" yaml:"output,omitempty"`
+	Zip        bool                   `json:"zip,omitempty" yaml:"zip,omitempty"`
+	Quiet      bool                   `json:"quiet,omitempty" yaml:"quiet,omitempty"`
+	Persistent bool                   `json:"persistent,omitempty" yaml:"persistent,omitempty"`
+	Background bool                   `json:"background,omitempty" yaml:"background,omitempty"`
+}
+
+//Adds the run command, it executes the job described in a job file
+func AddRunCommand(cli *Cli, link *PipelineLink) {
+	dryRun := false
+	cmd := cli.AddCommand("run", "Runs the script invocation described in a job file", func(command string, args ...string) error {
+		job, err := loadJobFile(args[0])
+		if err != nil {
+			return err
+		}
+		exec, err := job.execution(link, filepath.Dir(args[0]))
+		if err != nil {
+			return err
+		}
+		exec.dryRun = dryRun
+		exec.args = cli.args
+		return exec.run(cli.Output)
+	})
+	cmd.SetArity(1, "JOBFILE")
+	cmd.AddSwitch("dry-run", "", "Validate the job file and print the job request instead of sending it", func(string, string) error {
+		dryRun = true
+		return nil
+	})
+}
+
+//Reads the job file
+func loadJobFile(path string) (job jobFile, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return job, err
+	}
+	if err := goyaml.Unmarshal(data, &job); err != nil {
+		return job, fmt.Errorf("Error parsing the job file %v: %v", path, err)
+	}
+	if job.Script == "" {
+		return job, fmt.Errorf("The job file %v doesn't contain the script to run", path)
+	}
+	return job, nil
+}
+
+//Writes the job file
+func storeJobFile(path string, job jobFile) error {
+	data, err := goyaml.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+//Builds the job execution, the inputs and options go through the same
+//checks as the flags of the script command. Relative paths are resolved
+//against base (the job file folder) unless they refer to the data zip
+func (job jobFile) execution(link *PipelineLink, base string) (jobExecution, error) {
+	exec := jobExecution{
+		link:       link,
+		req:        newJobRequest(),
+		output:     job.Output,
+		verbose:    !job.Quiet,
+		persistent: job.Persistent,
+		zipped:     job.Zip,
+		extract:    defaultExtractOptions,
+	}
+	script, err := link.Script(job.Script)
+	if err != nil {
+		return exec, err
+	}
+	req := exec.req
+	req.Script = script.Id
+	req.Nicename = job.Nicename
+	req.Background = job.Background
+	if job.Priority != "" {
+		if !checkPriority(job.Priority) {
+			return exec, newError(ValidationError, nil, "%s is not a valid priority. Allowed values are high, medium and low", job.Priority)
+		}
+		req.Priority = job.Priority
+	}
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(base, path)
+	}
+	if exec.output != "" {
+		exec.output = resolve(exec.output)
+	}
+	if job.Data != "" {
+		req.dataFile = resolve(job.Data)
+		if req.Data, err = ioutil.ReadFile(req.dataFile); err != nil {
+			return exec, err
+		}
+	}
+	//paths inside the data zip are kept as they are
+	inData := func(path string) string {
+		if req.Data != nil {
+			return path
+		}
+		return resolve(path)
+	}
+	for _, name := range sortedKeys(job.Inputs) {
+		if _, ok := findInput(script, name); !ok {
+			return exec, fmt.Errorf("Script %v has no input %v", script.Id, name)
+		}
+		for _, value := range jobFileValues(job.Inputs[name]) {
+			if err := inputFunc(req, link)(name, inData(value)); err != nil {
+				return exec, err
+			}
+		}
+	}
+	for _, name := range sortedKeys(job.Options) {
+		option, ok := findOption(script, name)
+		if !ok {
+			return exec, fmt.Errorf("Script %v has no option %v", script.Id, name)
+		}
+		values := jobFileValues(job.Options[name])
+		if len(values) > 1 && !option.Sequence {
+			return exec, fmt.Errorf("Option %v only accepts one value", name)
+		}
+		for _, value := range values {
+			if isPathType(option.Type) {
+				value = inData(value)
+			}
+			if err := optionFunc(req, link, option.Type, option.Sequence)(name, value); err != nil {
+				return exec, err
+			}
+		}
+	}
+	for _, input := range script.Inputs {
+		if _, ok := req.Inputs[input.Name]; input.Required && !ok {
+			return exec, fmt.Errorf("The input %v is required by %v", input.Name, script.Id)
+		}
+	}
+	for _, option := range script.Options {
+		if _, ok := req.Options[option.Name]; option.Required && !ok {
+			return exec, fmt.Errorf("The option %v is required by %v", option.Name, script.Id)
+		}
+	}
+	return exec, nil
+}
+
+//Describes the job execution as a job file, local uris are written as paths. The
+//paths are made absolute unless they refer to the data zip, so the file can be run
+//from any folder
+func (j jobExecution) jobFile() jobFile {
+	abs := func(path string) string {
+		if path == "" || j.req.dataFile != "" {
+			return path
+		}
+		if p, err := filepath.Abs(path); err == nil {
+			return p
+		}
+		return path
+	}
+	job := jobFile{
+		Script:     j.req.Script,
+		Nicename:   j.req.Nicename,
+		Priority:   j.req.Priority,
+		Inputs:     make(map[string]interface{}),
+		Options:    make(map[string]interface{}),
+		Data:       j.req.dataFile,
+		Output:     j.output,
+		Zip:        j.zipped,
+		Quiet:      !j.verbose,
+		Persistent: j.persistent,
+		Background: j.req.Background,
+	}
+	if job.Data != "" {
+		job.Data, _ = filepath.Abs(job.Data)
+	}
+	if job.Output != "" {
+		job.Output, _ = filepath.Abs(job.Output)
+	}
+	for name, uris := range j.req.Inputs {
+		values := make([]string, len(uris))
+		for idx, u := range uris {
+			values[idx] = abs(uriToPath(u))
+		}
+		job.Inputs[name] = values
+	}
+	for name, values := range j.req.Options {
+		if !j.req.pathOptions[name] {
+			job.Options[name] = append([]string{}, values...)
+			continue
+		}
+		paths := make([]string, len(values))
+		for idx, value := range values {
+			paths[idx] = value
+			if u, err := url.Parse(value); err == nil {
+				paths[idx] = abs(uriToPath(*u))
+			}
+		}
+		job.Options[name] = paths
+	}
+	return job
+}
+
+//Returns the local path of file uris, other uris are returned as they are
+func uriToPath(u url.URL) string {
+	if u.Scheme != "file" {
+		if u.Opaque != "" {
+			return u.Opaque
+		}
+		return u.String()
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		//file:///C:/dir
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
+
+//Values of an input or option of the job file, either a single value or a list
+func jobFileValues(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return []string{}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case []string:
+		return v
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+//Finds the input port definition in the script
+func findInput(script pipeline.Script, name string) (pipeline.Input, bool) {
+	for _, input := range script.Inputs {
+		if input.Name == name {
+			return input, true
+		}
+	}
+	return pipeline.Input{}, false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
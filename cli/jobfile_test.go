@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const JOB_YAML = `script: test
+nicename: my job
+priority: high
+inputs:
+  single: book.xml
+  source:
+    - a.xml
+    - b.xml
+options:
+  another-opt: bar
+  test-opt: whatever
+output: out
+quiet: true
+`
+
+func writeJobFile(t *testing.T, folder, contents string) string {
+	path := filepath.Join(folder, "job.yml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return path
+}
+
+func TestJobFileExecution(t *testing.T) {
+	folder := testTree(t, "book.xml", "a.xml", "b.xml")
+	defer os.RemoveAll(folder)
+	job, err := loadJobFile(writeJobFile(t, folder, JOB_YAML))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	link := &PipelineLink{FsAllow: true, pipeline: newPipelineTest(false)}
+	exec, err := job.execution(link, folder)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	req := exec.req
+	if req.Script != "test" || req.Nicename != "my job" || req.Priority != "high" {
+		t.Errorf("Job values not set %+v", req)
+	}
+	if len(req.Inputs["source"]) != 2 {
+		t.Fatalf("Sequence not read %v", req.Inputs)
+	}
+	if u := req.Inputs["source"][1]; u.Scheme != "file" || !strings.HasSuffix(u.Path, "/b.xml") || !filepath.IsAbs(uriToPath(u)) {
+		t.Errorf("Input not resolved against the job file folder %v", u.String())
+	}
+	if req.Options["another-opt"][0] != "bar" {
+		t.Errorf("Option not set %v", req.Options)
+	}
+	if exec.output != filepath.Join(folder, "out") || exec.verbose {
+		t.Errorf("Output settings not read %v %v", exec.output, exec.verbose)
+	}
+}
+
+func TestJobFileErrors(t *testing.T) {
+	folder := testTree(t, "book.xml", "a.xml", "b.xml")
+	defer os.RemoveAll(folder)
+	link := &PipelineLink{FsAllow: true, pipeline: newPipelineTest(false)}
+	for _, broken := range []string{
+		strings.Replace(JOB_YAML, "another-opt: bar", "another-opt: baz", 1),
+		strings.Replace(JOB_YAML, "another-opt: bar", "another-opt: [foo, bar]", 1),
+		strings.Replace(JOB_YAML, "another-opt: bar", "unknown: bar", 1),
+		strings.Replace(JOB_YAML, "test-opt: whatever", "", 1),
+		strings.Replace(JOB_YAML, "priority: high", "priority: urgent", 1),
+		strings.Replace(JOB_YAML, "single: book.xml", "single: missing.xml", 1),
+	} {
+		job, err := loadJobFile(writeJobFile(t, folder, broken))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if _, err := job.execution(link, folder); err == nil {
+			t.Errorf("Expected error not thrown for\n%v", broken)
+		}
+	}
+	if _, err := loadJobFile(writeJobFile(t, folder, "nicename: no script\n")); err == nil {
+		t.Errorf("Expected error not thrown for a job file without script")
+	}
+}
+
+func TestJobFileRoundTrip(t *testing.T) {
+	folder := testTree(t, "book.xml", "a.xml", "b.xml")
+	defer os.RemoveAll(folder)
+	job, err := loadJobFile(writeJobFile(t, folder, JOB_YAML))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	link := &PipelineLink{FsAllow: true, pipeline: newPipelineTest(false)}
+	exec, err := job.execution(link, folder)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	saved := filepath.Join(folder, "saved.yml")
+	if err := storeJobFile(saved, exec.jobFile()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	reloaded, err := loadJobFile(saved)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	//run from a different folder
+	exec2, err := reloaded.execution(link, os.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for name, uris := range exec.req.Inputs {
+		for idx, u := range uris {
+			if exec2.req.Inputs[name][idx] != u {
+				t.Errorf("Input %v changed %v != %v", name, exec2.req.Inputs[name][idx], u)
+			}
+		}
+	}
+	if exec2.output != exec.output || exec2.req.Priority != "high" || exec2.verbose {
+		t.Errorf("Settings changed %+v", exec2)
+	}
+}
+
+func TestSaveJobOption(t *testing.T) {
+	folder := testTree(t, "book.xml", "a.xml")
+	defer os.RemoveAll(folder)
+	link := &PipelineLink{FsAllow: true, pipeline: newPipelineTest(false)}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	saved := filepath.Join(folder, "saved.yml")
+	err = cli.Run([]string{"test", "-b", "--save-job", saved, "--source", filepath.Join(folder, "a.xml"),
+		"--single", filepath.Join(folder, "book.xml"), "--test-opt", "x", "--another-opt", "foo"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	job, err := loadJobFile(saved)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !job.Background || jobFileValues(job.Options["another-opt"])[0] != "foo" {
+		t.Errorf("Invocation not stored %+v", job)
+	}
+	if values := jobFileValues(job.Inputs["single"]); len(values) != 1 || values[0] != filepath.Join(folder, "book.xml") {
+		t.Errorf("Input not stored as a path %v", values)
+	}
+}
@@ -1,19 +1,21 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"time"
 	"regexp"
+	"sort"
 
 	"github.com/daisy/pipeline-clientlib-go"
 )
 
 const (
-	MSG_WAIT = 1000 * time.Millisecond //waiting time for getting messages
+	MSG_WAIT     = 500 * time.Millisecond //waiting time for getting messages while the job is running
+	MSG_WAIT_MAX = 10 * time.Second       //maximum waiting time for getting messages while the job is idle
 )
 
 //Convinience for testing, propably move to pipeline-clientlib-go
@@ -64,17 +66,39 @@ func NewLink(conf Config) (pLink *PipelineLink) {
 }
 
 func (p *PipelineLink) Init() error {
-	log.Println("Initialising link")
-	p.pipeline.SetUrl(p.config.Url())
+	logDebug("Initialising link")
+	transport := p.connect()
 	if err := bringUp(p); err != nil {
 		return err
 	}
-	//set the credentials
+	return p.authenticate(transport)
+}
+
+//Points the client to the configured webservice, without checking that it's up
+func (p *PipelineLink) connect() *retryTransport {
+	p.pipeline.SetUrl(p.config.Url())
+	return installTransport(p.config)
+}
+
+//Fills the link with the values of the running webservice
+func (p *PipelineLink) setAlive(alive pipeline.Alive) {
+	p.Version = alive.Version
+	p.FsAllow = alive.FsAllow
+	p.Authentication = alive.Authentication
+}
+
+//Sets the credentials if the webservice requires authentication
+func (p *PipelineLink) authenticate(transport *retryTransport) error {
 	if p.Authentication {
-		if !(len(p.config[CLIENTKEY].(string)) > 0 && len(p.config[CLIENTSECRET].(string)) > 0) {
-			return errors.New("link: Authentication required but client_key and client_secret are not set. Please, check the configuration")
+		key, secret, err := resolveCredentials(p.config)
+		if err != nil {
+			return err
 		}
-		p.pipeline.SetCredentials(p.config[CLIENTKEY].(string), p.config[CLIENTSECRET].(string))
+		if !(len(key) > 0 && len(secret) > 0) {
+			return newError(AuthError, nil, "The webservice requires authentication but client_key and client_secret are not set. Please, check the configuration or run dp2 login")
+		}
+		p.pipeline.SetCredentials(key, secret)
+		transport.setCredentials(key, secret)
 	}
 	return nil
 }
@@ -82,6 +106,37 @@ func (p PipelineLink) IsLocal() bool {
 	return p.FsAllow
 }
 
+//The errors of the launcher explain what happened, the rest mean that it couldn't be run
+func launchError(err error) error {
+	var launch *Error
+	if errors.As(err, &launch) {
+		return err
+	}
+	return newError(ConnectionError, err, "Could not start the webservice, check the %v setting", EXECLINE)
+}
+
+//Launcher of the local webservice, it waits ws_timeup seconds for it to start
+func (p PipelineLink) launcher() Launcher {
+	return NewPipelineLauncher(p.pipeline, p.config.ExecPath(), configInt(p.config, WSTIMEUP))
+}
+
+//Launches the webservice after a pre-flight check of the java used to run it,
+//the launcher reports the actual failure if it doesn't start
+func (p PipelineLink) launch(w io.Writer) (pipeline.Alive, error) {
+	launcher := p.launcher()
+	found, err := CheckJava(MIN_JAVA_VERSION)
+	switch {
+	case err != nil && found == "":
+		logWarn("Please make sure that java %v or greater is accessible: %v", MIN_JAVA_VERSION, err)
+	case err != nil:
+		logWarn("Please make sure that java %v or greater is accessible, the java found is %v: %v", MIN_JAVA_VERSION, found, err)
+	default:
+		logInfo("Using %v", found)
+	}
+	launcher.java = found
+	return launcher.Launch(w)
+}
+
 //checks if the pipeline is up
 //otherwise it brings it up and fills the
 //link object
@@ -89,49 +144,68 @@ func bringUp(pLink *PipelineLink) error {
 	alive, err := pLink.pipeline.Alive()
 	if err != nil {
 		if pLink.config[STARTING].(bool) {
-			alive, err = NewPipelineLauncher(pLink.pipeline,
-				pLink.config.ExecPath(), pLink.config[TIMEOUT].(int)).Launch(os.Stdout)
+			//the output of the commands may be a json or yaml document
+			alive, err = pLink.launch(os.Stderr)
 			if err != nil {
-				return fmt.Errorf("Error bringing the pipeline2 up %v", err.Error())
+				return launchError(err)
 			}
 		} else {
-			return fmt.Errorf("Could not connect to the webservice and I'm not configured to start one\n\tError: %v", err.Error())
+			return newError(ConnectionError, err, "Could not connect to the webservice at %v and I'm not configured to start one", pLink.config.Url())
 		}
 	}
-	log.Println("Setting values")
-	pLink.Version = alive.Version
-	pLink.FsAllow = alive.FsAllow
-	pLink.Authentication = alive.Authentication
+	logDebug("Webservice %v up, version %v", pLink.config.Url(), alive.Version)
+	pLink.setAlive(alive)
 	return nil
 }
 
-//ScriptList returns the list of scripts available in the framework
+//ScriptList returns the list of scripts available in the framework, taken from
+//the cache when it's fresh
 func (p PipelineLink) Scripts() (scripts []pipeline.Script, err error) {
+	if scripts, ok := cachedScripts(ScriptsCachePath, p.config.Url(), p.Version, time.Now()); ok {
+		return scripts, nil
+	}
+	return p.RefreshScripts()
+}
+
+//RefreshScripts gets the definitions of the scripts from the framework and updates the cache
+func (p PipelineLink) RefreshScripts() (scripts []pipeline.Script, err error) {
 	scriptsStruct, err := p.pipeline.Scripts()
 	if err != nil {
 		return
 	}
-	scripts = make([]pipeline.Script, len(scriptsStruct.Scripts))
 	//fill the script list with the complete definition
-	for idx, script := range scriptsStruct.Scripts {
-		scripts[idx], err = p.pipeline.Script(script.Id)
-		if err != nil {
-			err = fmt.Errorf("Error loading script %v: %v", script.Id, err)
-			return nil, err
-		}
+	scripts, err = fetchScripts(p.pipeline, scriptsStruct.Scripts)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeScripts(ScriptsCachePath, p.config.Url(), p.Version, scripts, time.Now()); err != nil {
+		logWarn("Error storing the scripts cache: %v", err)
 	}
-	return scripts, err
+	return scripts, nil
+}
+
+//Script returns the complete definition of the script
+func (p PipelineLink) Script(id string) (script pipeline.Script, err error) {
+	return p.pipeline.Script(id)
 }
 
 //Gets the job identified by the jobId
 func (p PipelineLink) Job(jobId string) (job pipeline.Job, err error) {
 	job, err = p.pipeline.Job(jobId, 0)
+	if err == nil {
+		recordJobStatus(jobId, job.Status)
+	}
 	return
 }
 
 //Deletes the given job
 func (p PipelineLink) Delete(jobId string) (ok bool, err error) {
 	ok, err = p.pipeline.DeleteJob(jobId)
+	if err == nil && ok {
+		if err := updateJobIds(JobsCachePath, jobId, false); err != nil {
+			logWarn("Error updating the jobs cache: %v", err)
+		}
+	}
 	return
 }
 
@@ -149,6 +223,13 @@ func (p PipelineLink) Jobs() (jobs []pipeline.Job, err error) {
 		return
 	}
 	jobs = pJobs.Jobs
+	ids := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.Id)
+	}
+	if err := storeJobIds(JobsCachePath, ids); err != nil {
+		logWarn("Error storing the jobs cache: %v", err)
+	}
 	return
 }
 
@@ -228,68 +309,129 @@ func (m Message) String() string {
 }
 
 //Executes the job request and returns a channel fed with the job's messages,errors, and status.
-//The last message will have no contents but the status of the in which the job finished
-func (p PipelineLink) Execute(jobReq JobRequest) (job pipeline.Job, messages chan Message, err error) {
+//The last message will have no contents but the status of the in which the job finished.
+//Cancelling the context stops the feeding and closes the channel
+func (p PipelineLink) Execute(ctx context.Context, jobReq JobRequest) (job pipeline.Job, messages chan Message, err error) {
 	req, err := jobRequestToPipeline(jobReq, p)
 	if err != nil {
 		return
 	}
-	log.Printf("data len exec %v", len(jobReq.Data))
+	logDebug("Sending job request, data length %v", len(jobReq.Data))
 	job, err = p.pipeline.JobRequest(req, jobReq.Data)
 	if err != nil {
 		return
 	}
+	if err := updateJobIds(JobsCachePath, job.Id, true); err != nil {
+		logWarn("Error updating the jobs cache: %v", err)
+	}
 	messages = make(chan Message)
 	if !jobReq.Background {
-		go getAsyncMessages(p, job.Id, messages)
+		go getAsyncMessages(ctx, p, job.Id, messages)
 	} else {
 		close(messages)
 	}
 	return
 }
 
-//Feeds the channel with the messages describing the job's execution
-func getAsyncMessages(p PipelineLink, jobId string, messages chan Message) {
+//Returns a channel fed with the messages of an existing job, as Execute does
+func (p PipelineLink) Watch(ctx context.Context, jobId string) chan Message {
+	messages := make(chan Message)
+	go getAsyncMessages(ctx, p, jobId, messages)
+	return messages
+}
+
+//Feeds the channel with the messages describing the job's execution until the job
+//finishes or the context is cancelled, the channel is closed in both cases.
+//The polling interval grows while the job is waiting in the queue and is
+//reset once it starts running
+func getAsyncMessages(ctx context.Context, p PipelineLink, jobId string, messages chan Message) {
+	defer close(messages)
+	//never block if nobody is listening anymore
+	send := func(msg Message) bool {
+		select {
+		case messages <- msg:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 	msgNum := -1
+	wait := MSG_WAIT
+	failures := 0
 	for {
 		job, err := p.pipeline.Job(jobId, msgNum)
 		if err != nil {
-			messages <- Message{Error: err}
-			close(messages)
-			return
+			//a dropped poll doesn't stop following the job, unless
+			//asking again won't help
+			if failures++; !transientError(err) || failures > configInt(p.config, POLLFAILURES) {
+				send(Message{Error: err})
+				return
+			}
+			logWarn("Error getting the status of job %v (%v/%v): %v", jobId, failures, configInt(p.config, POLLFAILURES), err)
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return
+			}
 		}
+		failures = 0
 		n := msgNum
 		if len(job.Messages.Message) > 0 {
-			n = flattenMessages(job.Messages.Message, messages, job.Status, job.Messages.Progress, msgNum + 1, 0)
+			var ok bool
+			if n, ok = flattenMessages(job.Messages.Message, send, job.Status, job.Messages.Progress, msgNum + 1, 0); !ok {
+				return
+			}
 		}
 		if (n > msgNum) {
 			msgNum = n
-		} else {
-			messages <- Message{Progress: job.Messages.Progress}
+		} else if !send(Message{Progress: job.Messages.Progress}) {
+			return
 		}
 		if job.Status == "SUCCESS" || job.Status == "ERROR" || job.Status == "FAIL" {
-			messages <- Message{Status: job.Status}
-			close(messages)
+			send(Message{Status: job.Status})
+			return
+		}
+		wait = pollingInterval(job.Status, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
 			return
 		}
-		time.Sleep(MSG_WAIT)
 	}
 
 }
 
-//Flatten message coming from the Pipeline job and feed them into the channel
-//Return the sequence number of the last inner message
-func flattenMessages(from []pipeline.Message, to chan Message, status string, progress float64, firstNum int, depth int) (lastNum int) {
+//Returns the time to wait before asking for the job's messages again
+func pollingInterval(status string, last time.Duration) time.Duration {
+	if status != "IDLE" {
+		return MSG_WAIT
+	}
+	next := last * 2
+	if next > MSG_WAIT_MAX {
+		next = MSG_WAIT_MAX
+	}
+	return next
+}
+
+//Flatten message coming from the Pipeline job and feed them through the send function
+//Return the sequence number of the last inner message, or false as soon as send
+//reports that nobody is listening
+func flattenMessages(from []pipeline.Message, send func(Message) bool, status string, progress float64, firstNum int, depth int) (lastNum int, ok bool) {
 	for _, msg := range from {
 		lastNum = msg.Sequence
 		if lastNum >= firstNum {
-			to <- Message{Message: msg.Content, Level: msg.Level, Depth: depth, Status: status, Progress: progress}
+			if !send(Message{Message: msg.Content, Level: msg.Level, Depth: depth, Status: status, Progress: progress}) {
+				return lastNum, false
+			}
 		}
 		if len(msg.Message) > 0 {
-			lastNum = flattenMessages(msg.Message, to, status, progress, firstNum, depth + 1)
+			if lastNum, ok = flattenMessages(msg.Message, send, status, progress, firstNum, depth + 1); !ok {
+				return lastNum, false
+			}
 		}
 	}
-	return lastNum
+	return lastNum, true
 }
 
 func jobRequestToPipeline(req JobRequest, p PipelineLink) (pReq pipeline.JobRequest, err error) {
@@ -299,14 +441,27 @@ func jobRequestToPipeline(req JobRequest, p PipelineLink) (pReq pipeline.JobRequ
 		Nicename: req.Nicename,
 		Priority: req.Priority,
 	}
-	for name, values := range req.Inputs {
+	//sorted so the same request always produces the same document
+	inputs := make([]string, 0, len(req.Inputs))
+	for name := range req.Inputs {
+		inputs = append(inputs, name)
+	}
+	sort.Strings(inputs)
+	for _, name := range inputs {
+		values := req.Inputs[name]
 		input := pipeline.Input{Name: name}
 		for _, value := range values {
 			input.Items = append(input.Items, pipeline.Item{Value: value.String()})
 		}
 		pReq.Inputs = append(pReq.Inputs, input)
 	}
-	for name, values := range req.Options {
+	options := make([]string, 0, len(req.Options))
+	for name := range req.Options {
+		options = append(options, name)
+	}
+	sort.Strings(options)
+	for _, name := range options {
+		values := req.Options[name]
 		option := pipeline.Option{Name: name}
 		if len(values) > 1 {
 			for _, value := range values {
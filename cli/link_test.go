@@ -1,10 +1,14 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/daisy/pipeline-clientlib-go"
 )
@@ -250,6 +254,36 @@ func TestBadStart(t *testing.T) {
 	}
 }
 
+//The java is only checked when the webservice is launched
+func TestLaunchChecksJava(t *testing.T) {
+	back := javaVersionService
+	defer func() {
+		javaVersionService = back
+	}()
+	javaVersionService = func() (string, error) {
+		return `java version "1.8.0_45"`, nil
+	}
+	log, restore := captureLog(LogInfo, false)
+	defer restore()
+	conf := copyConf()
+	conf[STARTING] = true
+	conf[EXECLINE] = "nonexistingprogram"
+	link := PipelineLink{pipeline: newPipelineTest(false), config: conf}
+	if err := link.Init(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if log.Len() != 0 {
+		t.Errorf("Java checked for a running webservice %q", log.String())
+	}
+	link = PipelineLink{pipeline: newPipelineTest(true), config: conf}
+	if err := link.Init(); err == nil {
+		t.Errorf("Starting should've error'd")
+	}
+	if !strings.Contains(log.String(), `11 or greater is accessible, the java found is java version "1.8.0_45"`) {
+		t.Errorf("Old java not reported %q", log.String())
+	}
+}
+
 func TestScripts(t *testing.T) {
 	link := PipelineLink{pipeline: newPipelineTest(false)}
 	list, err := link.Scripts()
@@ -365,7 +399,7 @@ func TestJobRequestToPipeline(t *testing.T) {
 func TestAsyncMessagesErr(t *testing.T) {
 	link := PipelineLink{pipeline: newPipelineTest(true)}
 	chMsg := make(chan Message)
-	go getAsyncMessages(link, "jobId", chMsg)
+	go getAsyncMessages(context.Background(), link, "jobId", chMsg)
 	message := <-chMsg
 	if message.Error == nil {
 		t.Error("Expected error nil")
@@ -377,7 +411,7 @@ func TestAsyncMessages(t *testing.T) {
 	link := PipelineLink{pipeline: newPipelineTest(false)}
 	chMsg := make(chan Message)
 	var msgs []string
-	go getAsyncMessages(link, "jobId", chMsg)
+	go getAsyncMessages(context.Background(), link, "jobId", chMsg)
 	for msg := range chMsg {
 		msgs = append(msgs, msg.Message)
 	}
@@ -392,6 +426,67 @@ func TestAsyncMessages(t *testing.T) {
 	}
 }
 
+//Tests that no more messages are sent once send reports false
+func TestFlattenMessagesStops(t *testing.T) {
+	msgs := []pipeline.Message{
+		{Sequence: 0, Content: "Message 1", Message: []pipeline.Message{
+			{Sequence: 1, Content: "Message 2"},
+			{Sequence: 2, Content: "Message 3"},
+		}},
+		{Sequence: 3, Content: "Message 4"},
+	}
+	var sent []string
+	send := func(msg Message) bool {
+		sent = append(sent, msg.Message)
+		return len(sent) < 2
+	}
+	last, ok := flattenMessages(msgs, send, "RUNNING", 0, 0, 0)
+	if ok {
+		t.Errorf("Expected the flattening to stop")
+	}
+	if !reflect.DeepEqual(sent, []string{"Message 1", "Message 2"}) || last != 1 {
+		t.Errorf("Messages sent after send reported false %v (last %v)", sent, last)
+	}
+}
+
+//Tests that cancelling the context stops the polling and closes the channel
+func TestAsyncMessagesCancel(t *testing.T) {
+	pipe := newPipelineTest(false)
+	pipe.job = func(string, int) (pipeline.Job, error) {
+		return pipeline.Job{Status: "RUNNING"}, nil
+	}
+	link := PipelineLink{pipeline: pipe}
+	chMsg := make(chan Message)
+	ctx, cancel := context.WithCancel(context.Background())
+	go getAsyncMessages(ctx, link, "jobId", chMsg)
+	<-chMsg
+	cancel()
+	done := make(chan bool)
+	go func() {
+		for range chMsg {
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * MSG_WAIT_MAX):
+		t.Error("The channel wasn't closed after cancelling")
+	}
+}
+
+func TestPollingInterval(t *testing.T) {
+	wait := pollingInterval("IDLE", MSG_WAIT)
+	if wait != 2*MSG_WAIT {
+		t.Errorf("Idle jobs should back off %v", wait)
+	}
+	if wait = pollingInterval("IDLE", MSG_WAIT_MAX); wait != MSG_WAIT_MAX {
+		t.Errorf("The waiting time shouldn't grow over MSG_WAIT_MAX %v", wait)
+	}
+	if wait = pollingInterval("RUNNING", MSG_WAIT_MAX); wait != MSG_WAIT {
+		t.Errorf("Running jobs should be polled every MSG_WAIT %v", wait)
+	}
+}
+
 func TestIsLocal(t *testing.T) {
 	link := PipelineLink{FsAllow: true}
 	if !link.IsLocal() {
@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Severity of the log messages, only the messages of the configured level
+//or a more severe one are written
+type LogLevel int
+
+const (
+	LogError LogLevel = iota
+	LogWarn
+	LogInfo
+	LogDebug
+)
+
+//Names of the levels as used in the configuration
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+//Level used when neither debug nor log_level are set
+const DEFAULT_LOG_LEVEL = "warn"
+
+func (l LogLevel) String() string {
+	return strings.ToUpper(logLevelNames[l])
+}
+
+//Returns the level with the given name
+func parseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(level), nil
+		}
+	}
+	return LogWarn, newError(ValidationError, nil, "%v is not a valid log level. Allowed values are %v", name, strings.Join(logLevelNames, ", "))
+}
+
+//Writes the log messages of the cli to stderr or to the log file. The messages
+//of the standard logger, used by the client library, are only written at
+//debug level
+type logger struct {
+	mutex     sync.Mutex
+	level     LogLevel
+	traceHttp bool        //write the http requests and responses
+	path      string      //log file, stderr if empty
+	file      *os.File    //open log file
+	out       *log.Logger //destination of the messages
+}
+
+//The logger of the cli, configured with Config.UpdateDebug
+var logs = &logger{
+	level: LogWarn,
+	out:   log.New(redactor{os.Stderr}, "", log.LstdFlags|log.Lshortfile),
+}
+
+//Sets the level and the destination of the messages, the log file is opened
+//in append mode and it's only reopened if the path changes
+func (l *logger) configure(level LogLevel, path string, traceHttp bool) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.level = level
+	l.traceHttp = traceHttp
+	if path != l.path {
+		var file *os.File
+		var w io.Writer = os.Stderr
+		if path != "" {
+			var err error
+			if file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+				return fmt.Errorf("Error opening the log file %v: %v", path, err)
+			}
+			w = file
+		}
+		if l.file != nil {
+			l.file.Close()
+		}
+		l.file, l.path = file, path
+		l.out.SetOutput(redactor{w})
+	}
+	if level == LogDebug {
+		log.SetOutput(l.out.Writer())
+	} else {
+		log.SetOutput(ioutil.Discard)
+	}
+	return nil
+}
+
+//Whether the messages of the level are written
+func (l *logger) enabled(level LogLevel) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return level <= l.level
+}
+
+//Whether the informative messages are written to the terminal, where they
+//would be mixed with the output of the commands
+func (l *logger) toTerminal() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.path == "" && (l.level >= LogInfo || l.traceHttp)
+}
+
+func (l *logger) tracing() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.traceHttp
+}
+
+//Writes the message with the caller that is depth levels above
+func (l *logger) output(depth int, level string, format string, args ...interface{}) {
+	l.out.Output(depth+1, level+" "+fmt.Sprintf(format, args...))
+}
+
+func logError(format string, args ...interface{}) {
+	if logs.enabled(LogError) {
+		logs.output(2, LogError.String(), format, args...)
+	}
+}
+
+func logWarn(format string, args ...interface{}) {
+	if logs.enabled(LogWarn) {
+		logs.output(2, LogWarn.String(), format, args...)
+	}
+}
+
+func logInfo(format string, args ...interface{}) {
+	if logs.enabled(LogInfo) {
+		logs.output(2, LogInfo.String(), format, args...)
+	}
+}
+
+func logDebug(format string, args ...interface{}) {
+	if logs.enabled(LogDebug) {
+		logs.output(2, LogDebug.String(), format, args...)
+	}
+}
+
+//Signature of the authenticated requests
+var signParam = regexp.MustCompile(`([?&]sign=)[^&\s"]*`)
+
+//Hides the signature of the urls
+func redact(msg string) string {
+	return signParam.ReplaceAllString(msg, "${1}REDACTED")
+}
+
+//Writer that hides the signatures before writing the messages, the client
+//library logs whole requests
+type redactor struct {
+	w io.Writer
+}
+
+func (r redactor) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//Transport that writes the request and status lines of every http exchange
+//when --trace-http is used
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !logs.tracing() {
+		return t.base.RoundTrip(req)
+	}
+	start := time.Now()
+	logs.output(1, "HTTP", "--> %v %v", req.Method, req.URL)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		logs.output(1, "HTTP", "<-- %v %v failed after %v: %v", req.Method, req.URL.Path, time.Since(start), err)
+		return resp, err
+	}
+	logs.output(1, "HTTP", "<-- %v %v %v (%v, %v bytes)", req.Method, req.URL.Path, resp.Status, time.Since(start), resp.ContentLength)
+	return resp, err
+}
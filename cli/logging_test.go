@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//Sends the log to a buffer until the returned function is called
+func captureLog(level LogLevel, traceHttp bool) (*bytes.Buffer, func()) {
+	buf := new(bytes.Buffer)
+	logs.configure(level, "", traceHttp)
+	logs.out.SetOutput(redactor{buf})
+	if level == LogDebug {
+		log.SetOutput(logs.out.Writer())
+	}
+	return buf, func() {
+		logs.out.SetOutput(redactor{os.Stderr})
+		logs.configure(LogWarn, "", false)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, exp := range map[string]LogLevel{"error": LogError, "warn": LogWarn, "Info": LogInfo, "DEBUG": LogDebug} {
+		if level, err := parseLogLevel(name); err != nil || level != exp {
+			t.Errorf("%v: %v %v", name, level, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); ExitCode(err) != 6 {
+		t.Errorf("Wrong error %v", err)
+	}
+}
+
+func TestConfigLogLevel(t *testing.T) {
+	tests := []struct {
+		conf Config
+		exp  LogLevel
+	}{
+		{Config{}, LogWarn},
+		{Config{LOGLEVEL: "info"}, LogInfo},
+		{Config{LOGLEVEL: "info", DEBUG: true}, LogDebug},
+		{Config{LOGLEVEL: "error", DEBUG: false}, LogError},
+	}
+	for _, test := range tests {
+		if level := test.conf.logLevel(); level != test.exp {
+			t.Errorf("%v: %v != %v", test.conf, level, test.exp)
+		}
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	buf, restore := captureLog(LogInfo, false)
+	defer restore()
+	logDebug("debug message")
+	logInfo("info message")
+	logWarn("warn message")
+	log.Println("library message")
+	out := buf.String()
+	if strings.Contains(out, "debug message") || strings.Contains(out, "library message") {
+		t.Errorf("Messages below the level written %q", out)
+	}
+	if !strings.Contains(out, "logging_test.go") || !strings.Contains(out, "INFO info message") || !strings.Contains(out, "WARN warn message") {
+		t.Errorf("Messages not written %q", out)
+	}
+	if !logs.toTerminal() {
+		t.Errorf("The info messages are written to stderr")
+	}
+}
+
+func TestLogRedactsSignatures(t *testing.T) {
+	buf, restore := captureLog(LogDebug, false)
+	defer restore()
+	log.Printf("Request %v", "http://localhost:8181/ws/jobs?authid=key&time=now&nonce=1&sign=abc%2Bdef%3D")
+	out := buf.String()
+	if strings.Contains(out, "abc") || !strings.Contains(out, "nonce=1&sign=REDACTED") {
+		t.Errorf("Signature not redacted %q", out)
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dp2.log")
+	conf := Config{LOGLEVEL: "debug", LOGFILE: path}
+	conf.UpdateDebug()
+	logDebug("to the file")
+	if logs.toTerminal() {
+		t.Errorf("The log is written to a file")
+	}
+	Config{}.UpdateDebug()
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "DEBUG to the file") {
+		t.Errorf("Message not written to the file %q %v", data, err)
+	}
+}
+
+func TestTraceHttp(t *testing.T) {
+	server, _ := statusServer(http.StatusOK)
+	defer server.Close()
+	client := &http.Client{Transport: traceTransport{http.DefaultTransport}}
+	buf, restore := captureLog(LogWarn, false)
+	defer restore()
+	client.Get(server.URL + "/ws/alive?sign=secret")
+	if buf.Len() != 0 {
+		t.Errorf("Traced without --trace-http %q", buf.String())
+	}
+	logs.configure(LogWarn, "", true)
+	client.Get(server.URL + "/ws/alive?sign=secret")
+	out := buf.String()
+	if !strings.Contains(out, "--> GET "+server.URL+"/ws/alive?sign=REDACTED") || !strings.Contains(out, "<-- GET /ws/alive 200 OK") {
+		t.Errorf("Wrong trace %q", out)
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Signature not redacted %q", out)
+	}
+}
+
+func TestFollowMessagesWithLog(t *testing.T) {
+	_, restore := captureLog(LogDebug, false)
+	defer restore()
+	messages := make(chan Message, 2)
+	messages <- Message{Message: "Message 1", Progress: 0.5}
+	messages <- Message{Status: "SUCCESS", Progress: 1}
+	close(messages)
+	buf := new(bytes.Buffer)
+	if _, err := followMessages(buf, messages, true, nil); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Contains(buf.String(), "\033[1A") || strings.Contains(buf.String(), "█") {
+		t.Errorf("Progress bar drawn while logging to the terminal %q", buf.String())
+	}
+}
@@ -30,6 +30,15 @@ const (
 	SIZES_CALL         = "sizes"
 )
 
+//the tests don't use the caches and server files unless they set their paths
+func init() {
+	ScriptsCachePath = ""
+	JobsCachePath = ""
+	HistoryPath = ""
+	ServerPidPath = ""
+	ServerLogPath = ""
+}
+
 //Sets the output of the cli to a bytes.Buffer
 func overrideOutput(cli *Cli) *bytes.Buffer {
 	buf := make([]byte, 0)
@@ -87,6 +96,9 @@ type PipelineTest struct {
 	deleted        bool
 	resulted       bool
 	backgrounded   bool
+	requested      bool
+	listed         int //calls to Scripts
+	sent           int //calls to JobRequest, used as the id of the new jobs
 	authentication bool
 	fsallow        bool
 	call           string
@@ -95,6 +107,7 @@ type PipelineTest struct {
 	key            string
 	secret         string
 	withScripts    bool
+	job            func(string, int) (pipeline.Job, error)
 	jobs           func() (pipeline.Jobs, error)
 	delete         func(string) (bool, error)
 }
@@ -150,6 +163,7 @@ func (p *PipelineTest) Alive() (alive pipeline.Alive, err error) {
 }
 
 func (p *PipelineTest) Scripts() (scripts pipeline.Scripts, err error) {
+	p.listed++
 	if p.fail {
 		return scripts, errors.New("Error")
 	}
@@ -173,6 +187,9 @@ func (p *PipelineTest) ScriptUrl(id string) string {
 }
 
 func (p *PipelineTest) Job(id string, msgSeq int) (job pipeline.Job, err error) {
+	if p.job != nil {
+		return p.job(id, msgSeq)
+	}
 	p.call = JOB_CALL
 	_, err = p.mockCall()
 	if err != nil {
@@ -191,6 +208,9 @@ func (p *PipelineTest) Job(id string, msgSeq int) (job pipeline.Job, err error)
 }
 
 func (p *PipelineTest) JobRequest(newJob pipeline.JobRequest, data []byte) (job pipeline.Job, err error) {
+	p.requested = true
+	p.sent++
+	job.Id = fmt.Sprintf("job-%v", p.sent)
 	return
 }
 
@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//When the server is remote the inputs and the file and directory options are local
+//paths, they are packaged into the data zip and their uris rewritten relative to the zip root
+
+//Returns true if the values of the data type are paths
+func isPathType(t pipeline.DataType) bool {
+	switch t.(type) {
+	case pipeline.AnyFileURI, pipeline.AnyDirURI:
+		return true
+	}
+	return false
+}
+
+//Reference to a path from an input or an option of the request
+type pathRef struct {
+	path  string       //path as given by the user
+	isDir bool         //the path is a directory
+	set   func(string) //rewrites the reference
+}
+
+//Returns the references to paths of the request, sorted by input and option name
+func (r *JobRequest) pathRefs() []pathRef {
+	refs := []pathRef{}
+	inputs := make([]string, 0, len(r.Inputs))
+	for name := range r.Inputs {
+		inputs = append(inputs, name)
+	}
+	sort.Strings(inputs)
+	for _, name := range inputs {
+		values := r.Inputs[name]
+		for idx, u := range values {
+			idx := idx
+			p := u.Opaque
+			if p == "" {
+				p = u.Path
+			}
+			refs = append(refs, pathRef{path: p, set: func(p string) {
+				values[idx] = url.URL{Opaque: p}
+			}})
+		}
+	}
+	options := make([]string, 0, len(r.pathOptions))
+	for name := range r.pathOptions {
+		options = append(options, name)
+	}
+	sort.Strings(options)
+	for _, name := range options {
+		values := r.Options[name]
+		for idx, value := range values {
+			idx := idx
+			refs = append(refs, pathRef{path: value, set: func(p string) {
+				values[idx] = p
+			}})
+		}
+	}
+	return refs
+}
+
+//Builds the data zip with the local files referenced by the request and rewrites the
+//references relative to the zip root. If the data was given by the user it
+//checks that the references are included in it
+func (r *JobRequest) packageLocalFiles() error {
+	refs := r.pathRefs()
+	if len(refs) == 0 {
+		return nil
+	}
+	if len(r.Data) > 0 {
+		return checkInData(r.Data, refs)
+	}
+	paths := make([]string, len(refs))
+	dirs := make([]string, len(refs))
+	for idx, ref := range refs {
+		abs, err := filepath.Abs(filepath.FromSlash(ref.path))
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return newError(NotFoundError, nil, "%v not found", ref.path)
+		}
+		paths[idx] = abs
+		dirs[idx] = filepath.Dir(abs)
+		if info.IsDir() {
+			refs[idx].isDir = true
+			dirs[idx] = abs
+		}
+	}
+	root, err := commonRoot(dirs)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	added := make(map[string]bool)
+	for idx, ref := range refs {
+		if err := addToZip(zw, root, paths[idx], added); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, paths[idx])
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ref.isDir {
+			//the root itself becomes ./
+			rel += "/"
+		}
+		ref.set(rel)
+		refs[idx].path = rel
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	r.Data = buf.Bytes()
+	return checkInData(r.Data, refs)
+}
+
+//Returns the deepest directory containing all the directories
+func commonRoot(dirs []string) (string, error) {
+	root := dirs[0]
+	for _, dir := range dirs[1:] {
+		for !isInside(root, dir) {
+			parent := filepath.Dir(root)
+			if parent == root {
+				return "", fmt.Errorf("%v and %v don't have a common directory", root, dir)
+			}
+			root = parent
+		}
+	}
+	return root, nil
+}
+
+//Checks if path is dir or is inside dir
+func isInside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+//Adds the file, or the files inside the directory, to the zip. The entries already
+//added are skipped
+func addToZip(zw *zip.Writer, root, file string, added map[string]bool) error {
+	return filepath.Walk(file, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			//follow the links to files
+			if info, err = os.Stat(p); err != nil || info.IsDir() {
+				return err
+			}
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if added[name] {
+			return nil
+		}
+		added[name] = true
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+//Checks that every reference is a file or a directory of the data zip
+func checkInData(data []byte, refs []pathRef) error {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("The data is not a valid zip file: %v", err)
+	}
+	for _, ref := range refs {
+		name := strings.TrimPrefix(path.Clean(ref.path), "/")
+		if name == "." && len(reader.File) > 0 {
+			continue
+		}
+		found := false
+		for _, f := range reader.File {
+			if f.Name == name || strings.HasPrefix(f.Name, name+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%v is not included in the data zip", ref.path)
+		}
+	}
+	return nil
+}
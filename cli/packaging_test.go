@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Writes a data zip with the given entries and returns its path
+func testDataZip(t *testing.T, names ...string) string {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		w.Write([]byte("content"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	path := filepath.Join(os.TempDir(), "dp2_test_data.zip")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return path
+}
+
+//Creates the files inside a temporary folder, returns the folder
+func testTree(t *testing.T, names ...string) string {
+	folder, err := ioutil.TempDir("", "dp2_packaging")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, name := range names {
+		path := filepath.Join(folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	return folder
+}
+
+func zipEntries(t *testing.T, data []byte) map[string]bool {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	entries := make(map[string]bool)
+	for _, f := range reader.File {
+		entries[f.Name] = true
+	}
+	return entries
+}
+
+func TestIsPathType(t *testing.T) {
+	if !isPathType(pipeline.AnyFileURI{}) || !isPathType(pipeline.AnyDirURI{}) {
+		t.Errorf("Files and directories are paths")
+	}
+	if isPathType(pipeline.XsString{}) {
+		t.Errorf("Strings are not paths")
+	}
+}
+
+func TestPackageLocalFiles(t *testing.T) {
+	folder := testTree(t, "book/book.xml", "book/images/cover.png", "css/style.css")
+	defer os.RemoveAll(folder)
+	req := newJobRequest()
+	req.Inputs["source"] = []url.URL{url.URL{Opaque: filepath.ToSlash(filepath.Join(folder, "book", "book.xml"))}}
+	req.Options["images"] = []string{filepath.Join(folder, "book", "images")}
+	req.Options["stylesheet"] = []string{filepath.Join(folder, "css", "style.css")}
+	req.Options["title"] = []string{"not a path"}
+	req.pathOptions["images"] = true
+	req.pathOptions["stylesheet"] = true
+	if err := req.packageLocalFiles(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	entries := zipEntries(t, req.Data)
+	for _, name := range []string{"book/book.xml", "book/images/cover.png", "css/style.css"} {
+		if !entries[name] {
+			t.Errorf("%v not packaged %v", name, entries)
+		}
+	}
+	if len(entries) != 3 {
+		t.Errorf("Wrong number of entries %v", entries)
+	}
+	if req.Inputs["source"][0].String() != "book/book.xml" {
+		t.Errorf("Input not rewritten %v", req.Inputs["source"][0].String())
+	}
+	if req.Options["images"][0] != "book/images/" {
+		t.Errorf("Directory option not rewritten %v", req.Options["images"][0])
+	}
+	if req.Options["stylesheet"][0] != "css/style.css" {
+		t.Errorf("File option not rewritten %v", req.Options["stylesheet"][0])
+	}
+	if req.Options["title"][0] != "not a path" {
+		t.Errorf("Non path option rewritten %v", req.Options["title"][0])
+	}
+}
+
+func TestPackageLocalFilesRootDir(t *testing.T) {
+	folder := testTree(t, "a.xml", "sub/b.xml")
+	defer os.RemoveAll(folder)
+	req := newJobRequest()
+	req.Inputs["source"] = []url.URL{url.URL{Opaque: filepath.ToSlash(filepath.Join(folder, "sub", "b.xml"))}}
+	req.Options["dir"] = []string{folder}
+	req.pathOptions["dir"] = true
+	if err := req.packageLocalFiles(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if req.Options["dir"][0] != "./" {
+		t.Errorf("The root directory should be ./ %v", req.Options["dir"][0])
+	}
+	if len(zipEntries(t, req.Data)) != 2 {
+		t.Errorf("Files duplicated in the zip %v", zipEntries(t, req.Data))
+	}
+}
+
+func TestPackageLocalFilesMissing(t *testing.T) {
+	req := newJobRequest()
+	req.Inputs["source"] = []url.URL{url.URL{Opaque: "/tmp/dp2_this_file_does_not_exist.xml"}}
+	if err := req.packageLocalFiles(); err == nil {
+		t.Errorf("Expected error not thrown for a missing file")
+	}
+}
+
+func TestPackageLocalFilesWithData(t *testing.T) {
+	data, err := ioutil.ReadFile(testDataZip(t, "tmp/file", "dir/nested/file"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	req := newJobRequest()
+	req.Data = data
+	req.Inputs["source"] = []url.URL{url.URL{Opaque: "./tmp/file"}}
+	req.Options["dir"] = []string{"dir/nested/"}
+	req.pathOptions["dir"] = true
+	if err := req.packageLocalFiles(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if !bytes.Equal(req.Data, data) {
+		t.Errorf("The data given shouldn't be changed")
+	}
+	req.Inputs["source"] = []url.URL{url.URL{Opaque: "tmp/other"}}
+	if err := req.packageLocalFiles(); err == nil {
+		t.Errorf("Expected error not thrown for a file not in the zip")
+	}
+	req.Inputs["source"] = []url.URL{url.URL{Opaque: "tmp/fil"}}
+	if err := req.packageLocalFiles(); err == nil {
+		t.Errorf("Expected error not thrown for a prefix that isn't a directory")
+	}
+}
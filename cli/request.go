@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+//Name of the command that prints the job request of a script instead of running it
+const REQUEST_COMMAND = "request"
+
+//Adds the request command, dp2 request SCRIPT [OPTIONS] is the same as
+//dp2 SCRIPT --dry-run [OPTIONS] (see Cli.Run)
+func AddRequestCommand(cli *Cli, link *PipelineLink) {
+	cmd := cli.AddCommand(REQUEST_COMMAND, "Prints the job request of a script invocation without sending it", func(string, ...string) error {
+		return fmt.Errorf("request: a script and its options expected, e.g. dp2 request SCRIPT [OPTIONS]")
+	})
+	cmd.SetArity(-1, "SCRIPT [OPTIONS]")
+}
+
+//Replaces dp2 request SCRIPT by dp2 SCRIPT --dry-run, idx is the position of the command
+func expandRequest(args []string, idx int) []string {
+	if idx < 0 || args[idx] != REQUEST_COMMAND || idx+1 >= len(args) {
+		return args
+	}
+	res := append([]string{}, args[:idx]...)
+	res = append(res, args[idx+1], "--dry-run")
+	return append(res, args[idx+2:]...)
+}
+
+//Prints the job request document as it would be sent to the server along
+//with the contents of the data zip
+func printJobRequest(w io.Writer, link PipelineLink, req JobRequest) error {
+	pReq, err := jobRequestToPipeline(req, link)
+	if err != nil {
+		return err
+	}
+	doc, err := xml.MarshalIndent(pReq, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s%s\n", xml.Header, doc)
+	if len(req.Data) == 0 {
+		return nil
+	}
+	reader, err := zip.NewReader(bytes.NewReader(req.Data), int64(len(req.Data)))
+	if err != nil {
+		return fmt.Errorf("The data is not a valid zip file: %v", err)
+	}
+	fmt.Fprintf(w, "\nData zip (%v):\n", humanSize(int64(len(req.Data))))
+	for _, f := range reader.File {
+		fmt.Fprintf(w, "%10v  %v\n", humanSize(int64(f.UncompressedSize64)), f.Name)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandRequest(t *testing.T) {
+	args := []string{"--host", "http://remote", "request", "dtbook-to-epub3", "--source", "book.xml"}
+	exp := []string{"--host", "http://remote", "dtbook-to-epub3", "--dry-run", "--source", "book.xml"}
+	if res := expandRequest(args, 2); !reflect.DeepEqual(res, exp) {
+		t.Errorf("Wrong expansion %v", res)
+	}
+	args = []string{"request"}
+	if res := expandRequest(args, 0); !reflect.DeepEqual(res, args) {
+		t.Errorf("request without a script shouldn't be expanded %v", res)
+	}
+	args = []string{"jobs"}
+	if res := expandRequest(args, 0); !reflect.DeepEqual(res, args) {
+		t.Errorf("Other commands shouldn't be expanded %v", res)
+	}
+}
+
+func TestJobRequestToPipelineOrder(t *testing.T) {
+	req := newJobRequest()
+	for _, name := range []string{"zeta", "alpha", "mu", "beta", "omega", "gamma"} {
+		req.Options[name] = []string{name}
+		req.Inputs[name] = []url.URL{url.URL{Opaque: name}}
+	}
+	link := PipelineLink{pipeline: newPipelineTest(false)}
+	pReq, err := jobRequestToPipeline(*req, link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	exp := []string{"alpha", "beta", "gamma", "mu", "omega", "zeta"}
+	for idx, name := range exp {
+		if pReq.Options[idx].Name != name || pReq.Inputs[idx].Name != name {
+			t.Errorf("Wrong order at %v: %v %v", idx, pReq.Options[idx].Name, pReq.Inputs[idx].Name)
+		}
+	}
+}
+
+func TestScriptDryRun(t *testing.T) {
+	config := copyConf()
+	config[STARTING] = false
+	pipe := newPipelineTest(false)
+	pipe.fsallow = false
+	link := &PipelineLink{pipeline: pipe, config: config}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	w := overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddRequestCommand(cli, link)
+	err = cli.Run([]string{"request", "test", "-d", testDataZip(t, "tmp/file", "tmp/file2"), "--source", "./tmp/file",
+		"--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if pipe.requested {
+		t.Errorf("The job was sent to the server")
+	}
+	out := w.String()
+	for _, exp := range []string{"<?xml", "<jobRequest", `name="another-opt"`, "Data zip", "tmp/file2"} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("%q not found in the output\n%v", exp, out)
+		}
+	}
+	if strings.Index(out, `name="another-opt"`) > strings.Index(out, `name="test-opt"`) {
+		t.Errorf("Options not sorted\n%v", out)
+	}
+}
+
+func TestScriptDryRunValidation(t *testing.T) {
+	pipe := newPipelineTest(false)
+	link := &PipelineLink{FsAllow: true, pipeline: pipe}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	err = cli.Run([]string{"test", "--dry-run", "--source", os.TempDir(), "--single", os.TempDir(), "--test-opt", "x", "--another-opt", "baz"})
+	if err == nil {
+		t.Errorf("Expected error not thrown for a wrong option value")
+	}
+	if pipe.requested {
+		t.Errorf("The job was sent to the server")
+	}
+}
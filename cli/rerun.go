@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertfrees/go-subcommand"
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Name of the command that sends again a previous job
+const RERUN_COMMAND = "rerun"
+
+//Adds the rerun command, the options that follow the job id override the ones
+//of the original job (see Cli.setRawArgs)
+func AddRerunCommand(cli *Cli, link *PipelineLink) {
+	cmd := cli.AddCommand(RERUN_COMMAND, "Sends again a previous job, the options given replace the original ones", func(name string, args ...string) error {
+		return rerunJob(cli, link, args[0], cli.rawArgs)
+	})
+	cmd.SetArity(1, "JOB_ID [OPTIONS]")
+	cli.setRawArgs(cmd.Name)
+	cli.jobIdCommands[cmd.Name] = true
+}
+
+//Rebuilds the job from the history or, if it's not there, from the server, applies
+//the overriding options and sends it
+func rerunJob(cli *Cli, link *PipelineLink, id string, overrides []string) error {
+	job, err := previousJob(link, id)
+	if err != nil {
+		return err
+	}
+	script, err := link.Script(job.Script)
+	if err != nil {
+		return err
+	}
+	settings, err := overrideJob(cli.Name, script, &job, overrides)
+	if err != nil {
+		return err
+	}
+	//the paths of the overrides are relative to the current directory
+	exec, err := job.execution(link, "")
+	if err != nil {
+		return err
+	}
+	exec.extract = settings.extract
+	exec.dryRun = settings.dryRun
+	exec.saveJob = settings.saveJob
+	exec.args = cli.args
+	return exec.run(cli.Output)
+}
+
+//Returns the invocation of a job, the local history is preferred as the server
+//doesn't keep the data zip nor the output settings
+func previousJob(link *PipelineLink, id string) (jobFile, error) {
+	if entry, ok := findHistoryEntry(HistoryPath, id); ok {
+		return entry.Job, nil
+	}
+	job, err := link.Job(id)
+	if err != nil {
+		return jobFile{}, err
+	}
+	return serverJobFile(job)
+}
+
+//Builds the invocation of a job as reported by the server
+func serverJobFile(job pipeline.Job) (jobFile, error) {
+	res := jobFile{
+		Script:   job.Script.Id,
+		Nicename: job.Nicename,
+		Priority: job.Priority,
+		Inputs:   make(map[string]interface{}),
+		Options:  make(map[string]interface{}),
+	}
+	if res.Script == "" {
+		return res, fmt.Errorf("The server didn't report the script of job %v", job.Id)
+	}
+	for _, input := range job.Script.Inputs {
+		values := []string{}
+		for _, item := range input.Items {
+			u, err := url.Parse(item.Value)
+			if err != nil || u.Scheme != "file" {
+				return res, fmt.Errorf("The input %v of job %v is not a local file (%v), the job can only be rerun from the history", input.Name, job.Id, item.Value)
+			}
+			values = append(values, uriToPath(*u))
+		}
+		if len(values) > 0 {
+			res.Inputs[input.Name] = values
+		}
+	}
+	for _, option := range job.Script.Options {
+		values := []string{}
+		for _, item := range option.Items {
+			values = append(values, serverOptionValue(item.Value))
+		}
+		if len(option.Items) == 0 && option.Value != "" {
+			values = append(values, serverOptionValue(option.Value))
+		}
+		if len(values) > 0 {
+			res.Options[option.Name] = values
+		}
+	}
+	return res, nil
+}
+
+//Option values that are file uris are turned into paths so they are resolved again
+func serverOptionValue(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Scheme == "file" {
+		return uriToPath(*u)
+	}
+	return value
+}
+
+//Parses the overriding options and replaces the values of the job with them, the
+//inputs and options are named as in the script command and the rest of the flags
+//are the ones of the script command. Returns the settings that the job file doesn't
+//keep (--overwrite, --require-empty, --dry-run and --save-job)
+func overrideJob(name string, script pipeline.Script, job *jobFile, args []string) (settings jobExecution, err error) {
+	parser := subcommand.NewParser(name)
+	cmd := parser.AddCommand(RERUN_COMMAND, "", "", func(string, ...string) error { return nil })
+	cmd.SetArity(0, "")
+	//the first value replaces the original ones, the rest are added
+	replaced := make(map[string]bool)
+	override := func(values map[string]interface{}, port string) func(string, string) error {
+		return func(flag, value string) error {
+			if !replaced[flag] {
+				values[port] = []string{}
+				replaced[flag] = true
+			}
+			values[port] = append(jobFileValues(values[port]), value)
+			return nil
+		}
+	}
+	if job.Inputs == nil {
+		job.Inputs = make(map[string]interface{})
+	}
+	if job.Options == nil {
+		job.Options = make(map[string]interface{})
+	}
+	for _, input := range script.Inputs {
+		flag := getFlagName(input.Name, "i-", cmd.Flags())
+		cmd.AddOption(flag, "", input.ShortDesc, "", italic("FILE"), override(job.Inputs, input.Name))
+	}
+	for _, option := range script.Options {
+		flag := getFlagName(option.Name, "x-", cmd.Flags())
+		cmd.AddOption(flag, "", option.ShortDesc, "", italic("VALUE"), override(job.Options, option.Name))
+	}
+	//the flags start from the values of the original job
+	settings = jobExecution{
+		req: &JobRequest{
+			Nicename:   job.Nicename,
+			Priority:   job.Priority,
+			Background: job.Background,
+		},
+		output:     job.Output,
+		verbose:    !job.Quiet,
+		persistent: job.Persistent,
+		zipped:     job.Zip,
+		extract:    defaultExtractOptions,
+	}
+	addJobOptions(cmd, &settings)
+	if _, err = parser.Parse(append([]string{RERUN_COMMAND}, args...)); err != nil {
+		return settings, fmt.Errorf("rerun: %v", strings.TrimSpace(err.Error()))
+	}
+	job.Nicename = settings.req.Nicename
+	job.Priority = settings.req.Priority
+	job.Background = settings.req.Background
+	job.Output = settings.output
+	job.Quiet = !settings.verbose
+	job.Persistent = settings.persistent
+	job.Zip = settings.zipped
+	//an overriden output applies to the current directory
+	if job.Output != "" && !filepath.IsAbs(job.Output) {
+		job.Output, _ = filepath.Abs(job.Output)
+	}
+	return settings, nil
+}
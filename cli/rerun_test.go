@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+func TestOverrideJob(t *testing.T) {
+	job := jobFile{
+		Script:  "test",
+		Inputs:  map[string]interface{}{"source": []string{"a.xml", "b.xml"}, "single": []string{"book.xml"}},
+		Options: map[string]interface{}{"test-opt": []string{"x"}, "another-opt": []string{"foo"}},
+		Output:  "/tmp/out",
+	}
+	settings, err := overrideJob("dp2", SCRIPT, &job, []string{"--source", "c.xml", "--source", "d.xml", "--another-opt", "bar", "-b", "--dry-run",
+		"--overwrite", "never", "--require-empty", "--save-job", "job.yml"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !settings.dryRun || !job.Background {
+		t.Errorf("Switches not applied %v %+v", settings.dryRun, job)
+	}
+	if settings.extract != (extractOptions{overwrite: OVERWRITE_NEVER, requireEmpty: true}) || settings.saveJob != "job.yml" {
+		t.Errorf("Script command flags not applied %+v %v", settings.extract, settings.saveJob)
+	}
+	if values := jobFileValues(job.Inputs["source"]); !reflect.DeepEqual(values, []string{"c.xml", "d.xml"}) {
+		t.Errorf("Input not replaced %v", values)
+	}
+	if values := jobFileValues(job.Options["another-opt"]); !reflect.DeepEqual(values, []string{"bar"}) {
+		t.Errorf("Option not replaced %v", values)
+	}
+	if values := jobFileValues(job.Options["test-opt"]); !reflect.DeepEqual(values, []string{"x"}) || job.Output != "/tmp/out" {
+		t.Errorf("Values not overriden changed %v %v", values, job.Output)
+	}
+	if _, err := overrideJob("dp2", SCRIPT, &job, []string{"--unknown", "x"}); err == nil {
+		t.Errorf("Expected error not thrown for an unknown option")
+	}
+	if _, err := overrideJob("dp2", SCRIPT, &job, []string{"--priority", "urgent"}); err == nil {
+		t.Errorf("Expected error not thrown for an invalid priority")
+	}
+}
+
+func TestServerJobFile(t *testing.T) {
+	job := pipeline.Job{Id: "job", Nicename: "nice", Priority: "high"}
+	job.Script = pipeline.Script{
+		Id: "test",
+		Inputs: []pipeline.Input{
+			pipeline.Input{Name: "source", Items: []pipeline.Item{pipeline.Item{Value: "file:/tmp/a.xml"}, pipeline.Item{Value: "file:/tmp/b.xml"}}},
+		},
+		Options: []pipeline.Option{
+			pipeline.Option{Name: "test-opt", Value: "x"},
+			pipeline.Option{Name: "dir", Value: "file:/tmp/dir/"},
+			pipeline.Option{Name: "seq", Items: []pipeline.Item{pipeline.Item{Value: "1"}, pipeline.Item{Value: "2"}}},
+		},
+	}
+	res, err := serverJobFile(job)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if res.Script != "test" || res.Nicename != "nice" || res.Priority != "high" {
+		t.Errorf("Job values not read %+v", res)
+	}
+	if values := jobFileValues(res.Inputs["source"]); !reflect.DeepEqual(values, []string{filepath.FromSlash("/tmp/a.xml"), filepath.FromSlash("/tmp/b.xml")}) {
+		t.Errorf("Wrong inputs %v", values)
+	}
+	if jobFileValues(res.Options["test-opt"])[0] != "x" || jobFileValues(res.Options["dir"])[0] != filepath.FromSlash("/tmp/dir/") {
+		t.Errorf("Wrong options %v", res.Options)
+	}
+	if values := jobFileValues(res.Options["seq"]); len(values) != 2 {
+		t.Errorf("Sequence not read %v", values)
+	}
+	job.Script.Inputs[0].Items[0].Value = "a.xml"
+	if _, err := serverJobFile(job); err == nil {
+		t.Errorf("Expected error not thrown for inputs in a data zip")
+	}
+}
+
+func TestRerunCommand(t *testing.T) {
+	defer mockHistory(t)()
+	folder := testTree(t, "book.xml", "a.xml", "b.xml")
+	defer os.RemoveAll(folder)
+	pipe := newPipelineTest(false)
+	link := &PipelineLink{FsAllow: true, pipeline: pipe}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddRerunCommand(cli, link)
+	err = cli.Run([]string{"test", "-b", "--source", filepath.Join(folder, "a.xml"),
+		"--single", filepath.Join(folder, "book.xml"), "--test-opt", "x", "--another-opt", "foo"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := cli.Run([]string{"rerun", "job-1", "--another-opt", "bar", "--source", filepath.Join(folder, "b.xml")}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	entry, ok := findHistoryEntry(HistoryPath, "job-2")
+	if !ok {
+		t.Fatalf("Job not rerun")
+	}
+	if values := jobFileValues(entry.Job.Options["another-opt"]); !reflect.DeepEqual(values, []string{"bar"}) {
+		t.Errorf("Option not overriden %v", values)
+	}
+	if values := jobFileValues(entry.Job.Inputs["source"]); len(values) != 1 || values[0] != filepath.Join(folder, "b.xml") {
+		t.Errorf("Input not overriden %v", values)
+	}
+	if values := jobFileValues(entry.Job.Options["test-opt"]); values[0] != "x" || !entry.Job.Background {
+		t.Errorf("Original values lost %+v", entry.Job)
+	}
+	if err := cli.Run([]string{"rerun", "job-1", "--another-opt", "not-a-choice"}); err == nil {
+		t.Errorf("Expected error not thrown for a wrong option value")
+	}
+	if pipe.sent != 2 {
+		t.Errorf("A wrong job was sent")
+	}
+}
@@ -1,15 +1,18 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 	"regexp"
 	"strconv"
 
@@ -22,6 +25,9 @@ import (
 //set the last id path (in utils)
 var LastIdPath = getLastIdPath(runtime.GOOS)
 
+//Returned when the user interrupts the job execution
+var errInterrupted = errors.New("Job execution interrupted")
+
 //Represents the job request
 type JobRequest struct {
 	Script     string               //Script id to call
@@ -31,13 +37,17 @@ type JobRequest struct {
 	Inputs     map[string][]url.URL //Input ports for the script
 	Data       []byte               //Data to send with the job request
 	Background bool                 //Send the request and return
+
+	pathOptions map[string]bool //Options whose values are files or directories
+	dataFile    string          //Path of the data zip
 }
 
 //Creates a new JobRequest
 func newJobRequest() *JobRequest {
 	return &JobRequest{
 		Options: make(map[string][]string),
-		Inputs:  make(map[string][]url.URL),
+		Inputs:      make(map[string][]url.URL),
+		pathOptions: make(map[string]bool),
 	}
 }
 
@@ -59,24 +69,51 @@ type jobExecution struct {
 	verbose    bool
 	persistent bool
 	zipped     bool
+	extract    extractOptions
+	saveJob    string   //job file where the invocation is stored
+	dryRun     bool     //print the job request instead of sending it
+	args       []string //command line recorded in the history
 }
 
 func (j jobExecution) run(stdOut io.Writer) error {
-	log.Printf("run data len %v\n", len(j.req.Data))
+	logDebug("Running job request, data length %v", len(j.req.Data))
 	//manual check of output
-	if !j.req.Background && j.output == "" {
-		return errors.New("--output option is mandatory if the job is not running in the req.Background")
+	if !j.dryRun && !j.req.Background && j.output == "" {
+		return newError(UsageError, nil, "--output option is mandatory if the job is not running in the background")
 	}
-	if j.req.Background && j.output != "" {
-		fmt.Printf("Warning: --output option ignored as the job will run in the background\n")
+	if !j.dryRun && j.req.Background && j.output != "" {
+		logWarn("--output option ignored as the job will run in the background")
 	}
 	storeId := j.req.Background || j.persistent
+	//the invocation is taken before the local files are packaged, which
+	//makes their paths relative to the zip
+	invocation := j.jobFile()
+	if j.saveJob != "" {
+		if err := storeJobFile(j.saveJob, invocation); err != nil {
+			return err
+		}
+		//only the request is printed in dry runs
+		if !j.dryRun {
+			fmt.Fprintf(stdOut, "Job stored in %v\n", j.saveJob)
+		}
+	}
+	if !j.link.IsLocal() {
+		if err := j.req.packageLocalFiles(); err != nil {
+			return err
+		}
+	}
+	if j.dryRun {
+		return printJobRequest(stdOut, *j.link, *j.req)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	//send the job
-	job, messages, err := j.link.Execute(*(j.req))
+	job, messages, err := j.link.Execute(ctx, *(j.req))
 	if err != nil {
 		return err
 	}
 	fmt.Fprintf(stdOut, "Job %v sent to the server\n", job.Id)
+	j.record(job, invocation)
 	//store id if it suits
 	if storeId {
 		err = storeLastId(job.Id)
@@ -84,60 +121,122 @@ func (j jobExecution) run(stdOut io.Writer) error {
 			return err
 		}
 	}
+	if j.req.Background {
+		return nil
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
 	//get realtime messages, status and progress from the webservice
-	status := job.Status
-	progress := 0.0
-	printProgressBar(stdOut, progress)
-	for msg := range messages {
-		if msg.Error != nil {
-			err = msg.Error
-			return err
-		}
-		if j.verbose && msg.Message != "" || msg.Progress > progress {
-			//erase the progress bar (last two lines)
-			//FIXME: don't do this when debug logging enabled
-			fmt.Fprint(stdOut, "\n\033[1A\033[K\033[1A\033[K")
-			if j.verbose && msg.Message != "" {
-				fmt.Fprintf(stdOut, "%v\n", msg.String())
-			}
-			if (msg.Progress > progress) {
-				progress = msg.Progress
-			}
-			printProgressBar(stdOut, progress)
-		}
-		status = msg.Status
+	status, err := followMessages(stdOut, messages, j.verbose, interrupt)
+	signal.Stop(interrupt)
+	if err == errInterrupted {
+		cancel()
+		return interruptedJob(stdOut, *j.link, job.Id)
+	} else if err != nil {
+		return err
 	}
+	recordJobStatus(job.Id, status)
 
 	if status != "ERROR" {
 		//get the data
-		if !j.req.Background {
-			wc, err := zipProcessor(j.output, j.zipped)
+		ok, err := downloadResults(*j.link, job.Id, j.output, j.zipped, j.extract, stdOut)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdOut)
+		if !j.persistent {
+			_, err = j.link.Delete(job.Id)
 			if err != nil {
 				return err
 			}
-			ok, err := j.link.Results(job.Id, wc)
-			if err != nil {
-				return err
+			fmt.Fprintf(stdOut, "The job has been deleted from the server\n")
+		}
+		fmt.Fprintf(stdOut, "Job finished with status: %v\n", status)
+		if (!ok && (status == "SUCCESS" || status == "FAIL")) {
+			fmt.Fprintf(stdOut, "No results available\n")
+		}
+	}
+	return jobStatusError(job.Id, status)
+}
+
+//Records the job in the history, errors are only logged as the job was sent anyway
+func (j jobExecution) record(job pipeline.Job, invocation jobFile) {
+	output := ""
+	if !j.req.Background && j.output != "" {
+		output, _ = filepath.Abs(j.output)
+	}
+	entry := historyEntry{
+		Id:        job.Id,
+		Script:    j.req.Script,
+		Args:      historyArgs(j.args),
+		Profile:   fmt.Sprint(j.link.config[PROFILE]),
+		Server:    j.link.config.Url(),
+		Submitted: time.Now(),
+		Status:    job.Status,
+		Output:    output,
+		Job:       invocation,
+	}
+	if err := appendHistory(HistoryPath, entry); err != nil {
+		logWarn("Error storing the job in the history: %v", err)
+	}
+}
+
+//Prints the job's messages and the progress bar until the job finishes or the
+//user interrupts the execution (errInterrupted). Returns the last job status
+func followMessages(stdOut io.Writer, messages chan Message, verbose bool, interrupt <-chan os.Signal) (status string, err error) {
+	progress := 0.0
+	//the log messages would break the progress bar
+	bar := !logs.toTerminal()
+	if bar {
+		printProgressBar(stdOut, progress)
+	}
+	for {
+		select {
+		case <-interrupt:
+			return status, errInterrupted
+		case msg, ok := <-messages:
+			if !ok {
+				return status, nil
 			}
-			if err := wc.Close(); err != nil {
-				return err
+			if msg.Error != nil {
+				return status, msg.Error
 			}
-			fmt.Fprintln(stdOut)
-			if !j.persistent {
-				_, err = j.link.Delete(job.Id)
-				if err != nil {
-					return err
+			if verbose && msg.Message != "" || msg.Progress > progress {
+				//erase the progress bar (last two lines)
+				if bar {
+					fmt.Fprint(stdOut, "\n\033[1A\033[K\033[1A\033[K")
+				}
+				if verbose && msg.Message != "" {
+					fmt.Fprintf(stdOut, "%v\n", msg.String())
+				}
+				if (msg.Progress > progress) {
+					progress = msg.Progress
+				}
+				if bar {
+					printProgressBar(stdOut, progress)
 				}
-				fmt.Fprintf(stdOut, "The job has been deleted from the server\n")
-			}
-			fmt.Fprintf(stdOut, "Job finished with status: %v\n", status)
-			if (!ok && (status == "SUCCESS" || status == "FAIL")) {
-				fmt.Fprintf(stdOut, "No results available\n")
 			}
+			status = msg.Status
 		}
+	}
+}
 
+//Asks the user whether the interrupted job should be left running in the
+//server, otherwise it's deleted
+func interruptedJob(stdOut io.Writer, link PipelineLink, id string) error {
+	fmt.Fprintln(stdOut)
+	if askYesNo(stdOut, fmt.Sprintf("Interrupted. Leave job %v running on the server?", id)) {
+		if err := storeLastId(id); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdOut, "Job %v left running on the server, check it with the status command and --lastid\n", id)
+		return nil
 	}
-	return nil
+	if _, err := link.Delete(id); err != nil {
+		return fmt.Errorf("Job %v interrupted but couldn't be deleted: %v", id, err)
+	}
+	fmt.Fprintf(stdOut, "The job has been deleted from the server\n")
+	return errInterrupted
 }
 
 func printProgressBar(stdOut io.Writer, value float64) {
@@ -155,7 +254,7 @@ func printProgressBar(stdOut io.Writer, value float64) {
 	fmt.Fprintf(stdOut, "%v\n%v %.1f%% ", line, bar, value * 100)
 }
 
-var commonFlags = []string{"--output", "--zip", "--nicename", "--priority", "--quiet", "--persistent", "--background"}
+var commonFlags = []string{"--output", "--zip", "--overwrite", "--require-empty", "--nicename", "--priority", "--quiet", "--persistent", "--background", "--save-job", "--dry-run"}
 
 func getFlagName(name, prefix string, flags []subcommand.Flag) string {
 	flaggedName := "--" + name
@@ -191,6 +290,7 @@ func scriptToCommand(script pipeline.Script, cli *Cli, link *PipelineLink) (req
 		output:  "",
 		verbose: true,
 		zipped:  false,
+		extract: defaultExtractOptions,
 	}
 	desc := blackterm.MarkdownString(script.Description)
 	command := cli.AddScriptCommand(
@@ -198,6 +298,7 @@ func scriptToCommand(script pipeline.Script, cli *Cli, link *PipelineLink) (req
 		desc,
 		fmt.Sprintf("%s [v%s]", desc, script.Version),
 		func(string, ...string) error {
+			jExec.args = cli.args
 			if err := jExec.run(cli.Output); err != nil {
 				return err
 			}
@@ -206,6 +307,8 @@ func scriptToCommand(script pipeline.Script, cli *Cli, link *PipelineLink) (req
 		jobRequest,
 	)
 	command.SetArity(0, "")
+	types := make(map[string]pipeline.DataType)
+	cli.flagTypes[script.Id] = types
 
 	for _, input := range script.Inputs {
 		name := getFlagName(input.Name, "i-", command.Flags())
@@ -222,6 +325,7 @@ func scriptToCommand(script pipeline.Script, cli *Cli, link *PipelineLink) (req
 			shortDesc = input.NiceName
 		}
 		command.AddOption(name, "", shortDesc, longDesc, italic("FILE"), inputFunc(jobRequest, link)).Must(input.Required)
+		types[name] = pipeline.AnyFileURI{}
 	}
 
 	for _, option := range script.Options {
@@ -254,45 +358,61 @@ func scriptToCommand(script pipeline.Script, cli *Cli, link *PipelineLink) (req
 		command.AddOption(
 			name, "", shortDesc, longDesc, optionTypeToString(option.Type, name, option.Default),
 			optionFunc(jobRequest, link, option.Type, option.Sequence)).Must(option.Required)
+		types[name] = option.Type
 	}
-	command.AddOption("output", "o", "Path where to store the results. This option is mandatory when the job is not executed in the background", "", italic("DIRECTORY"), func(name, folder string) error {
+	addJobOptions(command, &jExec)
+
+	return jobRequest, nil
+}
+
+//Adds the flags that control how the job is executed rather than what the script
+//does, they are shared by the script commands and the rerun overrides
+func addJobOptions(cmd *subcommand.Command, jExec *jobExecution) {
+	cmd.AddOption("output", "o", "Path where to store the results. This option is mandatory when the job is not executed in the background", "", italic("DIRECTORY"), func(name, folder string) error {
 		jExec.output = folder
 		return nil
 	})
-	command.AddSwitch("zip", "z", "Write the output to a zip file rather than to a folder", func(string, string) error {
+	cmd.AddSwitch("zip", "z", "Write the output to a zip file rather than to a folder", func(string, string) error {
 		jExec.zipped = true
 		return nil
 	})
+	addExtractOptions(cmd, &jExec.extract)
 
-	command.AddOption("nicename", "n", "Set job's nice name", "", italic("NICENAME"), func(name, nice string) error {
+	cmd.AddOption("nicename", "n", "Set job's nice name", "", italic("NICENAME"), func(name, nice string) error {
 		jExec.req.Nicename = nice
 
 		return nil
 	})
-	command.AddOption("priority", "r", "Set job's priority", "", "(high|" + underline("medium") + "|low)", func(name, priority string) error {
+	cmd.AddOption("priority", "r", "Set job's priority", "", "(high|" + underline("medium") + "|low)", func(name, priority string) error {
 		if checkPriority(priority) {
 			jExec.req.Priority = priority
 			return nil
 		} else {
-			return fmt.Errorf("%s is not a valid priority. Allowed values are high, medium and low",
+			return newError(ValidationError, nil, "%s is not a valid priority. Allowed values are high, medium and low",
 				priority)
 		}
 	})
-	command.AddSwitch("quiet", "q", "Do not print the job's messages", func(string, string) error {
+	cmd.AddSwitch("quiet", "q", "Do not print the job's messages", func(string, string) error {
 		jExec.verbose = false
 		return nil
 	})
-	command.AddSwitch("persistent", "p", "Do not delete the job after it is executed", func(string, string) error {
+	cmd.AddSwitch("persistent", "p", "Do not delete the job after it is executed", func(string, string) error {
 		jExec.persistent = true
 		return nil
 	})
 
-	command.AddSwitch("background", "b", "Sends the job and exits", func(string, string) error {
+	cmd.AddSwitch("background", "b", "Sends the job and exits", func(string, string) error {
 		jExec.req.Background = true
 		return nil
 	})
-
-	return jobRequest, nil
+	cmd.AddSwitch("dry-run", "", "Validate the options and print the job request instead of sending it", func(string, string) error {
+		jExec.dryRun = true
+		return nil
+	})
+	cmd.AddOption("save-job", "", "Store this invocation in a job file that can be executed with dp2 run", "", italic("FILE"), func(name, path string) error {
+		jExec.saveJob = path
+		return nil
+	})
 }
 
 func optionTypeToString(optionType pipeline.DataType, optionName string, defaultValue string) string {
@@ -425,25 +545,26 @@ func optionTypeToDetailedHelp(optionType pipeline.DataType) string {
 }
 
 func (c *ScriptCommand) addDataOption() {
-	c.AddOption("data", "d", "Zip file containing the files to convert", "", "", func(name, path string) error {
+	c.AddOption("data", "d", "Zip file containing the files to convert, if omitted the local files are packaged", "", "", func(name, path string) error {
 		file, err := os.Open(path)
 		defer func() {
 			err := file.Close()
 			if err != nil {
-				log.Printf("Error closing file %v :%v", path, err.Error())
+				logWarn("Error closing file %v :%v", path, err.Error())
 			}
 		}()
 		if err != nil {
 			return err
 		}
+		c.req.dataFile = path
 		c.req.Data, err = ioutil.ReadAll(file)
 		//FIXME: this breaks the tests, but focused in a different thing right now
 		//if err != nil {
 		//return err
 		//}
-		log.Printf("data len %v\n", len(c.req.Data))
+		logDebug("Job data length %v", len(c.req.Data))
 		return nil
-	}).Must(true)
+	}).Must(false)
 }
 
 //Returns a function that fills the request info with the subcommand option name
@@ -476,6 +597,12 @@ func optionFunc(req *JobRequest, link *PipelineLink, optionType pipeline.DataTyp
 			name = name[2:]
 		}
 		var err error
+		if isPathType(optionType) {
+			if req.pathOptions == nil {
+				req.pathOptions = make(map[string]bool)
+			}
+			req.pathOptions[name] = true
+		}
 		if sequence {
 			for _, v := range strings.Split(value, ",") {
 				v, err = validateOption(v, optionType, link)
@@ -500,7 +627,7 @@ func validationError(optionName, value string, cause error) error {
 	if cause != nil {
 		msg += (": " + cause.Error())
 	}
-	return errors.New(msg)
+	return newError(ValidationError, nil, msg)
 }
 
 func validateOption(value string, optionType pipeline.DataType, link *PipelineLink) (result string, err error) {
@@ -627,8 +754,8 @@ func pathToUri(path string, basePath string) (u *url.URL, err error) {
 			err = errors.New("uri not absolute: " + u.String())
 		}
 	} else {
-		// FIXME: check if file present in zip
-		//TODO is opaque really apropriate?
+		//the files are packaged or checked against the data zip
+		//before sending the request (see packageLocalFiles)
 		u = &url.URL{
 			Opaque: toSlash(path),
 		}
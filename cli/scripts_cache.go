@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+const (
+	SCRIPTS_CACHE_FILE = "scripts.json"
+	SCRIPTS_CACHE_TTL  = 24 * time.Hour //the definitions are fetched again after this time
+	SCRIPTS_WORKERS    = 8              //concurrent requests when fetching the definitions
+)
+
+//File where the script definitions are cached, next to the last id file. An
+//empty path disables the cache
+var ScriptsCachePath = filepath.Join(filepath.Dir(LastIdPath), SCRIPTS_CACHE_FILE)
+
+//Definitions of the scripts of a server
+type scriptsCacheEntry struct {
+	Version string         `json:"version"` //framework version
+	Updated time.Time      `json:"updated"`
+	Scripts []cachedScript `json:"scripts"`
+}
+
+//The option types are interfaces, they are stored apart so they can be decoded
+type cachedScript struct {
+	Script pipeline.Script `json:"script"`
+	Types  []cachedType    `json:"types"` //type of every option
+}
+
+//Serializable version of pipeline.DataType
+type cachedType struct {
+	Kind          string       `json:"kind"`
+	XmlDefinition string       `json:"xml,omitempty"`
+	Documentation string       `json:"doc,omitempty"`
+	Pattern       string       `json:"pattern,omitempty"`
+	Value         string       `json:"value,omitempty"`
+	Values        []cachedType `json:"values,omitempty"`
+}
+
+func encodeType(t pipeline.DataType) cachedType {
+	switch v := t.(type) {
+	case pipeline.Choice:
+		c := cachedType{Kind: "choice", XmlDefinition: v.XmlDefinition}
+		for _, value := range v.Values {
+			c.Values = append(c.Values, encodeType(value))
+		}
+		return c
+	case pipeline.Value:
+		return cachedType{Kind: "value", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation, Value: v.Value}
+	case pipeline.Pattern:
+		return cachedType{Kind: "pattern", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation, Pattern: v.Pattern}
+	case pipeline.AnyFileURI:
+		return cachedType{Kind: "anyFileURI", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation}
+	case pipeline.AnyDirURI:
+		return cachedType{Kind: "anyDirURI", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation}
+	case pipeline.XsAnyURI:
+		return cachedType{Kind: "anyURI", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation}
+	case pipeline.XsBoolean:
+		return cachedType{Kind: "boolean", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation}
+	case pipeline.XsInteger:
+		return cachedType{Kind: "integer", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation}
+	case pipeline.XsNonNegativeInteger:
+		return cachedType{Kind: "nonNegativeInteger", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation}
+	case pipeline.XsString:
+		return cachedType{Kind: "string", XmlDefinition: v.XmlDefinition, Documentation: v.Documentation}
+	}
+	return cachedType{}
+}
+
+func decodeType(c cachedType) pipeline.DataType {
+	switch c.Kind {
+	case "choice":
+		choice := pipeline.Choice{XmlDefinition: c.XmlDefinition}
+		for _, value := range c.Values {
+			choice.Values = append(choice.Values, decodeType(value))
+		}
+		return choice
+	case "value":
+		return pipeline.Value{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation, Value: c.Value}
+	case "pattern":
+		return pipeline.Pattern{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation, Pattern: c.Pattern}
+	case "anyFileURI":
+		return pipeline.AnyFileURI{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation}
+	case "anyDirURI":
+		return pipeline.AnyDirURI{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation}
+	case "anyURI":
+		return pipeline.XsAnyURI{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation}
+	case "boolean":
+		return pipeline.XsBoolean{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation}
+	case "integer":
+		return pipeline.XsInteger{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation}
+	case "nonNegativeInteger":
+		return pipeline.XsNonNegativeInteger{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation}
+	case "string":
+		return pipeline.XsString{XmlDefinition: c.XmlDefinition, Documentation: c.Documentation}
+	}
+	return nil
+}
+
+//Loads the cache by server url, a missing or broken file means an empty cache
+func loadScriptsCache(path string) map[string]scriptsCacheEntry {
+	cache := make(map[string]scriptsCacheEntry)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		logWarn("Ignoring the scripts cache %v: %v", path, err)
+		return make(map[string]scriptsCacheEntry)
+	}
+	return cache
+}
+
+//Returns the cached scripts of the server if they are fresh and belong to the same
+//framework version
+func cachedScripts(path, url, version string, now time.Time) ([]pipeline.Script, bool) {
+	if path == "" {
+		return nil, false
+	}
+	entry, ok := loadScriptsCache(path)[url]
+	if !ok || entry.Version != version || now.Sub(entry.Updated) > SCRIPTS_CACHE_TTL {
+		return nil, false
+	}
+	return entry.decode()
+}
+
+//Returns the cached scripts of the server whatever their age or version, for
+//the commands that can't contact the webservice
+func storedScripts(path, url string) ([]pipeline.Script, bool) {
+	if path == "" {
+		return nil, false
+	}
+	entry, ok := loadScriptsCache(path)[url]
+	if !ok {
+		return nil, false
+	}
+	return entry.decode()
+}
+
+func (entry scriptsCacheEntry) decode() ([]pipeline.Script, bool) {
+	scripts := make([]pipeline.Script, len(entry.Scripts))
+	for idx, cached := range entry.Scripts {
+		scripts[idx] = cached.Script
+		if len(cached.Types) != len(cached.Script.Options) {
+			return nil, false
+		}
+		for i, t := range cached.Types {
+			scripts[idx].Options[i].Type = decodeType(t)
+		}
+	}
+	return scripts, true
+}
+
+//Stores the scripts of the server in the cache, the entries of other servers are kept
+func storeScripts(path, url, version string, scripts []pipeline.Script, now time.Time) error {
+	if path == "" {
+		return nil
+	}
+	cache := loadScriptsCache(path)
+	entry := scriptsCacheEntry{Version: version, Updated: now}
+	for _, script := range scripts {
+		cached := cachedScript{Script: script}
+		cached.Script.Options = make([]pipeline.Option, len(script.Options))
+		for i, option := range script.Options {
+			cached.Types = append(cached.Types, encodeType(option.Type))
+			option.Type = nil
+			cached.Script.Options[i] = option
+		}
+		entry.Scripts = append(entry.Scripts, cached)
+	}
+	cache[url] = entry
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+//Gets the complete definitions of the scripts using at most SCRIPTS_WORKERS
+//concurrent requests, the order of the list is kept
+func fetchScripts(api PipelineApi, list []pipeline.Script) ([]pipeline.Script, error) {
+	scripts := make([]pipeline.Script, len(list))
+	errs := make([]error, len(list))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < SCRIPTS_WORKERS && i < len(list); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				scripts[idx], errs[idx] = api.Script(list[idx].Id)
+			}
+		}()
+	}
+	for idx := range list {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+	for idx, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Error loading script %v: %v", list[idx].Id, err)
+		}
+	}
+	return scripts, nil
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Points the scripts cache to a temporary file, returns a function that restores it
+func mockScriptsCache(t *testing.T) func() {
+	folder, err := ioutil.TempDir("", "dp2_scripts_cache")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	old := ScriptsCachePath
+	ScriptsCachePath = filepath.Join(folder, SCRIPTS_CACHE_FILE)
+	return func() {
+		ScriptsCachePath = old
+		os.RemoveAll(folder)
+	}
+}
+
+func typedScript() pipeline.Script {
+	script := SCRIPT
+	script.Options = []pipeline.Option{
+		pipeline.Option{Name: "file", Type: pipeline.AnyFileURI{XmlDefinition: "<data/>", Documentation: "A file"}},
+		pipeline.Option{Name: "choice", Type: SCRIPT.Options[1].Type},
+		pipeline.Option{Name: "pattern", Type: pipeline.Pattern{Pattern: "[a-z]+"}},
+		pipeline.Option{Name: "flag", Type: pipeline.XsBoolean{}},
+	}
+	return script
+}
+
+func TestScriptsCacheRoundTrip(t *testing.T) {
+	defer mockScriptsCache(t)()
+	now := time.Now()
+	scripts := []pipeline.Script{typedScript()}
+	if err := storeScripts(ScriptsCachePath, "http://localhost:8181/ws/", "1.0", scripts, now); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cached, ok := cachedScripts(ScriptsCachePath, "http://localhost:8181/ws/", "1.0", now)
+	if !ok || len(cached) != 1 {
+		t.Fatalf("Scripts not cached %v", cached)
+	}
+	for idx, option := range scripts[0].Options {
+		if !reflect.DeepEqual(cached[0].Options[idx].Type, option.Type) {
+			t.Errorf("Type of %v changed %#v != %#v", option.Name, cached[0].Options[idx].Type, option.Type)
+		}
+	}
+	if cached[0].Id != "test" || len(cached[0].Inputs) != 2 {
+		t.Errorf("Script not cached %+v", cached[0])
+	}
+	if scripts[0].Options[0].Type == nil {
+		t.Errorf("Storing the cache modified the scripts")
+	}
+}
+
+func TestScriptsCacheStale(t *testing.T) {
+	defer mockScriptsCache(t)()
+	now := time.Now()
+	url := "http://localhost:8181/ws/"
+	if err := storeScripts(ScriptsCachePath, url, "1.0", []pipeline.Script{SCRIPT}, now); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, ok := cachedScripts(ScriptsCachePath, url, "1.1", now); ok {
+		t.Errorf("Scripts of another version returned")
+	}
+	if _, ok := cachedScripts(ScriptsCachePath, "http://remote/ws/", "1.0", now); ok {
+		t.Errorf("Scripts of another server returned")
+	}
+	if _, ok := cachedScripts(ScriptsCachePath, url, "1.0", now.Add(SCRIPTS_CACHE_TTL+time.Minute)); ok {
+		t.Errorf("Expired scripts returned")
+	}
+	if scripts, ok := storedScripts(ScriptsCachePath, url); !ok || len(scripts) != 1 {
+		t.Errorf("The stored scripts should be returned whatever their age %v", scripts)
+	}
+	if _, ok := cachedScripts("", url, "1.0", now); ok {
+		t.Errorf("An empty path should disable the cache")
+	}
+	ioutil.WriteFile(ScriptsCachePath, []byte("{broken"), 0644)
+	if _, ok := cachedScripts(ScriptsCachePath, url, "1.0", now); ok {
+		t.Errorf("Scripts returned from a broken cache")
+	}
+}
+
+func TestLinkScriptsCached(t *testing.T) {
+	defer mockScriptsCache(t)()
+	pipe := newPipelineTest(false)
+	link := PipelineLink{pipeline: pipe, config: copyConf(), Version: "1.0"}
+	for i := 0; i < 2; i++ {
+		scripts, err := link.Scripts()
+		if err != nil || len(scripts) != 1 {
+			t.Fatalf("Scripts not loaded %v (%v)", scripts, err)
+		}
+	}
+	if pipe.listed != 1 {
+		t.Errorf("The scripts should be fetched once and then cached (%v)", pipe.listed)
+	}
+	link.Version = "2.0"
+	link.Scripts()
+	if pipe.listed != 2 {
+		t.Errorf("A new framework version should refresh the cache (%v)", pipe.listed)
+	}
+	link.RefreshScripts()
+	if pipe.listed != 3 {
+		t.Errorf("The refresh didn't fetch the scripts (%v)", pipe.listed)
+	}
+}
+
+func TestFetchScriptsError(t *testing.T) {
+	list := []pipeline.Script{pipeline.Script{Id: "a"}, pipeline.Script{Id: "b"}}
+	scripts, err := fetchScripts(newPipelineTest(false), list)
+	if err != nil || len(scripts) != 2 {
+		t.Errorf("Scripts not fetched %v (%v)", scripts, err)
+	}
+	if _, err := fetchScripts(newPipelineTest(true), list); err == nil {
+		t.Errorf("Expected error not thrown")
+	}
+}
+
+func TestNonScriptCommandsSkipScripts(t *testing.T) {
+	pipe := newPipelineTest(false)
+	link := &PipelineLink{pipeline: pipe, config: copyConf()}
+	cli, err := NewCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	overrideOutput(cli)
+	cli.AddCommand("noop", "does nothing", func(string, ...string) error { return nil })
+	if err := cli.Run([]string{"noop"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if pipe.listed != 0 {
+		t.Errorf("Scripts loaded for a general command")
+	}
+	if err := cli.Run([]string{"help"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if pipe.listed != 1 {
+		t.Errorf("Scripts not loaded for the help")
+	}
+}
+
+//Pipeline whose scripts can't be listed
+type brokenScripts struct {
+	*PipelineTest
+}
+
+func (brokenScripts) Scripts() (pipeline.Scripts, error) {
+	return pipeline.Scripts{}, errors.New("Server error:  from /scripts")
+}
+
+func TestScriptsErrorReturned(t *testing.T) {
+	defer mockScriptsCache(t)()
+	link := &PipelineLink{pipeline: brokenScripts{newPipelineTest(false)}, config: copyConf()}
+	cli, err := NewCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	w := overrideOutput(cli)
+	err = cli.Run([]string{"help"})
+	if err == nil || !strings.Contains(ErrorMessage(err, false), "Error loading scripts") || ExitCode(err) != 1 {
+		t.Errorf("Wrong error %v", err)
+	}
+	if w.Len() != 0 {
+		t.Errorf("The error was printed %q", w.String())
+	}
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+const (
+	ScriptListTemplate = `{{range .}}{{.Id}}	{{.Version}}	{{.Description}}
+{{end}}`
+
+	ScriptDescribeTemplate = `{{.Id}}{{if .Version}} (v{{.Version}}){{end}}
+{{.Description}}
+{{if .Homepage}}{{.Homepage}}
+{{end}}
+Inputs:
+{{range .Inputs}}  {{.Name}}{{if .Required}} (required){{end}}{{if .Sequence}} (sequence){{end}}{{range .MediaTypes}} {{.}}{{end}}
+      {{.Description}}
+{{end}}
+Options:
+{{range .Options}}  {{.Name}}{{if .Required}} (required){{end}}{{if .Sequence}} (sequence){{end}} {{.Type}}{{if not .Required}} default: "{{.Default}}"{{end}}
+      {{.Description}}
+{{end}}`
+)
+
+//Adds the scripts command, it lists the scripts and manages their cache
+func AddScriptsCommand(cli *Cli, link *PipelineLink) {
+	filter := ""
+	cmd := cli.AddCommand("scripts", "Lists the scripts available in the webservice", func(name string, args ...string) error {
+		if len(args) == 1 && args[0] == "refresh" {
+			scripts, err := link.RefreshScripts()
+			if err != nil {
+				return err
+			}
+			cli.Printf("%v scripts loaded from %v\n", len(scripts), link.config.Url())
+			return nil
+		} else if len(args) > 0 {
+			return fmt.Errorf("scripts: wrong arguments %v, expected nothing or refresh", args)
+		}
+		scripts, err := link.Scripts()
+		if err != nil {
+			return err
+		}
+		summaries, err := listScripts(scripts, filter)
+		if err != nil {
+			return err
+		}
+		return commandBuilder{template: ScriptListTemplate}.writeOutput(summaries, cli)
+	})
+	cmd.SetArity(-1, "[refresh]")
+	cmd.AddOption("filter", "", "Only list the scripts whose id or description match the regular expression", "", italic("REGEX"), func(name, value string) error {
+		filter = value
+		return nil
+	})
+}
+
+//Adds the describe command, it prints the inputs and options of a script
+func AddDescribeCommand(cli *Cli, link *PipelineLink) {
+	cmd := cli.AddCommand("describe", "Describes the inputs and options of a script", func(name string, args ...string) error {
+		scripts, err := link.Scripts()
+		if err != nil {
+			return err
+		}
+		for _, script := range scripts {
+			if script.Id == args[0] {
+				return commandBuilder{template: ScriptDescribeTemplate}.writeOutput(newScriptDocument(script), cli)
+			}
+		}
+		return newError(NotFoundError, nil, "Script %v not found", args[0])
+	})
+	cmd.SetArity(1, "SCRIPT")
+}
+
+//Returns the summaries of the scripts that match the filter, sorted as the server lists them
+func listScripts(scripts []pipeline.Script, filter string) ([]scriptSummaryDocument, error) {
+	var re *regexp.Regexp
+	if filter != "" {
+		var err error
+		if re, err = regexp.Compile(filter); err != nil {
+			return nil, fmt.Errorf("%v is not a valid regular expression: %v", filter, err)
+		}
+	}
+	summaries := []scriptSummaryDocument{}
+	for _, script := range scripts {
+		summary := scriptSummaryDocument{
+			Id:          script.Id,
+			Version:     script.Version,
+			Description: firstLine(script.Description),
+		}
+		if re != nil && !re.MatchString(summary.Id) && !re.MatchString(script.Description) {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+func firstLine(s string) string {
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(s), "\n", 2)[0])
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+func makeScriptsCli(t *testing.T) (*Cli, *PipelineTest) {
+	config := copyConf()
+	config[STARTING] = false
+	pipe := newPipelineTest(false)
+	link := &PipelineLink{pipeline: pipe, config: config}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	pipe.withScripts = true
+	AddScriptsCommand(cli, link)
+	AddDescribeCommand(cli, link)
+	return cli, pipe
+}
+
+func TestListScripts(t *testing.T) {
+	scripts := []pipeline.Script{
+		pipeline.Script{Id: "dtbook-to-epub3", Version: "1.0", Description: "Converts DTBook\n\nLonger text"},
+		pipeline.Script{Id: "daisy3-to-epub3", Version: "1.1", Description: "Converts a DAISY 3"},
+	}
+	summaries, err := listScripts(scripts, "")
+	if err != nil || len(summaries) != 2 {
+		t.Fatalf("Wrong summaries %v (%v)", summaries, err)
+	}
+	if summaries[0].Description != "Converts DTBook" {
+		t.Errorf("Only the first line should be used %q", summaries[0].Description)
+	}
+	if summaries, _ := listScripts(scripts, "^dtbook"); len(summaries) != 1 || summaries[0].Id != "dtbook-to-epub3" {
+		t.Errorf("Filter by id not applied %v", summaries)
+	}
+	if summaries, _ := listScripts(scripts, "DAISY"); len(summaries) != 1 || summaries[0].Id != "daisy3-to-epub3" {
+		t.Errorf("Filter by description not applied %v", summaries)
+	}
+	if _, err := listScripts(scripts, "(broken"); err == nil {
+		t.Errorf("Expected error not thrown for a wrong regular expression")
+	}
+}
+
+func TestScriptsCommand(t *testing.T) {
+	cli, _ := makeScriptsCli(t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"scripts"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(w.String(), "test") || !strings.Contains(w.String(), "Mocked script") {
+		t.Errorf("Wrong output %q", w.String())
+	}
+	cli, _ = makeScriptsCli(t)
+	w = overrideOutput(cli)
+	if err := cli.Run([]string{"--format", "json", "scripts", "--filter", "nothing-matches"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.TrimSpace(w.String()) != "[]" {
+		t.Errorf("Wrong output %q", w.String())
+	}
+}
+
+func TestDescribeCommand(t *testing.T) {
+	cli, _ := makeScriptsCli(t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"--format", "json", "describe", "test"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	doc := scriptDocument{}
+	if err := json.Unmarshal(w.Bytes(), &doc); err != nil {
+		t.Fatalf("Unexpected error %v\n%v", err, w.String())
+	}
+	if doc.Id != "test" || len(doc.Inputs) != 2 || len(doc.Options) != 2 {
+		t.Fatalf("Wrong document %+v", doc)
+	}
+	if doc.Inputs[1].Name != "source" || !doc.Inputs[1].Sequence || doc.Inputs[1].MediaTypes[0] != "application/x-dtbook+xml" {
+		t.Errorf("Wrong input %+v", doc.Inputs[1])
+	}
+	choice := doc.Options[1].Type
+	if choice.Kind != "choice" || len(choice.Choices) != 2 || choice.Choices[1].Value != "bar" {
+		t.Errorf("Wrong option type %+v", choice)
+	}
+	if !doc.Options[0].Required {
+		t.Errorf("Option should be required %+v", doc.Options[0])
+	}
+
+	cli, _ = makeScriptsCli(t)
+	w = overrideOutput(cli)
+	if err := cli.Run([]string{"describe", "test"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, exp := range []string{"Inputs:", "source (sequence) application/x-dtbook+xml", `another-opt ("foo"|"bar")`, "test-opt (required)"} {
+		if !strings.Contains(w.String(), exp) {
+			t.Errorf("%q not found in the output\n%v", exp, w.String())
+		}
+	}
+
+	cli, _ = makeScriptsCli(t)
+	if err := cli.Run([]string{"describe", "unknown"}); err == nil {
+		t.Errorf("Expected error not thrown for an unknown script")
+	}
+}
+
+func TestTypeDocumentString(t *testing.T) {
+	tests := map[string]pipeline.DataType{
+		"boolean":    pipeline.XsBoolean{},
+		"/[a-z]+/":   pipeline.Pattern{Pattern: "[a-z]+"},
+		"anyFileURI": pipeline.AnyFileURI{},
+		"string":     nil,
+	}
+	for exp, dataType := range tests {
+		if res := newTypeDocument(dataType).String(); res != exp {
+			t.Errorf("%v != %v", res, exp)
+		}
+	}
+}
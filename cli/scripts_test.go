@@ -1,7 +1,10 @@
 package cli
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/bertfrees/go-subcommand"
 	//"github.com/bertfrees/go-subcommand"
@@ -9,6 +12,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -93,7 +97,7 @@ func TestScriptPriority(t *testing.T) {
 		t.Error("Unexpected error")
 	}
 	//parser.Parse([]string{"test","--source","value"})
-	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", os.TempDir(), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "low"})
+	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", testDataZip(t, "tmp/file", "tmp/file2"), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "low"})
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
@@ -118,7 +122,7 @@ func TestScriptNiceName(t *testing.T) {
 		t.Error("Unexpected error")
 	}
 	//parser.Parse([]string{"test","--source","value"})
-	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", os.TempDir(), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--nicename", "my_job"})
+	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", testDataZip(t, "tmp/file", "tmp/file2"), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--nicename", "my_job"})
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
@@ -142,7 +146,7 @@ func TestScriptPriorityMedium(t *testing.T) {
 		t.Error("Unexpected error")
 	}
 	////medium
-	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", os.TempDir(), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "medium"})
+	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", testDataZip(t, "tmp/file", "tmp/file2"), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "medium"})
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
@@ -163,7 +167,7 @@ func TestScriptPriorityHigh(t *testing.T) {
 		t.Error("Unexpected error")
 	}
 	////medium
-	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", os.TempDir(), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "high"})
+	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", testDataZip(t, "tmp/file", "tmp/file2"), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "high"})
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
@@ -183,7 +187,7 @@ func TestScriptPriorityWrongValue(t *testing.T) {
 	if err != nil {
 		t.Error("Unexpected error")
 	}
-	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", os.TempDir(), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "not_so_low"})
+	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", testDataZip(t, "tmp/file", "tmp/file2"), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar", "--priority", "not_so_low"})
 	if err == nil {
 		t.Errorf("Wrong priority value didn't error")
 	}
@@ -205,7 +209,7 @@ func TestScriptToCommand(t *testing.T) {
 		t.Error("Unexpected error")
 	}
 	//parser.Parse([]string{"test","--source","value"})
-	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", os.TempDir(), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar"})
+	err = cli.Run([]string{"test", "-o", os.TempDir(), "-d", testDataZip(t, "tmp/file", "tmp/file2"), "--source", "./tmp/file", "--single", "./tmp/file2", "--test-opt", "./myfile.xml", "--another-opt", "bar"})
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
@@ -435,3 +439,83 @@ func TestGetFlagCommonExists(t *testing.T) {
 	}
 
 }
+
+func TestFollowMessages(t *testing.T) {
+	messages := make(chan Message, 3)
+	messages <- Message{Message: "hello", Level: "INFO", Progress: 0.5}
+	messages <- Message{Status: "SUCCESS"}
+	close(messages)
+	var buf bytes.Buffer
+	status, err := followMessages(&buf, messages, true, make(chan os.Signal))
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if status != "SUCCESS" {
+		t.Errorf("Wrong status %v", status)
+	}
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("Message not printed %q", buf.String())
+	}
+}
+
+func TestFollowMessagesInterrupted(t *testing.T) {
+	interrupt := make(chan os.Signal, 1)
+	interrupt <- os.Interrupt
+	_, err := followMessages(ioutil.Discard, make(chan Message), true, interrupt)
+	if err != errInterrupted {
+		t.Errorf("Expected interruption got %v", err)
+	}
+}
+
+func TestInterruptedJob(t *testing.T) {
+	oldAsk, oldPath := askYesNo, LastIdPath
+	defer func() { askYesNo, LastIdPath = oldAsk, oldPath }()
+	LastIdPath = os.TempDir() + string(os.PathSeparator) + "dp2_interrupted_id"
+	defer os.Remove(LastIdPath)
+	//leave it running
+	askYesNo = func(io.Writer, string) bool { return true }
+	pipe := newPipelineTest(false)
+	link := PipelineLink{pipeline: pipe}
+	if err := interruptedJob(ioutil.Discard, link, "id"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if pipe.deleted {
+		t.Errorf("The job shouldn't be deleted")
+	}
+	if id, _ := getLastId(); id != "id" {
+		t.Errorf("The job id wasn't stored %v", id)
+	}
+	//delete it
+	askYesNo = func(io.Writer, string) bool { return false }
+	if err := interruptedJob(ioutil.Discard, link, "id"); err != errInterrupted {
+		t.Errorf("Expected interruption got %v", err)
+	}
+	if !pipe.deleted {
+		t.Errorf("The job should be deleted")
+	}
+}
+
+//The warnings don't go to the output, which may be a json or yaml document
+func TestScriptBackgroundOutputWarning(t *testing.T) {
+	folder := testTree(t, "book.xml", "a.xml")
+	defer os.RemoveAll(folder)
+	link := &PipelineLink{FsAllow: true, pipeline: newPipelineTest(false)}
+	cli, err := makeCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	w := overrideOutput(cli)
+	if _, err := scriptToCommand(SCRIPT, cli, link); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	logged, restore := captureLog(LogWarn, false)
+	defer restore()
+	err = cli.Run([]string{"test", "-b", "-o", folder, "--source", filepath.Join(folder, "a.xml"),
+		"--single", filepath.Join(folder, "book.xml"), "--test-opt", "x", "--another-opt", "foo"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(logged.String(), "--output option ignored") || strings.Contains(w.String(), "--output") {
+		t.Errorf("Wrong warning %q %q", logged.String(), w.String())
+	}
+}
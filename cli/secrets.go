@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertfrees/go-subcommand"
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Random bytes of the generated secrets, 256 bits
+const SECRET_BYTES = 32
+
+const TmplClientSecret = `
+Client id:      {{.Id}}
+Role:           {{.Role}}
+Contact:        {{.Contact}}
+Secret:         {{if .Generated}}{{.Generated}}{{else if .StoredIn}}stored in {{.StoredIn}}{{else}}****{{end}}
+Priority:       {{.Priority}}
+{{if .Generated}}
+The secret won't be shown again, keep it in a safe place.
+{{end}}
+`
+
+//Source of randomness of the secrets
+var secretRand = rand.Reader
+
+//Where the generated secret goes besides the webservice, if nothing is
+//given it's printed
+type secretOptions struct {
+	generate bool
+	file     string //written only readable by the current user
+	profile  string //stored as the credentials of the webservice of the profile
+}
+
+//Client along with its new secret, which is only shown if it wasn't stored
+type clientSecret struct {
+	pipeline.Client
+	Generated string //the secret to show
+	StoredIn  string //where the secret was stored
+}
+
+//Returns a new random secret, url safe so it can be used in the
+//configuration files and the command line
+func generateSecret() (string, error) {
+	data := make([]byte, SECRET_BYTES)
+	if _, err := secretRand.Read(data); err != nil {
+		return "", fmt.Errorf("Could not generate the secret: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+//Adds the options to store the generated secret
+func addSecretOptions(cmd *subcommand.Command, opts *secretOptions) {
+	cmd.AddOption("secret-file", "", "Write the generated secret to FILE, only readable by the current user, instead of printing it", "", "FILE", func(name, value string) error {
+		opts.file = value
+		return nil
+	})
+	cmd.AddOption("save-profile", "", "Store the client id and the generated secret as the credentials for the webservice of the configuration profile NAME, as dp2 login does", "", "NAME", func(name, value string) error {
+		opts.profile = value
+		return nil
+	})
+}
+
+//Checks the options before the secret is sent to the webservice
+func (opts secretOptions) check(conf Config) error {
+	if opts.profile != "" {
+		_, err := profileUrl(conf, opts.profile)
+		return err
+	}
+	return nil
+}
+
+//Stores the secret where the options say once the webservice uses it. If it
+//can't be stored it's shown instead so it's not lost
+func (opts secretOptions) store(conf Config, id, secret string) clientSecret {
+	result := clientSecret{}
+	where := []string{}
+	err := func() error {
+		if opts.file != "" {
+			if err := writeSecretFile(opts.file, secret); err != nil {
+				return err
+			}
+			where = append(where, opts.file)
+		}
+		if opts.profile != "" {
+			url, err := profileUrl(conf, opts.profile)
+			if err != nil {
+				return err
+			}
+			backend, err := login(url, id, secret, true)
+			if err != nil {
+				return err
+			}
+			if backend == BACKEND_KEYRING {
+				where = append(where, "the system keyring for "+url)
+			} else {
+				where = append(where, CredentialsPath+" for "+url)
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		logWarn("The secret of the client %v is in use but it couldn't be stored, it's shown instead: %v", id, err)
+	}
+	if err != nil || len(where) == 0 {
+		result.Generated = secret
+	}
+	result.StoredIn = strings.Join(where, " and ")
+	return result
+}
+
+//Writes the secret in a file only the current user can read
+func writeSecretFile(path string, secret string) error {
+	if err := mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return fmt.Errorf("Could not write the secret to %v: %v", path, err)
+	}
+	//WriteFile keeps the permissions of existing files
+	return os.Chmod(path, 0600)
+}
+
+//Returns the webservice url of the configuration profile
+func profileUrl(conf Config, name string) (string, error) {
+	profile, ok := conf.Profiles()[name]
+	if !ok {
+		return "", newError(NotFoundError, nil, "Profile %v not found in the configuration", name)
+	}
+	cp := conf.copy()
+	for key, value := range profile {
+		cp[key] = value
+	}
+	return cp.Url(), nil
+}
+
+//Adds the rotate-secret command, it replaces the secret of a client with a
+//generated one
+func (c *Cli) AddRotateSecretCommand(link PipelineLink) {
+	opts := &secretOptions{}
+	fn := func(args ...string) (interface{}, error) {
+		return rotateSecret(link, args[0], *opts)
+	}
+	cmd := newCommandBuilder("rotate-secret", "Replaces the secret of a client with a randomly generated one").
+		withCall(fn).withTemplate(TmplClientSecret).buildAdmin(c)
+	cmd.SetArity(1, "CLIENT_ID")
+	addSecretOptions(cmd, opts)
+}
+
+func rotateSecret(link PipelineLink, id string, opts secretOptions) (interface{}, error) {
+	client, err := link.Client(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.check(link.config); err != nil {
+		return nil, err
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+	client.Id = id
+	client.Secret = secret
+	modified, err := link.ModifyClient(client, id)
+	if err != nil {
+		return nil, err
+	}
+	result := opts.store(link.config, id, secret)
+	result.Client = modified
+	return result, nil
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+//Cli with the secret commands and a profile pointing to a remote webservice
+func makeSecretsCli(store clientStore, t *testing.T) *Cli {
+	conf := Config{HOST: "http://localhost", PORT: 8181, PATH: "ws", PROFILES: map[interface{}]interface{}{
+		"remote": map[interface{}]interface{}{"host": "https://remote.org", "port": 443},
+	}}
+	link := PipelineLink{pipeline: store, config: conf}
+	cli, err := NewCli("test", &link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cli.AddNewClientCommand(link)
+	cli.AddRotateSecretCommand(link)
+	return cli
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := generateSecret()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	second, _ := generateSecret()
+	if len(first) != 43 || first == second || strings.ContainsAny(first, "+/=") {
+		t.Errorf("Wrong secrets %q %q", first, second)
+	}
+	back := secretRand
+	defer func() {
+		secretRand = back
+	}()
+	secretRand = failingReader{}
+	if _, err := generateSecret(); err == nil {
+		t.Errorf("Secret generated without randomness")
+	}
+}
+
+func TestCreateGenerateSecret(t *testing.T) {
+	store := newClientStore()
+	cli := makeSecretsCli(store, t)
+	w := overrideOutput(cli)
+	if err := cli.Run(strings.Split("create -i school -r CLIENTAPP --generate-secret", " ")); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	secret := store.clients["school"].Secret
+	if secret == "" {
+		t.Fatalf("No secret sent")
+	}
+	text := w.String()
+	out := checkMapLikeOutput(w)
+	if out["Secret"] != secret || !strings.Contains(text, "won't be shown again") {
+		t.Errorf("The secret isn't shown %v", out)
+	}
+}
+
+func TestCreateSecretErrors(t *testing.T) {
+	for _, args := range []string{
+		"create -i school -r CLIENTAPP",
+		"create -i school -r CLIENTAPP -s secret --generate-secret",
+		"create -i school -r CLIENTAPP -s secret --secret-file secret.txt",
+	} {
+		store := newClientStore()
+		cli := makeSecretsCli(store, t)
+		overrideOutput(cli)
+		if err := cli.Run(strings.Split(args, " ")); ExitCode(err) != 2 {
+			t.Errorf("%v: wrong error %v", args, err)
+		}
+		if len(*store.calls) != 0 {
+			t.Errorf("%v: client created", args)
+		}
+	}
+}
+
+func TestRotateSecretFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "library.secret")
+	ioutil.WriteFile(path, []byte("old"), 0644)
+	store := newClientStore(testClients()...)
+	cli := makeSecretsCli(store, t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"rotate-secret", "--secret-file", path, "library"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	library := store.clients["library"]
+	if library.Secret == "libsecret" || library.Role != "CLIENTAPP" || library.Contact != "old@library.org" || library.Priority != "low" {
+		t.Errorf("Wrong client %+v", library)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.TrimSpace(string(data)) != library.Secret {
+		t.Errorf("Wrong secret file %q", data)
+	}
+	if info, _ := os.Stat(path); runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Wrong permissions %v", info.Mode())
+	}
+	if strings.Contains(w.String(), library.Secret) || !strings.Contains(w.String(), "stored in "+path) {
+		t.Errorf("Wrong output %q", w.String())
+	}
+}
+
+func TestRotateSecretProfile(t *testing.T) {
+	defer mockCredentials(t, nil)()
+	store := newClientStore(testClients()...)
+	cli := makeSecretsCli(store, t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"--format", "json", "rotate-secret", "--save-profile", "remote", "library"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	creds, err := loadCredentials(CredentialsPath)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	stored := creds["https://remote.org:443/ws/"]
+	if stored.ClientKey != "library" || stored.ClientSecret != store.clients["library"].Secret {
+		t.Errorf("Wrong credentials %+v", creds)
+	}
+	doc := clientSecretDocument{}
+	if err := json.Unmarshal(w.Bytes(), &doc); err != nil {
+		t.Fatalf("Not json %v %q", err, w.String())
+	}
+	if doc.Id != "library" || doc.Secret != "" || !strings.Contains(doc.StoredIn, CredentialsPath) {
+		t.Errorf("Wrong document %+v", doc)
+	}
+	if err := cli.Run([]string{"rotate-secret", "--save-profile", "missing", "library"}); ExitCode(err) != 5 {
+		t.Errorf("Wrong error %v", err)
+	}
+	if err := cli.Run([]string{"rotate-secret", "nobody"}); err == nil {
+		t.Errorf("Rotated the secret of a missing client")
+	}
+}
+
+func TestRotateSecretStoreError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	//the secret file can't be written under a file
+	blocker := filepath.Join(dir, "blocker")
+	ioutil.WriteFile(blocker, []byte("file"), 0644)
+	store := newClientStore(testClients()...)
+	cli := makeSecretsCli(store, t)
+	w := overrideOutput(cli)
+	log, restore := captureLog(LogWarn, false)
+	defer restore()
+	if err := cli.Run([]string{"rotate-secret", "--secret-file", filepath.Join(blocker, "library.secret"), "library"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	secret := store.clients["library"].Secret
+	if secret == "libsecret" {
+		t.Fatalf("The secret wasn't rotated")
+	}
+	if !strings.Contains(w.String(), secret) || !strings.Contains(log.String(), "couldn't be stored") {
+		t.Errorf("The secret in use isn't shown %q %q", w.String(), log.String())
+	}
+	//the profile is checked before changing anything
+	*store.calls = nil
+	if err := cli.Run([]string{"rotate-secret", "--save-profile", "missing", "library"}); ExitCode(err) != 5 || len(*store.calls) != 0 {
+		t.Errorf("Wrong error %v %v", err, *store.calls)
+	}
+}
@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SERVER_PID_FILE = "server.pid"
+	SERVER_LOG_FILE = "server.log"
+
+	ServerStatusTemplate = `{{if .Running}}The webservice is running at {{.Url}}
+{{if .Pid}}PID:            {{.Pid}}
+Uptime:         {{.Uptime}}
+{{end}}Version:        {{.Version}}
+Local mode:     {{.FsAllow}}
+Authentication: {{.Authentication}}
+{{else}}The webservice is not running at {{.Url}}
+{{end}}{{if .Log}}Log:            {{.Log}}
+{{end}}`
+)
+
+//Files of the webservice launched by dp2, next to the lastid file
+var (
+	ServerPidPath = filepath.Join(filepath.Dir(LastIdPath), SERVER_PID_FILE)
+	ServerLogPath = filepath.Join(filepath.Dir(LastIdPath), SERVER_LOG_FILE)
+)
+
+//Time given to the webservice to stop before it's killed, and how often it's checked
+var (
+	serverStopTimeout = 30 * time.Second
+	serverPollWait    = 500 * time.Millisecond
+)
+
+var errServerNotRunning = newError(ConnectionError, nil, "The webservice is not running")
+
+//Actions of the server command
+var serverActions = []string{"start", "stop", "restart", "status"}
+
+//Status of the local webservice
+type serverStatus struct {
+	Url            string `json:"url" yaml:"url"`
+	Running        bool   `json:"running" yaml:"running"`
+	Pid            int    `json:"pid,omitempty" yaml:"pid,omitempty"`       //only known if dp2 launched it
+	Uptime         string `json:"uptime,omitempty" yaml:"uptime,omitempty"` //since it was launched
+	Version        string `json:"version,omitempty" yaml:"version,omitempty"`
+	FsAllow        bool   `json:"fs_allow" yaml:"fs_allow"` //local mode, it reads the inputs from disk
+	Authentication bool   `json:"authentication" yaml:"authentication"`
+	Log            string `json:"log,omitempty" yaml:"log,omitempty"` //output of the webservice
+}
+
+//Adds the server command, it manages the webservice of this computer
+func AddServerCommand(cli *Cli, link *PipelineLink) {
+	cmd := cli.AddCommand("server", "Starts, stops, restarts or shows the status of the local webservice", func(name string, args ...string) error {
+		return serverCommand(cli, link, args[0])
+	})
+	cmd.SetArity(1, "(start|stop|restart|status)")
+	//it must not start the webservice on its own
+	cli.setOffline(cmd.Name)
+}
+
+//Dispatches the server actions
+func serverCommand(cli *Cli, link *PipelineLink, action string) error {
+	transport := link.connect()
+	switch action {
+	case "start":
+		return startServer(link, cli.Output)
+	case "stop":
+		return stopServer(link, transport, cli.Output)
+	case "restart":
+		if err := stopServer(link, transport, cli.Output); err != nil && err != errServerNotRunning {
+			return err
+		}
+		return startServer(link, cli.Output)
+	case "status":
+		status := getServerStatus(link, time.Now())
+		if err := (commandBuilder{template: ServerStatusTemplate}).writeOutput(status, cli); err != nil {
+			return err
+		}
+		if !status.Running {
+			return errServerNotRunning
+		}
+		return nil
+	}
+	return newError(UsageError, nil, "server: wrong action %v, expected start, stop, restart or status", action)
+}
+
+//Launches the webservice and waits until it's up
+func startServer(link *PipelineLink, w io.Writer) error {
+	if alive, err := link.pipeline.Alive(); err == nil {
+		fmt.Fprintf(w, "The webservice %v is already running at %v\n", alive.Version, link.config.Url())
+		return nil
+	}
+	if execLine, _ := link.config[EXECLINE].(string); execLine == "" {
+		return newError(GenericError, nil, "%v is not set in the configuration, I don't know how to start the webservice", EXECLINE)
+	}
+	alive, err := link.launch(w)
+	if err != nil {
+		return launchError(err)
+	}
+	link.setAlive(alive)
+	if pid, _, err := readPid(ServerPidPath); err == nil {
+		fmt.Fprintf(w, "Webservice %v started at %v (PID %v)\n", alive.Version, link.config.Url(), pid)
+	} else {
+		fmt.Fprintf(w, "Webservice %v started at %v\n", alive.Version, link.config.Url())
+	}
+	return nil
+}
+
+//Halts the webservice, if it doesn't stop the process launched by dp2 is
+//terminated and finally killed
+func stopServer(link *PipelineLink, transport *retryTransport, w io.Writer) error {
+	pid, _, err := readPid(ServerPidPath)
+	if err != nil || !processRunning(pid) {
+		pid = 0
+	}
+	alive, err := link.pipeline.Alive()
+	if err != nil && pid == 0 {
+		removePid(ServerPidPath)
+		return errServerNotRunning
+	}
+	if err == nil {
+		link.setAlive(alive)
+		if err := haltServer(link, transport); err != nil {
+			logWarn("Could not halt the webservice: %v", err)
+		} else {
+			fmt.Fprintln(w, "Halting the webservice...")
+			if waitServerStopped(link.pipeline, pid, serverStopTimeout) {
+				removePid(ServerPidPath)
+				fmt.Fprintln(w, "The webservice has been stopped")
+				return nil
+			}
+		}
+		if pid == 0 {
+			return newError(GenericError, nil, "The webservice couldn't be halted and it wasn't started by dp2, stop it by other means")
+		}
+	}
+	fmt.Fprintf(w, "Stopping the webservice process %v...\n", pid)
+	if err := terminateProcess(pid); err != nil {
+		logWarn("Could not terminate the process %v: %v", pid, err)
+	}
+	if !waitServerStopped(link.pipeline, pid, serverStopTimeout) {
+		logWarn("The process %v didn't stop after %v, killing it", pid, serverStopTimeout)
+		if err := killProcess(pid); err != nil {
+			return fmt.Errorf("Could not kill the webservice process %v: %v", pid, err)
+		}
+	}
+	removePid(ServerPidPath)
+	fmt.Fprintln(w, "The webservice has been stopped")
+	return nil
+}
+
+//Sends the halt request with the key the webservice left in the temp dir
+func haltServer(link *PipelineLink, transport *retryTransport) error {
+	if err := link.authenticate(transport); err != nil {
+		return err
+	}
+	key, err := loadKey()
+	if err != nil {
+		return err
+	}
+	return link.Halt(key)
+}
+
+//Waits until the webservice doesn't answer and its process, if known, is gone
+func waitServerStopped(pinger Pinger, pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := pinger.Alive()
+		if err != nil && (pid == 0 || !processRunning(pid)) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(serverPollWait)
+	}
+}
+
+//Returns the status of the webservice, the pid and uptime are only known if dp2 launched it
+func getServerStatus(link *PipelineLink, now time.Time) serverStatus {
+	status := serverStatus{Url: link.config.Url()}
+	if _, err := os.Stat(ServerLogPath); err == nil {
+		status.Log = ServerLogPath
+	}
+	alive, err := link.pipeline.Alive()
+	if err != nil {
+		return status
+	}
+	status.Running = true
+	status.Version = alive.Version
+	status.FsAllow = alive.FsAllow
+	status.Authentication = alive.Authentication
+	if pid, started, err := readPid(ServerPidPath); err == nil && processRunning(pid) {
+		status.Pid = pid
+		status.Uptime = now.Sub(started).Round(time.Second).String()
+	}
+	return status
+}
+
+//Stores the pid of the launched webservice, the modification time of the
+//file is the launch time
+func storePid(path string, pid int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644)
+}
+
+//Returns the stored pid and when it was stored
+func readPid(path string) (pid int, stored time.Time, err error) {
+	if path == "" {
+		return 0, stored, os.ErrNotExist
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if pid, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil || pid <= 0 {
+		return 0, stored, fmt.Errorf("Wrong pid file %v", path)
+	}
+	return pid, info.ModTime(), nil
+}
+
+func removePid(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logWarn("Error removing the pid file: %v", err)
+	}
+}
+
+//Whether the process exists, in windows finding it is enough
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+//Asks the process to finish, windows doesn't support signals so it's killed
+func terminateProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return process.Kill()
+	}
+	return process.Signal(syscall.SIGTERM)
+}
+
+func killProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Pipeline that stops answering once it's halted
+type haltingPipeline struct {
+	*PipelineTest
+}
+
+func (p haltingPipeline) Halt(key string) error {
+	p.fail = true
+	return p.PipelineTest.Halt(key)
+}
+
+//Uses a temporary directory for the server files
+func mockServerFiles(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "cli_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPid, oldLog, oldWait := ServerPidPath, ServerLogPath, serverPollWait
+	ServerPidPath = filepath.Join(dir, SERVER_PID_FILE)
+	ServerLogPath = filepath.Join(dir, SERVER_LOG_FILE)
+	serverPollWait = 10 * time.Millisecond
+	return func() {
+		ServerPidPath, ServerLogPath, serverPollWait = oldPid, oldLog, oldWait
+		os.RemoveAll(dir)
+	}
+}
+
+func makeServerCli(pipe PipelineApi, t *testing.T) *Cli {
+	link := &PipelineLink{pipeline: pipe, config: Config{HOST: "http://localhost", PORT: 8181, PATH: "ws"}}
+	cli, err := NewCli("test", link)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	AddServerCommand(cli, link)
+	return cli
+}
+
+func TestPidFile(t *testing.T) {
+	defer mockServerFiles(t)()
+	if err := storePid(ServerPidPath, 1234); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	pid, stored, err := readPid(ServerPidPath)
+	if err != nil || pid != 1234 || time.Since(stored) > time.Minute {
+		t.Errorf("Wrong pid %v %v %v", pid, stored, err)
+	}
+	ioutil.WriteFile(ServerPidPath, []byte("java"), 0644)
+	if _, _, err := readPid(ServerPidPath); err == nil {
+		t.Errorf("Broken pid file accepted")
+	}
+	removePid(ServerPidPath)
+	if _, _, err := readPid(ServerPidPath); !os.IsNotExist(err) {
+		t.Errorf("Pid file not removed %v", err)
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	defer mockServerFiles(t)()
+	storePid(ServerPidPath, os.Getpid())
+	pipe := newPipelineTest(false)
+	pipe.authentication = true
+	cli := makeServerCli(pipe, t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"server", "status"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	status := checkMapLikeOutput(w)
+	if status["PID"] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Pid not shown %v", status)
+	}
+	exp := map[string]string{"Version": "version-test", "Local mode": "true", "Authentication": "true"}
+	for key, value := range exp {
+		if status[key] != value {
+			t.Errorf("%v: %q != %q", key, status[key], value)
+		}
+	}
+	if _, err := time.ParseDuration(status["Uptime"]); err != nil {
+		t.Errorf("Wrong uptime %v", status)
+	}
+}
+
+func TestServerStatusNotRunning(t *testing.T) {
+	defer mockServerFiles(t)()
+	cli := makeServerCli(newPipelineTest(true), t)
+	w := overrideOutput(cli)
+	err := cli.Run([]string{"server", "status"})
+	if ExitCode(err) != 3 {
+		t.Errorf("Wrong error %v", err)
+	}
+	if !strings.Contains(w.String(), "The webservice is not running at http://localhost:8181/ws/") {
+		t.Errorf("Wrong output %q", w.String())
+	}
+}
+
+func TestServerStopHalts(t *testing.T) {
+	defer mockServerFiles(t)()
+	backup := keyFile
+	defer func() {
+		keyFile = backup
+	}()
+	keyFile = "fakeServerKey"
+	file, err := createKeyFile(keyFile, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	pipe := newPipelineTest(false)
+	cli := makeServerCli(haltingPipeline{pipe}, t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"server", "stop"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if pipe.call != HALT_CALL {
+		t.Errorf("Halt wasn't called")
+	}
+	if !strings.Contains(w.String(), "The webservice has been stopped") {
+		t.Errorf("Wrong output %q", w.String())
+	}
+	err = cli.Run([]string{"server", "stop"})
+	if err != errServerNotRunning {
+		t.Errorf("Stopped twice %v", err)
+	}
+}
+
+func TestServerStopSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sleep command")
+	}
+	defer mockServerFiles(t)()
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("Couldn't start the process %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	storePid(ServerPidPath, cmd.Process.Pid)
+	//the webservice doesn't answer
+	cli := makeServerCli(newPipelineTest(true), t)
+	overrideOutput(cli)
+	if err := cli.Run([]string{"server", "stop"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Errorf("The process wasn't stopped")
+	}
+	if _, _, err := readPid(ServerPidPath); !os.IsNotExist(err) {
+		t.Errorf("Pid file not removed %v", err)
+	}
+}
+
+func TestServerStartAlreadyRunning(t *testing.T) {
+	defer mockServerFiles(t)()
+	cli := makeServerCli(newPipelineTest(false), t)
+	w := overrideOutput(cli)
+	if err := cli.Run([]string{"server", "start"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(w.String(), "already running") {
+		t.Errorf("Wrong output %q", w.String())
+	}
+}
+
+func TestLauncherPidAndLog(t *testing.T) {
+	defer mockServerFiles(t)()
+	script := launchScript(t, filepath.Dir(ServerPidPath), "echo pipeline output\n")
+	launcher := NewPipelineLauncher(&MockPinger{maxCalls: 1}, script, 5)
+	if _, err := launcher.Launch(ioutil.Discard); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if pid, _, err := readPid(ServerPidPath); err != nil || pid <= 0 {
+		t.Errorf("Pid not stored %v %v", pid, err)
+	}
+	for i := 0; i < 100; i++ {
+		if data, _ := ioutil.ReadFile(ServerLogPath); strings.Contains(string(data), "pipeline output") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("The output of the webservice wasn't logged")
+}
@@ -3,7 +3,7 @@ package cli
 import (
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,14 +18,21 @@ const (
 	JAVA_OPTS = "JAVA_OPTS"
 	//Tell gogo shell to not expect for input
 	OH_MY_GOSH = "-Dgosh.args=--noi"
+	//Lines of the output shown when the pipeline fails to start
+	LAUNCH_TAIL_LINES = 10
+	//How often the launcher spinner is updated
+	SPINNER_TICK = 200 * time.Millisecond
 )
 
 //Holds the needed information to launch the pipeline
 type Launcher struct {
-	pinger Pinger                //An object to ask about the status of the ws
-	timeup int                   //The time up to wait for it
-	path   string                //Path to the pipeline executable
-	runner func(*exec.Cmd) error //A function to start the command (only modifiable for testing)
+	pinger  Pinger                //An object to ask about the status of the ws
+	timeup  int                   //The time up to wait for it
+	path    string                //Path to the pipeline executable
+	runner  func(*exec.Cmd) error //A function to start the command (only modifiable for testing)
+	logPath string                //File where the output of the pipeline is appended, discarded if empty
+	pidPath string                //File where the pid of the pipeline is stored, not stored if empty
+	java    string                //Description of the java found, shown when the pipeline exits
 }
 
 //Interface that allows to check if the ws is up
@@ -41,17 +48,19 @@ func execRunner(cmd *exec.Cmd) error {
 //Creates a new launcher
 func NewPipelineLauncher(p Pinger, path string, timeup int) Launcher {
 	return Launcher{
-		pinger: p,
-		timeup: timeup,
-		path:   path,
-		runner: execRunner,
+		pinger:  p,
+		timeup:  timeup,
+		path:    path,
+		runner:  execRunner,
+		logPath: ServerLogPath,
+		pidPath: ServerPidPath,
 	}
 }
 
 //Configures the command to be exected
 func (l Launcher) command() *exec.Cmd {
 	path := filepath.FromSlash(l.path)
-	log.Printf("command path %v\n", path)
+	logDebug("Command path %v", path)
 	cmd := exec.Command(path)
 	cmd.Env = os.Environ()
 	found := false
@@ -68,20 +77,27 @@ func (l Launcher) command() *exec.Cmd {
 	return cmd
 }
 
-//Waits for the pipeline
-func (l Launcher) wait(cAlive chan pipeline.Alive, tries chan int) {
-	log.Println("Calling alive")
+//Waits for the pipeline until it's up or done is closed
+func (l Launcher) wait(cAlive chan pipeline.Alive, tries chan int, done chan struct{}) {
+	logDebug("Calling alive")
 	triesCnt := 1
 	for {
 		alive, err := l.pinger.Alive()
 		if err != nil {
-			log.Printf("retrying...")
+			logDebug("Webservice not ready yet: %v", err)
 			time.Sleep(333 * time.Millisecond)
-			tries <- triesCnt
+			select {
+			case tries <- triesCnt:
+			case <-done:
+				return
+			}
 			triesCnt += 1
 		} else {
-			cAlive <- alive
-			break
+			select {
+			case cAlive <- alive:
+			case <-done:
+			}
+			return
 		}
 	}
 
@@ -89,38 +105,183 @@ func (l Launcher) wait(cAlive chan pipeline.Alive, tries chan int) {
 
 //Launches the pipeline writing the output messages to the supplied
 func (l Launcher) Launch(w io.Writer) (alive pipeline.Alive, err error) {
-	log.Println("Starting the fwk")
+	logInfo("Starting the webservice %v", l.path)
 	//launch the ws
 	cmd := l.command()
+	logStart := int64(0)
+	if l.logPath != "" {
+		var logFile *os.File
+		if logFile, err = os.OpenFile(l.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return
+		}
+		//the pipeline keeps its own descriptor
+		defer logFile.Close()
+		if info, err := logFile.Stat(); err == nil {
+			logStart = info.Size()
+		}
+		cmd.Stdout, cmd.Stderr = logFile, logFile
+	}
 	err = l.runner(cmd)
 	if err != nil {
-		log.Println("Error running the command")
+		logError("Could not run %v: %v", l.path, err)
 		return
 	}
+	//a pipeline that exits before answering won't come up
+	exited := make(chan error, 1)
+	if cmd.Process != nil {
+		if l.pidPath != "" {
+			if err := storePid(l.pidPath, cmd.Process.Pid); err != nil {
+				logWarn("Error storing the pid of the webservice: %v", err)
+			}
+		}
+		go func() {
+			exited <- cmd.Wait()
+		}()
+	}
 	//wait til it's up and running
+	start := time.Now()
 	timeOut := time.After(time.Duration(l.timeup) * time.Second)
+	ticker := time.NewTicker(SPINNER_TICK)
+	defer ticker.Stop()
+	spin := newSpinner(w, time.Duration(l.timeup)*time.Second)
+	defer spin.clear()
 	////communication
 	aliveChan := make(chan pipeline.Alive)
 	triesChan := make(chan int)
+	//stops the waiting when giving up
+	done := make(chan struct{})
+	defer close(done)
 	fmt.Fprintln(w, "Launching the pipeline webservice...")
-	go l.wait(aliveChan, triesChan)
+	go l.wait(aliveChan, triesChan, done)
 	for {
 		select {
 		case alive = <-aliveChan:
+			spin.clear()
 			fmt.Fprintln(w, "The webservice is UP!")
-			log.Println("The ws seems to be up")
+			logInfo("The webservice is up, version %v", alive.Version)
 			return
 		case tries := <-triesChan:
-			log.Printf("Trying to dial to the ws (%v)\n", tries)
+			logDebug("Trying to dial to the webservice (%v)", tries)
 		//keep on going
+		case exitErr := <-exited:
+			//launch scripts that leave the pipeline in the background exit successfully
+			if exitErr == nil {
+				logDebug("%v exited, waiting for the webservice", l.path)
+				continue
+			}
+			logWarn("The webservice exited after %v: %v", time.Since(start).Round(time.Millisecond), exitErr)
+			err = l.exitError(exitErr, logStart)
+			return
+		case <-ticker.C:
+			spin.update(time.Since(start))
 		case <-timeOut:
-			log.Println("launcher timed up")
-			err = fmt.Errorf("I have been waiting %v seconds for the WS to come up but it did not", l.timeup)
+			logWarn("Launcher timed out after %v seconds", l.timeup)
+			err = newError(ConnectionError, nil, "I have been waiting %v seconds for the webservice to come up but it did not%v", l.timeup, l.seeLog())
 			return
 		}
 	}
 }
 
+//Error of a pipeline that exited before being ready, with the last lines it wrote
+func (l Launcher) exitError(exitErr error, logStart int64) error {
+	tail := logTail(l.logPath, logStart, LAUNCH_TAIL_LINES)
+	if len(tail) == 0 {
+		return newError(ConnectionError, exitErr, "The webservice exited (%v) before it was ready%v%v", exitErr, l.javaUsed(), l.seeLog())
+	}
+	return newError(ConnectionError, exitErr, "The webservice exited (%v) before it was ready%v, its last messages were:\n\t\t%v\n\t%v",
+		exitErr, l.javaUsed(), strings.Join(tail, "\n\t\t"), strings.TrimPrefix(l.seeLog(), ", "))
+}
+
+func (l Launcher) javaUsed() string {
+	if l.java == "" {
+		return ""
+	}
+	return fmt.Sprintf(", the java found is %v", l.java)
+}
+
+func (l Launcher) seeLog() string {
+	if l.logPath == "" {
+		return ""
+	}
+	return fmt.Sprintf(", see %v", l.logPath)
+}
+
+//Returns the last lines written to the file after offset
+func logTail(path string, offset int64, lines int) []string {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil
+	}
+	all := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+	if len(all) == 1 && all[0] == "" {
+		return nil
+	}
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return all
+}
+
+//Shows how long the launcher has been waiting for the webservice, only in
+//terminals where the log isn't being written
+type spinner struct {
+	w      io.Writer
+	timeup time.Duration
+	frame  int
+}
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+//Returns nil, which does nothing, if the spinner can't be shown
+func newSpinner(w io.Writer, timeup time.Duration) *spinner {
+	if !isTerminal(w) || logs.toTerminal() {
+		return nil
+	}
+	return &spinner{w: w, timeup: timeup}
+}
+
+func (s *spinner) update(elapsed time.Duration) {
+	if s == nil {
+		return
+	}
+	elapsed = elapsed.Truncate(time.Second)
+	left := s.timeup - elapsed
+	if left < 0 {
+		left = 0
+	}
+	fmt.Fprintf(s.w, "\r%v Waiting for the webservice: %v elapsed, %v left\033[K", spinnerFrames[s.frame%len(spinnerFrames)], elapsed, left)
+	s.frame++
+}
+
+func (s *spinner) clear() {
+	if s == nil || s.frame == 0 {
+		return
+	}
+	fmt.Fprint(s.w, "\r\033[K")
+	s.frame = 0
+}
+
+//Whether the writer is a terminal
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 //Appends the gogo shell ignore input directive
 //to the JAVA_OPS
 func appendOpts(javaOptsVar string) string {
@@ -3,10 +3,14 @@ package cli
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/daisy/pipeline-clientlib-go"
 )
@@ -132,7 +136,7 @@ func TestWait(t *testing.T) {
 	launcher := NewPipelineLauncher(pinger, "pipeline2", 10)
 	chAlive := make(chan pipeline.Alive)
 	chTries := make(chan int)
-	go launcher.wait(chAlive, chTries)
+	go launcher.wait(chAlive, chTries, make(chan struct{}))
 
 	<-chTries
 	nTries := <-chTries
@@ -147,6 +151,23 @@ func TestWait(t *testing.T) {
 
 }
 
+//Tests that wait stops once nobody waits for the pipeline
+func TestWaitDone(t *testing.T) {
+	launcher := NewPipelineLauncher(&MockPinger{err: true}, "pipeline2", 10)
+	done := make(chan struct{})
+	finished := make(chan bool)
+	go func() {
+		launcher.wait(make(chan pipeline.Alive), make(chan int), done)
+		finished <- true
+	}()
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Errorf("wait didn't stop")
+	}
+}
+
 //Tests the launch method when calling the pipeline2 fails
 func TestLauncherCmdFail(t *testing.T) {
 	pinger := &MockPinger{err: false, maxCalls: 2}
@@ -198,3 +219,91 @@ func TestLauncherTimeUp(t *testing.T) {
 	}
 
 }
+
+//Writes an executable shell script into the directory
+func launchScript(t *testing.T, dir, content string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("no shell scripts")
+	}
+	script := filepath.Join(dir, "pipeline2")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\n"+content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+//Tests that the launcher gives up as soon as the pipeline exits with an error
+func TestLauncherExitsEarly(t *testing.T) {
+	defer mockServerFiles(t)()
+	ioutil.WriteFile(ServerLogPath, []byte("output of a previous launch\n"), 0644)
+	script := launchScript(t, filepath.Dir(ServerLogPath), "echo starting\necho 'UnsupportedClassVersionError' >&2\nexit 1\n")
+	launcher := NewPipelineLauncher(&MockPinger{err: true}, script, 20)
+	launcher.java = `java version "1.8.0_45"`
+	start := time.Now()
+	_, err := launcher.Launch(ioutil.Discard)
+	if err == nil {
+		t.Fatalf("Expected error not returned")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("The launcher waited until timing out")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "exit status 1") || !strings.Contains(msg, "starting\n\t\tUnsupportedClassVersionError") || !strings.Contains(msg, `the java found is java version "1.8.0_45"`) {
+		t.Errorf("The output isn't shown %q", msg)
+	}
+	if strings.Contains(msg, "previous launch") {
+		t.Errorf("Output of a previous launch shown %q", msg)
+	}
+	if ExitCode(err) != 3 || !strings.Contains(ErrorMessage(launchError(err), false), "UnsupportedClassVersionError") {
+		t.Errorf("Wrong error %v", err)
+	}
+}
+
+//Launch scripts that exit successfully may leave the pipeline in the background
+func TestLauncherExitsSuccessfully(t *testing.T) {
+	defer mockServerFiles(t)()
+	script := launchScript(t, filepath.Dir(ServerLogPath), "exit 0\n")
+	launcher := NewPipelineLauncher(&MockPinger{maxCalls: 3}, script, 20)
+	alive, err := launcher.Launch(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if alive.Version != "test" {
+		t.Errorf("Alive doesn't seem to be correct %+v", alive)
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	defer mockServerFiles(t)()
+	ioutil.WriteFile(ServerLogPath, []byte("old\n1\n2\n3\n"), 0644)
+	if tail := logTail(ServerLogPath, 4, 2); len(tail) != 2 || tail[0] != "2" || tail[1] != "3" {
+		t.Errorf("Wrong tail %q", tail)
+	}
+	if tail := logTail(ServerLogPath, 12, 2); len(tail) != 0 {
+		t.Errorf("Nothing was written %q", tail)
+	}
+	if tail := logTail("", 0, 2); len(tail) != 0 {
+		t.Errorf("No log file %q", tail)
+	}
+}
+
+func TestSpinner(t *testing.T) {
+	if newSpinner(new(bytes.Buffer), time.Second) != nil {
+		t.Errorf("Spinner shown in a file")
+	}
+	buf := new(bytes.Buffer)
+	spin := &spinner{w: buf, timeup: 25 * time.Second}
+	spin.update(3500 * time.Millisecond)
+	spin.update(30 * time.Second)
+	spin.clear()
+	out := buf.String()
+	if !strings.Contains(out, "| Waiting for the webservice: 3s elapsed, 22s left") || !strings.Contains(out, "/ Waiting for the webservice: 30s elapsed, 0s left") {
+		t.Errorf("Wrong spinner %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\033[K") {
+		t.Errorf("Spinner not cleared %q", out)
+	}
+	var none *spinner
+	none.update(time.Second)
+	none.clear()
+}
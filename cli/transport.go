@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	RETRY_BACKOFF     = 500 * time.Millisecond //waiting time before the first retry, doubled in every retry
+	RETRY_BACKOFF_MAX = 30 * time.Second       //maximum waiting time between retries, also for Retry-After
+)
+
+//Transport used by every webservice call. The client library builds its http
+//clients with the default transport, so it's replaced by this one (see installTransport).
+//The timeout is applied to the connection and to the wait for the response headers
+//of every request, the idempotent requests (GET and HEAD) are retried when they fail
+//because of the network or the server is temporarily unavailable
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int                 //number of retries after the first attempt
+	backoff time.Duration       //waiting time before the first retry
+	sleep   func(time.Duration) //waits between attempts, replaced in the tests
+	sign    func(string) string //signs again the url of an authenticated request
+}
+
+//Creates the transport on top of base for the timeout (in seconds) and number of retries
+func newRetryTransport(base http.RoundTripper, timeout, retries int) *retryTransport {
+	if installed, ok := base.(*retryTransport); ok {
+		base = installed.base
+	}
+	if traced, ok := base.(traceTransport); ok {
+		base = traced.base
+	}
+	if t, ok := base.(*http.Transport); ok {
+		t = t.Clone()
+		if timeout > 0 {
+			dialer := &net.Dialer{Timeout: time.Duration(timeout) * time.Second, KeepAlive: 30 * time.Second}
+			t.DialContext = dialer.DialContext
+			t.ResponseHeaderTimeout = time.Duration(timeout) * time.Second
+		}
+		base = t
+	}
+	return &retryTransport{
+		base:    traceTransport{base},
+		retries: retries,
+		backoff: RETRY_BACKOFF,
+		sleep:   time.Sleep,
+	}
+}
+
+//Replaces the default http transport with one configured after the link
+//configuration, returns it so the credentials can be set
+func installTransport(conf Config) *retryTransport {
+	t := newRetryTransport(http.DefaultTransport, configInt(conf, TIMEOUT), configInt(conf, RETRIES))
+	http.DefaultTransport = t
+	return t
+}
+
+//Sets the credentials used to sign the retried requests, the server doesn't
+//accept the same signature twice
+func (t *retryTransport) setCredentials(key, secret string) {
+	t.sign = func(rawUrl string) string {
+		return signUrl(rawUrl, key, secret)
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return t.base.RoundTrip(req)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.retries || !retriable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		wait := t.wait(attempt, resp)
+		if err != nil {
+			logWarn("Request to %v failed (%v), retrying in %v", req.URL.Path, err, wait)
+		} else {
+			logWarn("Request to %v failed with status %v, retrying in %v", req.URL.Path, resp.StatusCode, wait)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		t.sleep(wait)
+		req = t.retryRequest(req)
+	}
+}
+
+//Returns a copy of the request ready to be sent again
+func (t *retryTransport) retryRequest(req *http.Request) *http.Request {
+	if t.sign == nil || req.URL.Query().Get("sign") == "" {
+		return req
+	}
+	u, err := url.Parse(t.sign(req.URL.String()))
+	if err != nil {
+		return req
+	}
+	retry := req.Clone(req.Context())
+	retry.URL = u
+	return retry
+}
+
+//Exponential backoff with jitter, unless the server says how long to wait
+func (t *retryTransport) wait(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if after > RETRY_BACKOFF_MAX {
+				return RETRY_BACKOFF_MAX
+			}
+			return after
+		}
+	}
+	wait := t.backoff << uint(attempt)
+	if wait > RETRY_BACKOFF_MAX || wait <= 0 {
+		wait = RETRY_BACKOFF_MAX
+	}
+	//between half and the whole wait
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+//Whether the request may succeed if sent again: network errors (except when
+//nobody is listening) and the statuses of a busy or restarting server
+func retriable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, syscall.ECONNREFUSED)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+//Parses the Retry-After header, given in seconds or as an http date
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+//Signs the url as the client library does, replacing the previous signature if any
+func signUrl(rawUrl, key, secret string) string {
+	uri := rawUrl
+	if idx := strings.Index(uri, "authid="); idx > 0 {
+		uri = uri[:idx-1]
+	}
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+	nonce := fmt.Sprintf("%030d", rand.Int63())
+	uri = fmt.Sprintf("%v%vauthid=%v&time=%v&nonce=%v", uri, separator, key, timestamp, nonce)
+	hasher := hmac.New(sha1.New, []byte(secret))
+	hasher.Write([]byte(uri))
+	return uri + "&sign=" + url.QueryEscape(base64.StdEncoding.EncodeToString(hasher.Sum(nil)))
+}
+
+//Returns the integer value of the configuration key or 0 if it's not set
+func configInt(conf Config, key string) int {
+	value, _ := conf[key].(int)
+	return value
+}
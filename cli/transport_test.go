@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/daisy/pipeline-clientlib-go"
+)
+
+//Server that answers with the given statuses in order, the last one is repeated
+func statusServer(statuses ...int) (*httptest.Server, *int) {
+	calls := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[len(statuses)-1]
+		if *calls < len(statuses) {
+			status = statuses[*calls]
+		}
+		*calls++
+		if status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "2")
+		}
+		w.WriteHeader(status)
+	}))
+	return server, calls
+}
+
+func testTransport(retries int) (*retryTransport, *[]time.Duration) {
+	waits := &[]time.Duration{}
+	t := newRetryTransport(http.DefaultTransport, 1, retries)
+	t.sleep = func(d time.Duration) {
+		*waits = append(*waits, d)
+	}
+	return t, waits
+}
+
+func TestRetryTransport(t *testing.T) {
+	server, calls := statusServer(http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+	transport, waits := testTransport(3)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || *calls != 3 {
+		t.Errorf("Request not retried %v (%v calls)", resp.StatusCode, *calls)
+	}
+	if len(*waits) != 2 {
+		t.Fatalf("Wrong waits %v", *waits)
+	}
+	if (*waits)[0] < RETRY_BACKOFF/2 || (*waits)[0] > RETRY_BACKOFF {
+		t.Errorf("Wrong backoff %v", (*waits)[0])
+	}
+	if (*waits)[1] != 2*time.Second {
+		t.Errorf("Retry-After not honoured %v", (*waits)[1])
+	}
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	server, calls := statusServer(http.StatusBadGateway)
+	defer server.Close()
+	transport, _ := testTransport(2)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway || *calls != 3 {
+		t.Errorf("Wrong retries %v (%v calls)", resp.StatusCode, *calls)
+	}
+}
+
+func TestRetryTransportNotIdempotent(t *testing.T) {
+	server, calls := statusServer(http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+	transport, _ := testTransport(3)
+	resp, err := (&http.Client{Transport: transport}).Post(server.URL, "application/xml", strings.NewReader("<job/>"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || *calls != 1 {
+		t.Errorf("POST requests shouldn't be retried %v (%v calls)", resp.StatusCode, *calls)
+	}
+	server, calls = statusServer(http.StatusNotFound, http.StatusOK)
+	defer server.Close()
+	if resp, _ := (&http.Client{Transport: transport}).Get(server.URL); resp.StatusCode != http.StatusNotFound || *calls != 1 {
+		t.Errorf("Client errors shouldn't be retried %v (%v calls)", resp.StatusCode, *calls)
+	}
+}
+
+func TestRetryTransportTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+	}))
+	defer server.Close()
+	transport, waits := testTransport(0)
+	if _, err := (&http.Client{Transport: transport}).Get(server.URL); err == nil {
+		t.Errorf("The timeout wasn't applied")
+	}
+	if len(*waits) != 0 {
+		t.Errorf("Retried without retries configured")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2020 10:00:30 GMT": 30 * time.Second,
+		"Wed, 01 Jan 2020 09:00:00 GMT": 0,
+	}
+	for value, exp := range tests {
+		if wait, ok := retryAfter(value, now); !ok || wait != exp {
+			t.Errorf("%v: %v != %v", value, wait, exp)
+		}
+	}
+	for _, value := range []string{"", "soon", "-1"} {
+		if _, ok := retryAfter(value, now); ok {
+			t.Errorf("%q should be ignored", value)
+		}
+	}
+}
+
+func TestSignUrl(t *testing.T) {
+	signed := signUrl("http://localhost:8181/ws/jobs?msgSeq=1&authid=old&time=x&nonce=y&sign=z", "key", "secret")
+	if strings.Count(signed, "authid=") != 1 || strings.Contains(signed, "old") {
+		t.Fatalf("Previous signature kept %v", signed)
+	}
+	idx := strings.Index(signed, "&sign=")
+	hasher := hmac.New(sha1.New, []byte("secret"))
+	hasher.Write([]byte(signed[:idx]))
+	sign, _ := url.QueryUnescape(signed[idx+len("&sign="):])
+	if sign != base64.StdEncoding.EncodeToString(hasher.Sum(nil)) {
+		t.Errorf("Wrong signature %v", signed)
+	}
+	if !strings.HasPrefix(signed, "http://localhost:8181/ws/jobs?msgSeq=1&authid=key&") {
+		t.Errorf("Wrong url %v", signed)
+	}
+}
+
+func TestAsyncMessagesToleratesFailures(t *testing.T) {
+	reset := &url.Error{Op: "Get", URL: "http://localhost:8181/ws/jobs/jobId", Err: syscall.ECONNRESET}
+	run := func(tolerated int, failure error) (msgs []Message) {
+		failures := 0
+		pipe := newPipelineTest(false)
+		pipe.job = func(string, int) (pipeline.Job, error) {
+			if failures < 2 {
+				failures++
+				return pipeline.Job{}, failure
+			}
+			return pipeline.Job{Status: "SUCCESS"}, nil
+		}
+		link := PipelineLink{pipeline: pipe, config: Config{POLLFAILURES: tolerated}}
+		chMsg := make(chan Message)
+		go getAsyncMessages(context.Background(), link, "jobId", chMsg)
+		for msg := range chMsg {
+			msgs = append(msgs, msg)
+		}
+		return
+	}
+	for _, failure := range []error{reset, fmt.Errorf(pipeline.ERR_500, "busy"), fmt.Errorf(pipeline.ERR_DEFAULT, 503)} {
+		msgs := run(2, failure)
+		if last := msgs[len(msgs)-1]; last.Error != nil || last.Status != "SUCCESS" {
+			t.Errorf("Failures not tolerated (%v) %+v", failure, msgs)
+		}
+	}
+	msgs := run(1, reset)
+	if len(msgs) != 1 || msgs[0].Error == nil {
+		t.Errorf("Too many failures tolerated %+v", msgs)
+	}
+	//the job is gone or the credentials are wrong, there is no point in asking again
+	for _, failure := range []error{fmt.Errorf(pipeline.ERR_404, "/jobs/jobId"), errors.New(pipeline.ERR_401)} {
+		msgs := run(2, failure)
+		if len(msgs) != 1 || msgs[0].Error != failure {
+			t.Errorf("Failure tolerated (%v) %+v", failure, msgs)
+		}
+	}
+}
@@ -2,17 +2,19 @@ package cli
 
 import (
 	"archive/zip"
-	"bytes"
-	"errors"
+	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	re "regexp"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bertfrees/go-subcommand"
 )
@@ -27,6 +29,14 @@ var homePath = func() string {
 	return os.Getenv("HOME")
 }
 
+//Asks a yes/no question to the user, anything but y or yes is a no
+var askYesNo = func(w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%v [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 //Checks that a string defines a priority value
 func checkPriority(priority string) bool {
 
@@ -35,31 +45,40 @@ func checkPriority(priority string) bool {
 
 }
 
-//loads the halt key
+//loads the halt key, written by the webservice into the temp dir
 func loadKey() (key string, err error) {
-	//get temp dir
 	path := filepath.Join(os.TempDir(), keyFile)
-	file, err := os.Open(path)
+	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		errors.New("Could not find the key file, is the webservice running in this machine?")
-	}
-	bytes, err := ioutil.ReadAll(file)
-	if err != nil {
-		return
+		return "", newError(NotFoundError, err, "Could not read the key file %v, is the webservice running in this machine?", path)
 	}
-	key = string(bytes)
-	return
+	return string(bytes), nil
+}
+
+//How the job id is selected when it's not given as parameter
+type idSelector struct {
+	lastId     bool   //the id stored in the lastid file
+	last       int    //the nth most recent job of the history
+	lastScript string //the most recent job of the script in the history
+}
+
+func (s idSelector) selected() bool {
+	return s.lastId || s.last > 0 || s.lastScript != ""
 }
 
 //Checks if the job id is present when the command was called
-func checkId(lastId bool, command string, args ...string) (id string, err error) {
-	if len(args) != 1 && !lastId {
-		return id, fmt.Errorf("Command %v needs a job id", command)
+func checkId(sel idSelector, command string, args ...string) (id string, err error) {
+	if len(args) != 1 && !sel.selected() {
+		return id, newError(UsageError, nil, "Command %v needs a job id", command)
 	}
 	//got it from file
-	if lastId {
+	if sel.lastId {
 		id, err = getLastId()
 		return
+	} else if sel.last > 0 {
+		return lastHistoryId(HistoryPath, sel.last)
+	} else if sel.lastScript != "" {
+		return lastScriptId(HistoryPath, sel.lastScript)
 	} else {
 		//first arg otherwise
 		id = args[0]
@@ -67,10 +86,22 @@ func checkId(lastId bool, command string, args ...string) (id string, err error)
 	}
 }
 
-//Adds the last id switch to the command
-func addLastId(cmd *subcommand.Command, lastId *bool) {
+//Adds the last id switch and the history options to the command
+func addLastId(cmd *subcommand.Command, sel *idSelector) {
 	cmd.AddSwitch("lastid", "l", "Get id from the last executed job instead of JOB_ID", func(string, string) error {
-		*lastId = true
+		sel.lastId = true
+		return nil
+	})
+	cmd.AddOption("last", "", "Get id from the Nth most recent job of the history instead of JOB_ID", "", italic("N"), func(name, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("--last: %v is not a positive number", value)
+		}
+		sel.last = n
+		return nil
+	})
+	cmd.AddOption("last-script", "", "Get id from the most recent job of the script in the history instead of JOB_ID", "", italic("SCRIPT"), func(name, value string) error {
+		sel.lastScript = value
 		return nil
 	})
 	cmd.SetArity(-1, "[JOB_ID]")
@@ -93,76 +124,400 @@ func mkdir(path string) error {
 	return nil
 }
 
+//Destination of the job results, if the download fails Abort removes
+//the partial data
+type resultsWriter interface {
+	io.WriteCloser
+	Abort() error
+}
+
+//Overwrite policies for existing files when storing the results
+const (
+	OVERWRITE_NEVER  = "never"
+	OVERWRITE_ALWAYS = "always"
+	OVERWRITE_NEWER  = "newer"
+)
+
+//How the results are stored in the output path
+type extractOptions struct {
+	overwrite    string //overwrite policy
+	requireEmpty bool   //fail if the output directory is not empty
+}
+
+var defaultExtractOptions = extractOptions{overwrite: OVERWRITE_ALWAYS}
+
+//Adds the --overwrite and --require-empty flags to the command
+func addExtractOptions(cmd *subcommand.Command, opts *extractOptions) {
+	cmd.AddOption("overwrite", "", "What to do with existing files: never, always or newer (default always)", "", "(never|always|newer)", func(name, policy string) error {
+		if policy != OVERWRITE_NEVER && policy != OVERWRITE_ALWAYS && policy != OVERWRITE_NEWER {
+			return fmt.Errorf("%s is not a valid overwrite policy. Allowed values are never, always and newer", policy)
+		}
+		opts.overwrite = policy
+		return nil
+	})
+	cmd.AddSwitch("require-empty", "", "Fail if the output directory exists and is not empty", func(string, string) error {
+		opts.requireEmpty = true
+		return nil
+	})
+}
+
+//ZipInflator spools the zip data to a temporary file next to the destination folder
+//and extracts it on Close, so the results are never held in memory
 type ZipInflator struct {
-	folder string
-	buff   *bytes.Buffer
+	folder    string
+	options   extractOptions
+	spool     *os.File
+	extracted []extractedFile
+}
+
+//File written by the extraction, backup is where the file it replaced was
+//moved, if any
+type extractedFile struct {
+	path   string
+	backup string
 }
 
 func NewZipInflator(folder string) *ZipInflator {
 	return &ZipInflator{
-		folder: folder,
-		buff:   bytes.NewBuffer([]byte{}),
+		folder:  folder,
+		options: defaultExtractOptions,
 	}
 
 }
 
-//Writes the  data to a intermediate buffer
+//Writes the data to the temporary file, which is created on the first write
 func (z *ZipInflator) Write(data []byte) (int, error) {
-	return z.buff.Write(data)
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if z.spool == nil {
+		parent := filepath.Dir(filepath.Clean(z.folder))
+		if err := mkdir(parent); err != nil {
+			return 0, err
+		}
+		spool, err := ioutil.TempFile(parent, ".dp2-results-")
+		if err != nil {
+			return 0, err
+		}
+		z.spool = spool
+	}
+	return z.spool.Write(data)
 }
 
-//
+//Extracts the zip file into the folder and removes the temporary file. If the extraction
+//fails the files already extracted are removed and the ones they replaced restored
 func (z *ZipInflator) Close() error {
-	l := int64(z.buff.Len())
-	//if  no data do not try to compress it
-	if l == 0 {
+	//if  no data do not try to uncompress it
+	if z.spool == nil {
+		return nil
+	}
+	defer z.removeSpool()
+	if err := z.spool.Close(); err != nil {
+		return err
+	}
+	if err := z.extract(); err != nil {
+		z.removeExtracted()
+		return err
+	}
+	z.removeBackups()
+	return nil
+}
+
+//Discards the downloaded data
+func (z *ZipInflator) Abort() error {
+	if z.spool == nil {
 		return nil
 	}
-	reader, err := zip.NewReader(bytes.NewReader(z.buff.Bytes()), l)
+	z.spool.Close()
+	return z.removeSpool()
+}
+
+func (z *ZipInflator) removeSpool() error {
+	return os.Remove(z.spool.Name())
+}
+
+func (z *ZipInflator) removeExtracted() {
+	for idx := len(z.extracted) - 1; idx >= 0; idx-- {
+		file := z.extracted[idx]
+		os.Remove(file.path)
+		if file.backup != "" {
+			if err := os.Rename(file.backup, file.path); err != nil {
+				logWarn("Could not restore %v, the original is in %v", file.path, file.backup)
+			}
+		}
+	}
+}
+
+func (z *ZipInflator) removeBackups() {
+	for _, file := range z.extracted {
+		if file.backup != "" {
+			os.Remove(file.backup)
+		}
+	}
+}
+
+//Moves the existing file out of the way so it can be restored if the
+//extraction fails
+func backupFile(path string) (string, error) {
+	if _, err := os.Lstat(path); err != nil {
+		return "", nil
+	}
+	backup := path + ".dp2-backup"
+	if err := os.Rename(path, backup); err != nil {
+		return "", fmt.Errorf("Could not replace %v: %v", path, err)
+	}
+	return backup, nil
+}
+
+//Returns the path where the entry will be extracted, entries with absolute paths,
+//escaping the folder or symlinks are rejected
+func entryPath(folder string, f *zip.File) (string, error) {
+	name := strings.Replace(f.Name, "\\", "/", -1)
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("Zip entry %v has an absolute path", f.Name)
+	}
+	if f.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("Zip entry %v is a symbolic link", f.Name)
+	}
+	dest := filepath.Join(folder, filepath.FromSlash(name))
+	outside := fmt.Errorf("Zip entry %v is outside the output directory", f.Name)
+	if !insideDir(filepath.Clean(folder), dest) {
+		return "", outside
+	}
+	//existing directories in the path may be symbolic links pointing elsewhere
+	if !insideDir(resolvePath(folder), resolvePath(filepath.Dir(dest))) {
+		return "", outside
+	}
+	return dest, nil
+}
+
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+//Resolves the symbolic links of the part of the path that exists
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path
+	}
+	return filepath.Join(resolvePath(parent), filepath.Base(path))
+}
+
+//Modification time stored in the zip entry
+func entryTime(f *zip.File) time.Time {
+	if !f.Modified.IsZero() {
+		return f.Modified
+	}
+	return f.ModTime()
+}
+
+//Checks that the output directory is empty if required
+func checkEmptyDir(folder string) error {
+	entries, err := ioutil.ReadDir(folder)
+	if err == nil && len(entries) > 0 {
+		return fmt.Errorf("The output directory %v is not empty", folder)
+	}
+	return nil
+}
+
+//Validates all the entries before extracting anything and returns the ones to extract
+//according to the overwrite policy along with their paths
+func (z *ZipInflator) plan(files []*zip.File) (entries []*zip.File, paths []string, err error) {
+	if z.options.requireEmpty {
+		if err := checkEmptyDir(z.folder); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, f := range files {
+		dest, err := entryPath(z.folder, f)
+		if err != nil {
+			return nil, nil, err
+		}
+		if info, err := os.Stat(dest); err == nil && !f.FileInfo().IsDir() {
+			switch z.options.overwrite {
+			case OVERWRITE_NEVER:
+				return nil, nil, fmt.Errorf("%v already exists, use --overwrite always or newer to replace it", dest)
+			case OVERWRITE_NEWER:
+				if !entryTime(f).After(info.ModTime()) {
+					logInfo("Keeping %v, it is newer than the result", dest)
+					continue
+				}
+			}
+		}
+		entries = append(entries, f)
+		paths = append(paths, dest)
+	}
+	return
+}
+
+func (z *ZipInflator) extract() error {
+	reader, err := zip.OpenReader(z.spool.Name())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	entries, paths, err := z.plan(reader.File)
 	if err != nil {
 		return err
 	}
 	// Iterate through the files in the archive,
 	//and store the results
-	for _, f := range reader.File {
-		//Get the path of the new file
-		path := filepath.Join(z.folder, filepath.Clean(f.Name))
+	for idx, f := range entries {
+		path := paths[idx]
+		if f.FileInfo().IsDir() {
+			if err := mkdir(path); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := mkdir(filepath.Dir(path)); err != nil {
 			return err
 		}
-
-		rc, err := f.Open()
+		backup, err := backupFile(path)
 		if err != nil {
 			return err
 		}
-
-		dest, err := os.Create(path)
-		if err != nil {
+		z.extracted = append(z.extracted, extractedFile{path, backup})
+		if err := extractEntry(f, path); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		if _, err = io.Copy(dest, rc); err != nil {
-			return err
-		}
+//Extracts the entry keeping its permissions and modification time
+func extractEntry(f *zip.File, path string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	perm := f.Mode().Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	dest, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dest, rc)
+	if cerr := dest.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	//OpenFile doesn't change the mode of existing files and umask applies to new ones
+	if err := os.Chmod(path, perm); err != nil {
+		return err
+	}
+	modified := entryTime(f)
+	return os.Chtimes(path, modified, modified)
+}
 
-		if err := dest.Close(); err != nil {
-			return err
-		}
+//Writes the zip file with a .part suffix and renames it on Close
+type partialFile struct {
+	*os.File
+	path string
+}
 
-		if err := rc.Close(); err != nil {
-			return err
-		}
+func newPartialFile(path string) (*partialFile, error) {
+	file, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, err
+	}
+	return &partialFile{file, path}, nil
+}
 
+func (p *partialFile) Close() error {
+	if err := p.File.Close(); err != nil {
+		p.Abort()
+		return err
 	}
-	return nil
+	return os.Rename(p.File.Name(), p.path)
+}
+
+func (p *partialFile) Abort() error {
+	p.File.Close()
+	return os.Remove(p.File.Name())
 }
 
-func zipProcessor(file string, asZip bool) (io.WriteCloser, error) {
+//Returns the writer that stores the results, the checks that don't depend
+//on the zip contents are done before anything is downloaded
+func zipProcessor(file string, asZip bool, opts extractOptions) (resultsWriter, error) {
 	if asZip {
-		return os.Create(file)
+		if _, err := os.Stat(file); err == nil && opts.overwrite == OVERWRITE_NEVER {
+			return nil, fmt.Errorf("%v already exists, use --overwrite always to replace it", file)
+		}
+		return newPartialFile(file)
 	} else {
-		return NewZipInflator(file), nil
+		if opts.requireEmpty {
+			if err := checkEmptyDir(file); err != nil {
+				return nil, err
+			}
+		}
+		return &ZipInflator{folder: file, options: opts}, nil
+	}
+}
+
+//Minimum time between progress updates
+const PROGRESS_INTERVAL = 200 * time.Millisecond
+
+//progressWriter reports the amount of data written and fails the writes
+//once an interrupt is received, so the download can be aborted
+type progressWriter struct {
+	w         io.Writer
+	out       io.Writer
+	interrupt chan os.Signal
+	written   int64
+	last      time.Time
+}
+
+func newProgressWriter(w, out io.Writer, interrupt chan os.Signal) *progressWriter {
+	return &progressWriter{w: w, out: out, interrupt: interrupt}
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	select {
+	case <-p.interrupt:
+		return 0, errInterrupted
+	default:
 	}
+	n, err := p.w.Write(data)
+	p.written += int64(n)
+	if time.Since(p.last) >= PROGRESS_INTERVAL {
+		p.last = time.Now()
+		fmt.Fprintf(p.out, "\rDownloading results: %v", humanSize(p.written))
+	}
+	return n, err
+}
+
+//Prints the final size
+func (p *progressWriter) done() {
+	if p.written > 0 {
+		fmt.Fprintf(p.out, "\rDownloading results: %v\n", humanSize(p.written))
+	}
+}
+
+//Formats the size in bytes using the largest unit that fits
+func humanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	idx := 0
+	for value >= 1024 && idx < len(units)-1 {
+		value /= 1024
+		idx++
+	}
+	if idx == 0 {
+		return fmt.Sprintf("%d %v", size, units[idx])
+	}
+	return fmt.Sprintf("%.1f %v", value, units[idx])
 }
 
 //gets the path for last id file
@@ -186,22 +541,33 @@ func getLastIdPath(currentOs string) string {
 	return path
 }
 
+//Minimum java version needed to run the webservice
+const MIN_JAVA_VERSION = 11
+
 func AssertJava(minJavaVersion int) error {
+	_, err := CheckJava(minJavaVersion)
+	return err
+}
+
+//Checks the version of the java used to run the pipeline, returns the first
+//line of java -version, which describes the java found
+func CheckJava(minJavaVersion int) (found string, err error) {
 	//get the output
 	output, err := javaVersionService()
 	if err != nil {
-		return err
+		return "", fmt.Errorf("Could not run java (%v), install java %v or greater or set JAVA_HOME", err, minJavaVersion)
 	}
+	found = strings.TrimSpace(strings.SplitN(strings.TrimSpace(output), "\n", 2)[0])
 	//parse the output
 	ver, err := parseVersion(output)
 	if err != nil {
-		return err
+		return found, err
 	}
 	//check with the min version
 	if ver < minJavaVersion {
-		return fmt.Errorf("A java version " + strconv.Itoa(minJavaVersion) + " or greater is need in order to run the pipeline")
+		return found, fmt.Errorf("A java version " + strconv.Itoa(minJavaVersion) + " or greater is need in order to run the pipeline, found " + found)
 	}
-	return nil
+	return found, nil
 }
 
 var javaVersionService = func() (string, error) {
@@ -214,8 +580,8 @@ var javaVersionService = func() (string, error) {
 		output, err := exec.Command("/usr/libexec/java_home").Output()
 		if len(output) == 0 {
 			javaHome = "/Library/Internet Plug-Ins/JavaAppletPlugin.plugin/Contents/Home/"
-		} else if err != nil {
-			javaHome = string(output)
+		} else if err == nil {
+			javaHome = strings.TrimSpace(string(output))
 		}
 	}
 	if javaHome != "" {
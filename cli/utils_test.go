@@ -4,10 +4,13 @@ import (
 	"archive/zip"
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 var files = []struct {
@@ -321,3 +324,316 @@ func TestAssertJava(t *testing.T) {
 	}
 
 }
+
+//Lists the temporary results files left in the folder
+func spoolFiles(t *testing.T, folder string) []string {
+	spools, err := filepath.Glob(filepath.Join(folder, ".dp2-results-*"))
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	return spools
+}
+
+func TestZipInflatorCleanup(t *testing.T) {
+	parent, err := ioutil.TempDir("", "dp2_inflator")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(parent)
+	folder := filepath.Join(parent, "results")
+
+	//bad zip data
+	zi := NewZipInflator(folder)
+	zi.Write([]byte("not a zip"))
+	if err := zi.Close(); err == nil {
+		t.Errorf("Expected error not thrown for bad zip data")
+	}
+	if spools := spoolFiles(t, parent); len(spools) != 0 {
+		t.Errorf("Temporary files not removed %v", spools)
+	}
+
+	//aborted download
+	zi = NewZipInflator(folder)
+	zi.Write(createZipFile(t))
+	if err := zi.Abort(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if spools := spoolFiles(t, parent); len(spools) != 0 {
+		t.Errorf("Temporary files not removed %v", spools)
+	}
+	if _, err := os.Stat(folder); !os.IsNotExist(err) {
+		t.Errorf("Nothing should have been extracted")
+	}
+}
+
+func TestPartialFile(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_partial")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	path := filepath.Join(folder, "results.zip")
+	pf, err := newPartialFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	pf.Write([]byte("data"))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("The file shouldn't exist until it's closed")
+	}
+	if err := pf.Close(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "data" {
+		t.Errorf("Data not stored %q", data)
+	}
+
+	pf, _ = newPartialFile(filepath.Join(folder, "aborted.zip"))
+	pf.Write([]byte("data"))
+	pf.Abort()
+	if entries, _ := ioutil.ReadDir(folder); len(entries) != 1 {
+		t.Errorf("Aborted file not removed %v", entries)
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var buf, out bytes.Buffer
+	interrupt := make(chan os.Signal, 1)
+	pw := newProgressWriter(&buf, &out, interrupt)
+	pw.Write(make([]byte, 2048))
+	pw.done()
+	if buf.Len() != 2048 || !strings.HasSuffix(out.String(), "Downloading results: 2.0 KB\n") {
+		t.Errorf("Wrong progress %q", out.String())
+	}
+	interrupt <- os.Interrupt
+	if _, err := pw.Write([]byte("more")); err != errInterrupted {
+		t.Errorf("Writes should fail after an interrupt %v", err)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := map[int64]string{
+		10:                     "10 B",
+		1536:                   "1.5 KB",
+		3 * 1024 * 1024:        "3.0 MB",
+		5 * 1024 * 1024 * 1024: "5.0 GB",
+	}
+	for size, exp := range tests {
+		if res := humanSize(size); res != exp {
+			t.Errorf("Wrong size for %v: %v expected %v", size, res, exp)
+		}
+	}
+}
+
+//zip entry used to build the test archives
+type zipEntry struct {
+	header zip.FileHeader
+	body   string
+}
+
+func buildZip(t *testing.T, entries []zipEntry) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, entry := range entries {
+		header := entry.header
+		f, err := w.CreateHeader(&header)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		f.Write([]byte(entry.body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return buf.Bytes()
+}
+
+func inflate(folder string, opts extractOptions, data []byte) error {
+	zi := &ZipInflator{folder: folder, options: opts}
+	zi.Write(data)
+	return zi.Close()
+}
+
+func TestZipInflatorRejectsUnsafeEntries(t *testing.T) {
+	parent, err := ioutil.TempDir("", "dp2_zipslip")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(parent)
+	folder := filepath.Join(parent, "results")
+	link := zip.FileHeader{Name: "link"}
+	link.SetMode(os.ModeSymlink | 0777)
+	tests := map[string]zipEntry{
+		"escaping":  zipEntry{zip.FileHeader{Name: "../evil.txt"}, "evil"},
+		"nested":    zipEntry{zip.FileHeader{Name: "sub/../../evil.txt"}, "evil"},
+		"backslash": zipEntry{zip.FileHeader{Name: "..\\evil.txt"}, "evil"},
+		"absolute":  zipEntry{zip.FileHeader{Name: "/tmp/evil.txt"}, "evil"},
+		"symlink":   zipEntry{link, "/etc/passwd"},
+	}
+	for name, entry := range tests {
+		data := buildZip(t, []zipEntry{zipEntry{zip.FileHeader{Name: "good.txt"}, "good"}, entry})
+		if err := inflate(folder, defaultExtractOptions, data); err == nil {
+			t.Errorf("Expected error not thrown for the %v entry", name)
+		}
+		if _, err := os.Stat(filepath.Join(folder, "good.txt")); !os.IsNotExist(err) {
+			t.Errorf("Nothing should be extracted when an entry is rejected (%v)", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "evil.txt")); !os.IsNotExist(err) {
+		t.Errorf("A file was written outside the output directory")
+	}
+}
+
+func TestZipInflatorOverwrite(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_overwrite")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	path := filepath.Join(folder, "result.txt")
+	old := time.Now().Add(-time.Hour).Round(time.Second)
+	header := zip.FileHeader{Name: "result.txt", Modified: old}
+	data := buildZip(t, []zipEntry{zipEntry{header, "new"}})
+	check := func(policy, exp string, fails bool) {
+		ioutil.WriteFile(path, []byte("existing"), 0644)
+		err := inflate(folder, extractOptions{overwrite: policy}, data)
+		if fails != (err != nil) {
+			t.Errorf("Policy %v: unexpected error value %v", policy, err)
+		}
+		if content, _ := ioutil.ReadFile(path); string(content) != exp {
+			t.Errorf("Policy %v: wrong content %q", policy, content)
+		}
+	}
+	check(OVERWRITE_NEVER, "existing", true)
+	//the existing file is newer than the result
+	check(OVERWRITE_NEWER, "existing", false)
+	check(OVERWRITE_ALWAYS, "new", false)
+	//the existing file is older than the result
+	ioutil.WriteFile(path, []byte("existing"), 0644)
+	os.Chtimes(path, old.Add(-time.Hour), old.Add(-time.Hour))
+	if err := inflate(folder, extractOptions{overwrite: OVERWRITE_NEWER}, data); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "new" {
+		t.Errorf("Older file not replaced %q", content)
+	}
+	if err := inflate(folder, extractOptions{overwrite: OVERWRITE_ALWAYS, requireEmpty: true}, data); err == nil {
+		t.Errorf("Expected error not thrown for a non empty directory")
+	}
+}
+
+func TestZipInflatorRestoresOverwritten(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_restore")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	path := filepath.Join(folder, "result.txt")
+	ioutil.WriteFile(path, []byte("existing"), 0644)
+	//sub is a file so the second entry can't be extracted
+	ioutil.WriteFile(filepath.Join(folder, "sub"), []byte("file"), 0644)
+	data := buildZip(t, []zipEntry{
+		zipEntry{zip.FileHeader{Name: "new.txt"}, "new"},
+		zipEntry{zip.FileHeader{Name: "result.txt"}, "new"},
+		zipEntry{zip.FileHeader{Name: "sub/result.txt"}, "new"},
+	})
+	if err := inflate(folder, defaultExtractOptions, data); err == nil {
+		t.Errorf("Expected error not thrown")
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "existing" {
+		t.Errorf("The overwritten file wasn't restored %q", content)
+	}
+	if entries, _ := ioutil.ReadDir(folder); len(entries) != 2 {
+		t.Errorf("Wrong files left %v", entries)
+	}
+	//no backups are left after a successful extraction
+	data = buildZip(t, []zipEntry{zipEntry{zip.FileHeader{Name: "result.txt"}, "new"}})
+	if err := inflate(folder, defaultExtractOptions, data); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(folder); len(entries) != 2 {
+		t.Errorf("Wrong files left %v", entries)
+	}
+}
+
+func TestZipInflatorRejectsSymlinkedDirs(t *testing.T) {
+	parent, err := ioutil.TempDir("", "dp2_symlink")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(parent)
+	folder := filepath.Join(parent, "results")
+	outside := filepath.Join(parent, "outside")
+	os.Mkdir(folder, 0755)
+	os.Mkdir(outside, 0755)
+	if err := os.Symlink(outside, filepath.Join(folder, "link")); err != nil {
+		t.Skipf("Symbolic links not supported %v", err)
+	}
+	data := buildZip(t, []zipEntry{zipEntry{zip.FileHeader{Name: "link/evil.txt"}, "evil"}})
+	if err := inflate(folder, defaultExtractOptions, data); err == nil {
+		t.Errorf("Expected error not thrown for an entry under a symbolic link")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil.txt")); !os.IsNotExist(err) {
+		t.Errorf("A file was written outside the output directory")
+	}
+	//links inside the output directory are fine
+	os.Mkdir(filepath.Join(folder, "sub"), 0755)
+	os.Symlink(filepath.Join(folder, "sub"), filepath.Join(folder, "inner"))
+	data = buildZip(t, []zipEntry{zipEntry{zip.FileHeader{Name: "inner/good.txt"}, "good"}})
+	if err := inflate(folder, defaultExtractOptions, data); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestZipInflatorModes(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dp2_modes")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(folder)
+	modified := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	script := zip.FileHeader{Name: "bin/run.sh", Modified: modified}
+	script.SetMode(0755)
+	dir := zip.FileHeader{Name: "empty/"}
+	dir.SetMode(os.ModeDir | 0755)
+	data := buildZip(t, []zipEntry{zipEntry{dir, ""}, zipEntry{script, "#!/bin/sh"}})
+	if err := inflate(folder, defaultExtractOptions, data); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	info, err := os.Stat(filepath.Join(folder, "bin", "run.sh"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0755 {
+		t.Errorf("Mode not preserved %v", info.Mode())
+	}
+	if !info.ModTime().Equal(modified) {
+		t.Errorf("Modification time not preserved %v", info.ModTime())
+	}
+	if info, err := os.Stat(filepath.Join(folder, "empty")); err != nil || !info.IsDir() {
+		t.Errorf("Directory entry not created (%v)", err)
+	}
+}
+
+func TestCheckJava(t *testing.T) {
+	back := javaVersionService
+	defer func() {
+		javaVersionService = back
+	}()
+	javaVersionService = func() (string, error) {
+		return fmt.Sprintf(OpenJdkVersionUbuntu, "\"1.8.0_45-internal\""), nil
+	}
+	found, err := CheckJava(11)
+	if err == nil || !strings.Contains(err.Error(), found) {
+		t.Errorf("The java found isn't reported %v", err)
+	}
+	if !strings.HasPrefix(found, "openjdk version \"1.8.0_45-internal\"") {
+		t.Errorf("Wrong java found %q", found)
+	}
+	javaVersionService = func() (string, error) {
+		return "", fmt.Errorf("exec: \"java\": executable file not found in $PATH")
+	}
+	if _, err := CheckJava(11); err == nil || !strings.Contains(err.Error(), "JAVA_HOME") {
+		t.Errorf("Wrong error %v", err)
+	}
+}
@@ -8,29 +8,14 @@ import (
 	"github.com/daisy/pipeline-cli-go/cli"
 )
 
-var minJavaVersion = 11
-
 func main() {
 	log.SetFlags(log.Lshortfile)
 	cnf := cli.NewConfig()
-	/*if cnf[cli.STARTING].(bool) {
-		println("Checking java")
-		if err := cli.AssertJava(minJavaVersion); err != nil {
-			fmt.Printf(
-				"Java version error:\n\tPlease make sure that java is accessible and the version is equal or greater than %v\n\tError: %s\n",
-				minJavaVersion,
-				err.Error(),
-			)
-			os.Exit(-1)
-		}
-	}*/
-	// proper error handlign missing
-
 	link := cli.NewLink(cnf)
 	comm, err := cli.NewCli("dp2", link)
 
 	if err != nil {
-		fmt.Printf("Error creating client:\n\t%v\n", err)
+		fmt.Fprintf(os.Stderr, "Error creating client:\n\t%v\n", err)
 		os.Exit(-1)
 	}
 
@@ -39,24 +24,39 @@ func main() {
 	cli.AddResultsCommand(comm, *link)
 	cli.AddJobsCommand(comm, *link)
 	cli.AddLogCommand(comm, *link)
+	cli.AddWatchCommand(comm, *link)
 	cli.AddQueueCommand(comm, *link)
 	cli.AddMoveUpCommand(comm, *link)
 	cli.AddMoveDownCommand(comm, *link)
 	cli.AddCleanCommand(comm, *link)
 	cli.AddHaltCommand(comm, *link)
 	cli.AddVersionCommand(comm, link)
+	cli.AddConfigCommand(comm, link)
+	cli.AddLoginCommand(comm, link)
+	cli.AddBatchCommand(comm, link)
+	cli.AddRunCommand(comm, link)
+	cli.AddRequestCommand(comm, link)
+	cli.AddScriptsCommand(comm, link)
+	cli.AddDescribeCommand(comm, link)
+	cli.AddHistoryCommand(comm, link)
+	cli.AddRerunCommand(comm, link)
+	cli.AddCompletionCommand(comm, link)
+	cli.AddServerCommand(comm, link)
+	cli.AddDoctorCommand(comm, link)
 	//admin commands
 	comm.AddClientListCommand(*link)
 	comm.AddNewClientCommand(*link)
 	comm.AddDeleteClientCommand(*link)
 	comm.AddModifyClientCommand(*link)
+	comm.AddRotateSecretCommand(*link)
 	comm.AddClientCommand(*link)
+	comm.AddClientsCommand(*link)
 	comm.AddPropertyListCommand(*link)
 	comm.AddSizesCommand(*link)
 
 	err = comm.Run(os.Args[1:])
 	if err != nil {
-		fmt.Printf("Error:\n\t%v\n", err)
-		os.Exit(-1)
+		fmt.Fprintf(os.Stderr, "Error:\n\t%v\n", cli.ErrorMessage(err, cnf[cli.DEBUG].(bool)))
+		os.Exit(cli.ExitCode(err))
 	}
 }